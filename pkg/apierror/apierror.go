@@ -0,0 +1,180 @@
+// Package apierror defines the JSON error envelope shared by all services.
+//
+// Every error response has the shape
+//
+//	{"error": {"code": "not_found", "message": "Habit not found", "request_id": "..."}}
+//
+// where code is a stable machine-readable identifier and message is meant for
+// humans. Validation failures additionally carry per-field details.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"habit-tracker/pkg/requestid"
+)
+
+// Code is a stable, machine-readable error identifier.
+type Code string
+
+const (
+	CodeBadRequest          Code = "bad_request"
+	CodeInvalidJSON         Code = "invalid_json"
+	CodePayloadTooLarge     Code = "payload_too_large"
+	CodeValidationFailed    Code = "validation_failed"
+	CodeUnauthorized        Code = "unauthorized"
+	CodeInvalidCredentials  Code = "invalid_credentials"
+	CodeInvalidToken        Code = "invalid_token"
+	CodeForbidden           Code = "forbidden"
+	CodeNotFound            Code = "not_found"
+	CodeMethodNotAllowed    Code = "method_not_allowed"
+	CodeConflict            Code = "conflict"
+	CodeUsernameTaken       Code = "username_taken"
+	CodeEmailTaken          Code = "email_taken"
+	CodeRateLimited         Code = "rate_limited"
+	CodeAccountLocked       Code = "account_locked"
+	CodeAccountDisabled     Code = "account_disabled"
+	CodeUpstreamUnavailable Code = "upstream_unavailable"
+	CodeInternal            Code = "internal_error"
+)
+
+// FieldError describes a problem with a single request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is an error that knows how to render itself as an HTTP response.
+type Error struct {
+	Status    int          `json:"-"`
+	Code      Code         `json:"code"`
+	Message   string       `json:"message"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+
+	cause error
+}
+
+type envelope struct {
+	Error *Error `json:"error"`
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// New creates an error with the given status, code and client-facing message.
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// WithCause returns a copy of e that records err for logging. The cause is
+// never sent to the client.
+func (e *Error) WithCause(err error) *Error {
+	c := *e
+	c.cause = err
+	return &c
+}
+
+// WithDetails returns a copy of e with field-level details attached.
+func (e *Error) WithDetails(details ...FieldError) *Error {
+	c := *e
+	c.Details = append(append([]FieldError(nil), e.Details...), details...)
+	return &c
+}
+
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+func InvalidJSON(err error) *Error {
+	return New(http.StatusBadRequest, CodeInvalidJSON, "Invalid request body").WithCause(err)
+}
+
+func Validation(details ...FieldError) *Error {
+	return New(http.StatusBadRequest, CodeValidationFailed, "Request validation failed").WithDetails(details...)
+}
+
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+func MethodNotAllowed() *Error {
+	return New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+}
+
+func Conflict(code Code, message string) *Error {
+	return New(http.StatusConflict, code, message)
+}
+
+func Upstream(message string, err error) *Error {
+	return New(http.StatusBadGateway, CodeUpstreamUnavailable, message).WithCause(err)
+}
+
+func Internal(err error) *Error {
+	return New(http.StatusInternalServerError, CodeInternal, "Internal server error").WithCause(err)
+}
+
+// Write renders err as a JSON error envelope. Errors that are not *Error are
+// reported as a generic 500 so driver and library messages never reach the
+// client.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		apiErr = Internal(err)
+	}
+
+	resp := *apiErr
+	resp.RequestID = requestid.FromContext(r.Context())
+	if resp.Status == 0 {
+		resp.Status = http.StatusInternalServerError
+	}
+
+	if resp.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "request failed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"code", resp.Code,
+			"error", apiErr.Error(),
+		)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(resp.Status)
+	json.NewEncoder(w).Encode(envelope{Error: &resp})
+}
+
+// NotFoundHandler answers every request with a not_found error.
+func NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, NotFound("Resource not found"))
+	})
+}
+
+// MethodNotAllowedHandler answers every request with a method_not_allowed error.
+func MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, MethodNotAllowed())
+	})
+}
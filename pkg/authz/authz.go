@@ -0,0 +1,103 @@
+// Package authz carries the authenticated principal through the request
+// context and enforces role requirements. Each service supplies its own
+// Authenticator; the middleware is shared.
+package authz
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/middleware"
+)
+
+// Roles understood by every service.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Scopes limit what an API key may do. Session logins carry ScopeFull.
+const (
+	ScopeFull  = "full"
+	ScopeRead  = "read"
+	ScopeTrack = "track"
+)
+
+// Principal is the authenticated caller. Scope is empty or ScopeFull for
+// session logins and the key's scope for API keys; APIKey is set for every
+// key, full-scope ones included.
+type Principal struct {
+	UserID   int64
+	Username string
+	Role     string
+	Scope    string
+	APIKey   bool
+}
+
+// Allows reports whether p's scope permits an action needing scope. A full
+// scope permits everything; read-only and track-only keys permit only their
+// own kind of action.
+func (p Principal) Allows(scope string) bool {
+	return p.Scope == "" || p.Scope == ScopeFull || p.Scope == scope
+}
+
+// IsAdmin reports whether p has the admin role.
+func (p Principal) IsAdmin() bool {
+	return p.Role == RoleAdmin
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored by Authenticate.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(Principal)
+	return p, ok
+}
+
+// Authenticator identifies the caller of r. Errors should be *apierror.Error
+// values so they render with the right status.
+type Authenticator func(r *http.Request) (Principal, error)
+
+// Authenticate resolves the principal with auth and stores it in the request
+// context, rejecting the request if authentication fails.
+func Authenticate(auth Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := auth(r)
+			if err != nil {
+				apierror.Write(w, r, err)
+				return
+			}
+			middleware.SetUserID(r.Context(), p.UserID)
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+		})
+	}
+}
+
+// RequireRole allows only principals holding one of roles. It must run
+// after Authenticate.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := FromContext(r.Context())
+			if !ok {
+				apierror.Write(w, r, apierror.Unauthorized("Authentication required"))
+				return
+			}
+			// Roles guard account-wide powers that API keys never carry,
+			// whatever their scope.
+			if !slices.Contains(roles, p.Role) || p.APIKey {
+				apierror.Write(w, r, apierror.Forbidden("Insufficient permissions"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// Package env reads typed configuration values from environment variables,
+// falling back to a default when a variable is unset or malformed.
+package env
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// String returns the value of key, or def if it is unset or empty.
+func String(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// Int returns key parsed as an integer, or def.
+func Int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		invalid(key, v, err)
+		return def
+	}
+	return n
+}
+
+// Bool returns key parsed by strconv.ParseBool, or def.
+func Bool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		invalid(key, v, err)
+		return def
+	}
+	return b
+}
+
+// Duration returns key parsed by time.ParseDuration (e.g. "15s"), or def.
+func Duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		invalid(key, v, err)
+		return def
+	}
+	return d
+}
+
+func invalid(key, value string, err error) {
+	slog.Warn("ignoring invalid environment variable", "key", key, "value", value, "error", err)
+}
@@ -0,0 +1,48 @@
+// Package internalauth protects service-to-service endpoints with a shared
+// secret carried in the X-Internal-Token header.
+package internalauth
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"habit-tracker/pkg/apierror"
+)
+
+// Header carries the shared secret.
+const Header = "X-Internal-Token"
+
+// Middleware rejects requests whose X-Internal-Token does not match token.
+// An empty token disables the protected routes entirely.
+func Middleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				apierror.Write(w, r, apierror.NotFound("Resource not found"))
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get(Header)), []byte(token)) != 1 {
+				apierror.Write(w, r, apierror.Unauthorized("Invalid internal token"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Transport adds the shared secret to every outgoing request.
+type Transport struct {
+	Token string
+	// Base is the underlying transport; http.DefaultTransport when nil.
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(Header, t.Token)
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,106 @@
+// Package metrics exposes Prometheus instrumentation shared by the services:
+// per-route HTTP metrics, outbound call latency and database pool stats.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"habit-tracker/pkg/middleware"
+)
+
+// unmatchedRoute labels requests that did not match any registered route,
+// so arbitrary paths cannot blow up label cardinality.
+const unmatchedRoute = "unmatched"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by method and route template.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "upstream_request_duration_seconds",
+		Help:    "Latency of outbound HTTP calls, by upstream and outcome (status code or \"error\").",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"upstream", "outcome"})
+)
+
+// RouteFunc returns the route template that r matches, or "" if none.
+type RouteFunc func(r *http.Request) string
+
+// Middleware records request counts and latency labelled by the route
+// template returned by route, never by the raw path.
+func Middleware(route RouteFunc) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tmpl := route(r)
+			if tmpl == "" {
+				tmpl = unmatchedRoute
+			}
+
+			start := time.Now()
+			rec := middleware.NewRecorder(w)
+			next.ServeHTTP(rec, r)
+
+			httpDuration.WithLabelValues(r.Method, tmpl).Observe(time.Since(start).Seconds())
+			httpRequests.WithLabelValues(r.Method, tmpl, strconv.Itoa(rec.Status())).Inc()
+		})
+	}
+}
+
+// ServeMuxRoute returns a RouteFunc reporting the pattern mux would use for r.
+func ServeMuxRoute(mux *http.ServeMux) RouteFunc {
+	return func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	}
+}
+
+// Handler serves the default registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterDB exports connection pool statistics from sql.DB.Stats() under
+// the given database name.
+func RegisterDB(db *sql.DB, name string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Transport is an http.RoundTripper that records the latency of calls to a
+// named upstream.
+type Transport struct {
+	Upstream string
+	// Base is the underlying transport; http.DefaultTransport when nil.
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	outcome := "error"
+	if err == nil {
+		outcome = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamDuration.WithLabelValues(t.Upstream, outcome).Observe(time.Since(start).Seconds())
+	return resp, err
+}
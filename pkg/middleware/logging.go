@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/requestid"
+)
+
+// RequestID is requestid.Middleware, re-exported so services can build their
+// whole stack from this package.
+func RequestID(next http.Handler) http.Handler {
+	return requestid.Middleware(next)
+}
+
+// AccessLog writes one structured log line per request once it completes.
+func AccessLog(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ctx, info := withRequestInfo(r.Context())
+			rec := NewRecorder(w)
+
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.Status()),
+				slog.Int64("bytes", rec.Bytes()),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("user_agent", r.UserAgent()),
+			}
+			if id := info.userID.Load(); id != 0 {
+				attrs = append(attrs, slog.Int64("user_id", id))
+			}
+
+			level := slog.LevelInfo
+			if rec.Status() >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logger.LogAttrs(ctx, level, "http request", attrs...)
+		})
+	}
+}
+
+// Recover turns a panicking handler into a logged stack trace and a 500
+// JSON error response. If the handler had already started its response, a
+// 500 can no longer be sent; the connection is aborted instead so the client
+// sees a truncated response rather than a complete-looking one.
+func Recover(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := NewRecorder(w)
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				logger.ErrorContext(r.Context(), "panic serving request",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"panic", v,
+					"stack", string(debug.Stack()),
+				)
+				if rec.status != 0 {
+					panic(http.ErrAbortHandler)
+				}
+				apierror.Write(w, r, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "Internal server error"))
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// Standard returns the stack every service installs: request ID assignment,
+// access logging and panic recovery, in that order. Any extra middlewares
+// run between access logging and recovery, so they observe the 500 written
+// for a panic.
+func Standard(logger *slog.Logger, extra ...Middleware) []Middleware {
+	mws := []Middleware{RequestID, AccessLog(logger)}
+	mws = append(mws, extra...)
+	return append(mws, Recover(logger))
+}
@@ -0,0 +1,86 @@
+// Package middleware provides the HTTP middleware stack shared by the
+// services: request IDs, access logging and panic recovery.
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// Middleware wraps an http.Handler.
+type Middleware func(http.Handler) http.Handler
+
+// Chain applies middlewares to h so that the first one listed is outermost.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+type infoKey struct{}
+
+// requestInfo collects facts discovered while handling a request that the
+// access log reports once the handler returns.
+type requestInfo struct {
+	userID atomic.Int64
+}
+
+func withRequestInfo(ctx context.Context) (context.Context, *requestInfo) {
+	info := &requestInfo{}
+	return context.WithValue(ctx, infoKey{}, info), info
+}
+
+// SetUserID records the authenticated user for the request's access log
+// entry. It is a no-op outside the AccessLog middleware.
+func SetUserID(ctx context.Context, id int64) {
+	if info, ok := ctx.Value(infoKey{}).(*requestInfo); ok {
+		info.userID.Store(id)
+	}
+}
+
+// Recorder captures the status code and body size written by a handler.
+type Recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w}
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the status sent to the client, defaulting to 200.
+func (r *Recorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Bytes returns the number of body bytes written.
+func (r *Recorder) Bytes() int64 {
+	return r.bytes
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepThreshold is the bucket count above which idle buckets are purged.
+const sweepThreshold = 10000
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// MemoryStore keeps buckets in process memory. It is only correct when a
+// single replica serves the limited routes.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buckets) > sweepThreshold {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+
+	var res Result
+	b.tokens, res = refill(b.tokens, now.Sub(b.updated), limit)
+	b.updated = now
+	return res, nil
+}
+
+// sweep drops buckets that have refilled completely; they are
+// indistinguishable from buckets that were never created.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		full, _ := refill(b.tokens, now.Sub(b.updated), b.limit)
+		if full+1 >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// staleAfter is how long an untouched bucket is kept in Postgres.
+const staleAfter = time.Hour
+
+// PostgresStore keeps buckets in a rate_limits table so that all replicas
+// share the same limits.
+type PostgresStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewPostgresStore creates the rate_limits table if needed.
+func NewPostgresStore(db *sql.DB) (*PostgresStore, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS rate_limits (
+			key VARCHAR(255) PRIMARY KEY,
+			tokens DOUBLE PRECISION NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("create rate_limits table: %w", err)
+	}
+	return &PostgresStore{db: db}, nil
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.maybeSweep(ctx, now)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	// The no-op update takes the row lock so concurrent Takes serialise.
+	var tokens float64
+	var updated time.Time
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO rate_limits (key, tokens, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET key = EXCLUDED.key
+		RETURNING tokens, updated_at
+	`, key, float64(limit.Burst), now).Scan(&tokens, &updated)
+	if err != nil {
+		return Result{}, err
+	}
+
+	tokens, res := refill(tokens, now.Sub(updated), limit)
+	_, err = tx.ExecContext(ctx, `UPDATE rate_limits SET tokens = $2, updated_at = $3 WHERE key = $1`, key, tokens, now)
+	if err != nil {
+		return Result{}, err
+	}
+	return res, tx.Commit()
+}
+
+// maybeSweep deletes stale buckets at most once a minute.
+func (s *PostgresStore) maybeSweep(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	due := now.Sub(s.lastSweep) >= time.Minute
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+
+	if due {
+		s.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE updated_at < $1`, now.Add(-staleAfter))
+	}
+}
@@ -0,0 +1,130 @@
+// Package ratelimit implements token-bucket rate limiting with pluggable
+// storage: an in-memory store for single instances and a Postgres store that
+// shares buckets between replicas.
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/middleware"
+)
+
+// Limit describes a token bucket: Burst tokens at most, refilled at Rate
+// tokens per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute returns a Limit allowing n requests per minute with a burst of n.
+func PerMinute(n int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: n}
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Store persists buckets. Take removes one token from the bucket for key,
+// creating a full bucket if none exists.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Limiter applies one Limit to keys within a namespace.
+type Limiter struct {
+	Store  Store
+	Limit  Limit
+	Prefix string
+
+	// Now returns the current time; time.Now when nil.
+	Now func() time.Time
+}
+
+// Allow takes a token for key. If the store fails the request is allowed,
+// so an unavailable store never locks everyone out.
+func (l *Limiter) Allow(ctx context.Context, key string) Result {
+	now := time.Now
+	if l.Now != nil {
+		now = l.Now
+	}
+	res, err := l.Store.Take(ctx, l.Prefix+key, l.Limit, now())
+	if err != nil {
+		slog.ErrorContext(ctx, "rate limit store failed; allowing request", "prefix", l.Prefix, "error", err)
+		return Result{Allowed: true, Remaining: l.Limit.Burst}
+	}
+	return res
+}
+
+// KeyFunc derives the bucket key for a request.
+type KeyFunc func(r *http.Request) string
+
+// ByIP keys requests by client IP.
+func ByIP(r *http.Request) string {
+	return ClientIP(r)
+}
+
+// ClientIP returns the host part of r.RemoteAddr. Forwarding headers are
+// ignored because they are trivially spoofed.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware rejects requests with 429 once the bucket for key(r) is empty.
+func Middleware(l *Limiter, key KeyFunc) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res := l.Allow(r.Context(), key(r))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(l.Limit.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			if !res.Allowed {
+				WriteLimited(w, r, res.RetryAfter)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WriteLimited answers with 429 rate_limited and a Retry-After header.
+func WriteLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	SetRetryAfter(w, retryAfter)
+	apierror.Write(w, r, apierror.New(http.StatusTooManyRequests, apierror.CodeRateLimited, "Too many requests; try again later"))
+}
+
+// SetRetryAfter sets the Retry-After header, rounding up to whole seconds.
+func SetRetryAfter(w http.ResponseWriter, d time.Duration) {
+	secs := int(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
+
+// refill returns the token count after elapsed time and whether a token can
+// be taken, along with the wait until one becomes available.
+func refill(tokens float64, elapsed time.Duration, limit Limit) (float64, Result) {
+	if elapsed > 0 {
+		tokens = math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+	}
+	if tokens >= 1 {
+		tokens--
+		return tokens, Result{Allowed: true, Remaining: int(tokens)}
+	}
+	wait := time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	return tokens, Result{Allowed: false, RetryAfter: wait}
+}
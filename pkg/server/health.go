@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkTimeout bounds each readiness check so a hung dependency cannot hang
+// the probe.
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+// Health serves the liveness and readiness endpoints.
+//
+// Liveness (/healthz) only says the process is serving HTTP. Readiness
+// (/readyz) runs every registered check and fails once the server starts
+// draining, so load balancers stop routing new traffic before shutdown.
+type Health struct {
+	mu       sync.RWMutex
+	checks   map[string]Check
+	draining atomic.Bool
+}
+
+func NewHealth() *Health {
+	return &Health{checks: make(map[string]Check)}
+}
+
+// AddCheck registers a readiness check under name.
+func (h *Health) AddCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// SetDraining marks the service as shutting down.
+func (h *Health) SetDraining() {
+	h.draining.Store(true)
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// LivenessHandler always answers 200 while the process can serve requests.
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+	})
+}
+
+// ReadinessHandler answers 200 when every check passes and 503 otherwise.
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.draining.Load() {
+			writeHealth(w, http.StatusServiceUnavailable, healthResponse{Status: "draining"})
+			return
+		}
+
+		results, ok := h.run(r.Context())
+		resp := healthResponse{Status: "ready", Checks: results}
+		status := http.StatusOK
+		if !ok {
+			resp.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+		writeHealth(w, status, resp)
+	})
+}
+
+// run executes all checks concurrently.
+func (h *Health) run(ctx context.Context) (map[string]string, bool) {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = h.checks[name]
+	}
+	h.mu.RUnlock()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			errs[i] = check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	results := make(map[string]string, len(names))
+	ok := true
+	for i, name := range names {
+		if errs[i] != nil {
+			results[name] = errs[i].Error()
+			ok = false
+			continue
+		}
+		results[name] = "ok"
+	}
+	return results, ok
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
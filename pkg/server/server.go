@@ -0,0 +1,97 @@
+// Package server is the HTTP bootstrap shared by the services: timeouts,
+// health endpoints and graceful shutdown on SIGINT/SIGTERM.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"habit-tracker/pkg/env"
+)
+
+// Config holds the listener address, server timeouts and shutdown policy.
+type Config struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// DrainDelay is how long to keep serving after readiness starts failing,
+	// giving load balancers time to notice before connections are closed.
+	DrainDelay time.Duration
+	// ShutdownTimeout bounds how long in-flight requests may take to finish.
+	ShutdownTimeout time.Duration
+}
+
+// ConfigFromEnv builds a Config from HTTP_ADDR, HTTP_READ_HEADER_TIMEOUT,
+// HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT, HTTP_IDLE_TIMEOUT,
+// SHUTDOWN_DRAIN_DELAY and SHUTDOWN_TIMEOUT.
+func ConfigFromEnv(defaultAddr string) Config {
+	return Config{
+		Addr:              env.String("HTTP_ADDR", defaultAddr),
+		ReadHeaderTimeout: env.Duration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       env.Duration("HTTP_READ_TIMEOUT", 10*time.Second),
+		WriteTimeout:      env.Duration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       env.Duration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+		DrainDelay:        env.Duration("SHUTDOWN_DRAIN_DELAY", 0),
+		ShutdownTimeout:   env.Duration("SHUTDOWN_TIMEOUT", 20*time.Second),
+	}
+}
+
+// Run serves handler until ctx is cancelled or the process receives SIGINT
+// or SIGTERM, then marks health as draining and waits up to
+// cfg.ShutdownTimeout for in-flight requests. health may be nil.
+func Run(ctx context.Context, cfg Config, handler http.Handler, health *Health) error {
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	slog.Info("server listening", "addr", cfg.Addr)
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("listen on %s: %w", cfg.Addr, err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process immediately.
+	stop()
+
+	slog.Info("shutting down", "drain_delay", cfg.DrainDelay, "timeout", cfg.ShutdownTimeout)
+	if health != nil {
+		health.SetDraining()
+	}
+	time.Sleep(cfg.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("graceful shutdown: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("server stopped")
+	return nil
+}
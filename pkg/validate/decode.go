@@ -0,0 +1,63 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"habit-tracker/pkg/apierror"
+)
+
+// DefaultMaxBodyBytes is the body size limit used when DecodeJSON is given a
+// non-positive limit.
+const DefaultMaxBodyBytes = 64 << 10
+
+// DecodeJSON reads a single JSON object from r.Body into dst, rejecting
+// bodies larger than maxBytes and fields dst does not declare, then runs
+// Struct on the result. Every error it returns is an *apierror.Error.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, dst any, maxBytes int64) error {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBodyBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err, maxBytes)
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return apierror.InvalidJSON(errors.New("body must contain a single JSON object"))
+	}
+
+	return Struct(dst)
+}
+
+func decodeError(err error, maxBytes int64) error {
+	var maxErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &maxErr):
+		return apierror.New(http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge,
+			fmt.Sprintf("Request body must not exceed %d bytes", maxBytes))
+	case errors.As(err, &typeErr):
+		return apierror.Validation(apierror.FieldError{
+			Field:   typeErr.Field,
+			Code:    "invalid_type",
+			Message: fmt.Sprintf("%s must be a %s", typeErr.Field, typeErr.Type),
+		})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for unknown fields.
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return apierror.Validation(apierror.FieldError{
+			Field:   field,
+			Code:    "unknown_field",
+			Message: fmt.Sprintf("%s is not a recognised field", field),
+		})
+	}
+	return apierror.InvalidJSON(err)
+}
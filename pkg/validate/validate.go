@@ -0,0 +1,252 @@
+// Package validate implements declarative request validation driven by
+// `validate` struct tags, and strict JSON decoding of request bodies.
+//
+// Rules are comma separated and applied in order:
+//
+//	type RegisterRequest struct {
+//		Username string `json:"username" validate:"required,min=3,max=50,username"`
+//	}
+//
+// A field that is empty and not marked required skips its remaining rules.
+// Pointer fields are dereferenced, so a nil *string in a PATCH request means
+// "not provided" while a non-nil one is validated like a string.
+// All failing fields are reported together as an apierror validation error.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"habit-tracker/pkg/apierror"
+)
+
+// Rule checks a single field value. param is the text after "=" in the tag,
+// or "" if there was none. On failure it returns a machine-readable code and
+// a human-readable message (without the field name).
+type Rule func(v reflect.Value, param string) (code, message string, ok bool)
+
+var (
+	mu    sync.RWMutex
+	rules = map[string]Rule{
+		"required": required,
+		"min":      minLength,
+		"max":      maxLength,
+		"email":    email,
+		"username": username,
+		"password": password,
+		"oneof":    oneOf,
+	}
+)
+
+// Register adds or replaces a named rule.
+func Register(name string, rule Rule) {
+	mu.Lock()
+	defer mu.Unlock()
+	rules[name] = rule
+}
+
+// Struct validates every tagged field of the struct pointed to by v and
+// returns an *apierror.Error listing all failures, or nil.
+func Struct(v any) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validate: Struct called with %T", v))
+	}
+
+	var details []apierror.FieldError
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag, ok := sf.Tag.Lookup("validate")
+		if !ok || tag == "" || tag == "-" {
+			continue
+		}
+		if fe, failed := checkField(fieldName(sf), rv.Field(i), tag); failed {
+			details = append(details, fe)
+		}
+	}
+
+	if len(details) > 0 {
+		return apierror.Validation(details...)
+	}
+	return nil
+}
+
+// checkField applies the rules in tag to fv and stops at the first failure so
+// each field contributes at most one error.
+func checkField(name string, fv reflect.Value, tag string) (apierror.FieldError, bool) {
+	specs := strings.Split(tag, ",")
+	if fv.IsZero() && !contains(specs, "required") {
+		return apierror.FieldError{}, false
+	}
+	if fv.Kind() == reflect.Pointer && !fv.IsNil() {
+		fv = fv.Elem()
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, spec := range specs {
+		ruleName, param, _ := strings.Cut(spec, "=")
+		rule, ok := rules[ruleName]
+		if !ok {
+			panic(fmt.Sprintf("validate: unknown rule %q on field %s", ruleName, name))
+		}
+		if code, msg, ok := rule(fv, param); !ok {
+			return apierror.FieldError{Field: name, Code: code, Message: name + " " + msg}, true
+		}
+	}
+	return apierror.FieldError{}, false
+}
+
+func fieldName(sf reflect.StructField) string {
+	if tag := sf.Tag.Get("json"); tag != "" {
+		if name, _, _ := strings.Cut(tag, ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+func contains(specs []string, name string) bool {
+	for _, s := range specs {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func required(v reflect.Value, _ string) (string, string, bool) {
+	if v.Kind() == reflect.String {
+		return "required", "is required", strings.TrimSpace(v.String()) != ""
+	}
+	return "required", "is required", !v.IsZero()
+}
+
+// length reports the size used by min/max: characters for strings, elements
+// for slices and maps, and the value itself for numbers.
+func length(v reflect.Value) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(utf8.RuneCountInString(v.String()))
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return int64(v.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Pointer:
+		if v.IsNil() {
+			return 0
+		}
+		return length(v.Elem())
+	}
+	panic(fmt.Sprintf("validate: min/max not supported for %s", v.Kind()))
+}
+
+func unit(v reflect.Value) string {
+	switch reflect.Indirect(v).Kind() {
+	case reflect.String:
+		return " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return " items"
+	}
+	return ""
+}
+
+func isNumber(v reflect.Value) bool {
+	switch reflect.Indirect(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func minLength(v reflect.Value, param string) (string, string, bool) {
+	n := mustInt(param)
+	if isNumber(v) {
+		return "too_small", fmt.Sprintf("must be at least %d", n), length(v) >= n
+	}
+	return "too_short", fmt.Sprintf("must be at least %d%s", n, unit(v)), length(v) >= n
+}
+
+func maxLength(v reflect.Value, param string) (string, string, bool) {
+	n := mustInt(param)
+	if isNumber(v) {
+		return "too_large", fmt.Sprintf("must be at most %d", n), length(v) <= n
+	}
+	return "too_long", fmt.Sprintf("must be at most %d%s", n, unit(v)), length(v) <= n
+}
+
+func mustInt(param string) int64 {
+	n, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validate: bad numeric parameter %q", param))
+	}
+	return n
+}
+
+// oneOf accepts only the space separated values in param.
+func oneOf(v reflect.Value, param string) (string, string, bool) {
+	allowed := strings.Fields(param)
+	return "invalid_choice", "must be one of: " + strings.Join(allowed, ", "), contains(allowed, v.String())
+}
+
+func email(v reflect.Value, _ string) (string, string, bool) {
+	s := v.String()
+	addr, err := mail.ParseAddress(s)
+	ok := err == nil && addr.Address == s && addr.Name == ""
+	return "invalid_email", "must be a valid email address", ok
+}
+
+// username allows ASCII letters, digits, '.', '_' and '-', and must start
+// with a letter or digit.
+func username(v reflect.Value, _ string) (string, string, bool) {
+	s := v.String()
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case (c == '.' || c == '_' || c == '-') && i > 0:
+		default:
+			return "invalid_username", "may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit", false
+		}
+	}
+	return "", "", true
+}
+
+// password enforces the account password policy: 8 to 72 bytes (the bcrypt
+// input limit), at least one letter and one digit, and no surrounding
+// whitespace.
+func password(v reflect.Value, _ string) (string, string, bool) {
+	s := v.String()
+	if len(s) < 8 {
+		return "weak_password", "must be at least 8 characters", false
+	}
+	if len(s) > 72 {
+		return "too_long", "must be at most 72 bytes", false
+	}
+	if strings.TrimSpace(s) != s {
+		return "weak_password", "must not start or end with whitespace", false
+	}
+
+	var letter, digit bool
+	for _, c := range s {
+		switch {
+		case unicode.IsLetter(c):
+			letter = true
+		case unicode.IsDigit(c):
+			digit = true
+		}
+	}
+	if !letter || !digit {
+		return "weak_password", "must contain at least one letter and one digit", false
+	}
+	return "", "", true
+}
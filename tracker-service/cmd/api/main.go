@@ -1,23 +1,126 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/env"
+	"habit-tracker/pkg/internalauth"
+	"habit-tracker/pkg/logging"
+	"habit-tracker/pkg/metrics"
+	"habit-tracker/pkg/middleware"
+	"habit-tracker/pkg/server"
+	"habit-tracker/pkg/tracing"
 	"habit-tracker/tracker-service/internal/habit"
+
 	"github.com/gorilla/mux"
 )
 
 func main() {
+	logger := logging.Setup("tracker-service")
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "tracker-service")
+	if err != nil {
+		logging.Fatal("Failed to initialize tracing", err)
+	}
+
+	// Initialize database
+	if err := habit.InitDB(); err != nil {
+		logging.Fatal("Failed to initialize database", err)
+	}
+	metrics.RegisterDB(habit.DB(), "habits")
+
+	health := server.NewHealth()
+	health.AddCheck("database", habit.DB().PingContext)
+	health.AddCheck("user-service", habit.CheckUserService)
+
 	// Initialize router
 	router := mux.NewRouter()
+	router.NotFoundHandler = apierror.NotFoundHandler()
+	router.MethodNotAllowedHandler = apierror.MethodNotAllowedHandler()
 
 	// Habit routes
 	router.HandleFunc("/habits", habit.HabitsHandler).Methods("POST", "GET")
+	router.HandleFunc("/habits/{id}", habit.HabitHandler).Methods("PATCH")
 	router.HandleFunc("/habits/{id}/track", habit.TrackHandler).Methods("POST")
 	router.HandleFunc("/habits/{id}/stats", habit.StatsHandler).Methods("GET")
+	router.HandleFunc("/habits/{id}/records", habit.RecordsHandler).Methods("GET")
+	router.HandleFunc("/habits/{id}/records/{recordID}", habit.RecordHandler).Methods("PATCH")
+	router.HandleFunc("/records/search", habit.SearchRecordsHandler).Methods("GET")
+	router.HandleFunc("/stats", habit.SummaryHandler).Methods("GET")
+	router.HandleFunc("/stats/categories", habit.CategoryStatsHandler).Methods("GET")
+	router.HandleFunc("/analytics", habit.AnalyticsHandler).Methods("GET")
+	router.HandleFunc("/goals", habit.GoalsHandler).Methods("GET", "POST")
+	router.HandleFunc("/goals/{id}", habit.GoalHandler).Methods("GET", "DELETE")
+	router.HandleFunc("/achievements", habit.AchievementsHandler).Methods("GET")
+	router.HandleFunc("/freezes", habit.FreezesHandler).Methods("GET")
+	router.HandleFunc("/vacations", habit.VacationsHandler).Methods("GET", "POST")
+	router.HandleFunc("/vacations/{id}", habit.VacationHandler).Methods("DELETE")
+	router.HandleFunc("/partners", habit.PartnersHandler).Methods("GET", "POST")
+	router.HandleFunc("/partners/{id}", habit.PartnerHandler).Methods("DELETE")
+	router.HandleFunc("/partners/{id}/accept", habit.AcceptPartnerHandler).Methods("POST")
+	router.HandleFunc("/partners/{id}/habits", habit.PartnerHabitsHandler).Methods("GET")
+	router.HandleFunc("/habits/{id}/shares", habit.HabitSharesHandler).Methods("GET", "PUT")
+	router.HandleFunc("/shared-habits", habit.SharedHabitsHandler).Methods("GET", "POST")
+	router.HandleFunc("/shared-habits/{id}", habit.SharedHabitHandler).Methods("GET", "DELETE")
+	router.HandleFunc("/shared-habits/{id}/join", habit.JoinSharedHabitHandler).Methods("POST")
+	router.HandleFunc("/groups", habit.GroupsHandler).Methods("GET", "POST")
+	router.HandleFunc("/groups/{id}", habit.GroupHandler).Methods("GET", "DELETE")
+	router.HandleFunc("/groups/{id}/members", habit.GroupMembersHandler).Methods("POST")
+	router.HandleFunc("/groups/{id}/join", habit.JoinGroupHandler).Methods("POST")
+	router.HandleFunc("/groups/{id}/members/{userID}", habit.GroupMemberHandler).Methods("DELETE")
+	router.HandleFunc("/groups/{id}/privacy", habit.GroupPrivacyHandler).Methods("PUT")
+	router.HandleFunc("/groups/{id}/leaderboard", habit.GroupLeaderboardHandler).Methods("GET")
+	router.HandleFunc("/groups/{id}/challenges", habit.GroupChallengesHandler).Methods("GET", "POST")
+	router.HandleFunc("/groups/{id}/challenges/{challengeID}", habit.GroupChallengeHandler).Methods("GET", "DELETE")
+	router.HandleFunc("/groups/{id}/challenges/{challengeID}/join", habit.JoinChallengeHandler).Methods("POST", "DELETE")
+	router.HandleFunc("/groups/{id}/challenges/{challengeID}/leaderboard", habit.ChallengeLeaderboardHandler).Methods("GET")
+	router.HandleFunc("/export", habit.ExportHandler).Methods("GET")
+	router.HandleFunc("/export/account", habit.AccountExportHandler).Methods("GET")
+	router.HandleFunc("/tags", habit.TagsHandler).Methods("GET", "POST")
+	router.HandleFunc("/tags/{id}", habit.TagHandler).Methods("PATCH", "DELETE")
+	router.HandleFunc("/categories", habit.CategoriesHandler).Methods("GET", "POST")
+	router.HandleFunc("/categories/{id}", habit.CategoryHandler).Methods("PATCH", "DELETE")
 	router.PathPrefix("/motivation").HandlerFunc(habit.MotivationHandler).Methods("GET")
+	// Service-to-service routes
+	internal := router.PathPrefix("/internal").Subrouter()
+	internal.Use(internalauth.Middleware(env.String("INTERNAL_API_TOKEN", "")))
+	internal.HandleFunc("/users/{id}", habit.DeleteUserDataHandler).Methods("DELETE")
+
+	router.Handle("/healthz", health.LivenessHandler()).Methods("GET")
+	router.Handle("/readyz", health.ReadinessHandler()).Methods("GET")
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+
+	route := routeTemplate(router)
+	handler := middleware.Chain(router, middleware.Standard(logger, metrics.Middleware(route))...)
+	handler = tracing.Middleware(route)(handler)
 
 	// Start server
-	log.Println("Starting Tracker Service on port 8081")
-	log.Fatal(http.ListenAndServe(":8081", router))
-} 
\ No newline at end of file
+	slog.Info("Starting Tracker Service")
+	if err := server.Run(context.Background(), server.ConfigFromEnv(":8081"), handler, health); err != nil {
+		logging.Fatal("Server failed", err)
+	}
+
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	habit.DB().Close()
+}
+
+// routeTemplate labels metrics with the mux path template (e.g.
+// /habits/{id}/track) rather than the concrete path.
+func routeTemplate(router *mux.Router) metrics.RouteFunc {
+	return func(r *http.Request) string {
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.Route == nil {
+			return ""
+		}
+		tmpl, err := match.Route.GetPathTemplate()
+		if err != nil {
+			return ""
+		}
+		return tmpl
+	}
+}
@@ -0,0 +1,390 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+)
+
+// achievementRule describes a badge. Whether and when it was earned is
+// decided by unlockAchievements from the user's whole history.
+type achievementRule struct {
+	Code        string
+	Name        string
+	Description string
+}
+
+var achievementRules = []achievementRule{
+	{"first_track", "First step", "Track a habit for the first time"},
+	{"streak_7", "One week", "Reach a 7-day streak on a daily habit"},
+	{"streak_30", "One month", "Reach a 30-day streak on a daily habit"},
+	{"streak_100", "Centurion", "Reach a 100-day streak on a daily habit"},
+	{"completions_1000", "Thousand", "Complete habits 1000 times"},
+	{"perfect_week", "Perfect week", "Complete every scheduled day of every habit from Monday to Sunday"},
+}
+
+var streakAchievements = []struct {
+	Code string
+	Days int
+}{{"streak_7", 7}, {"streak_30", 30}, {"streak_100", 100}}
+
+// Achievement is a badge and, once earned, when and with which habit.
+type Achievement struct {
+	Code        string     `json:"code"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Unlocked    bool       `json:"unlocked"`
+	UnlockedAt  *time.Time `json:"unlocked_at"`
+	HabitID     *int64     `json:"habit_id,omitempty"`
+}
+
+type AchievementListResponse struct {
+	Achievements []Achievement `json:"achievements"`
+}
+
+// unlock is an achievement earned on Day, a calendar date in the user's
+// time zone, or at At when the exact time is known.
+type unlock struct {
+	Day     time.Time
+	At      time.Time
+	HabitID *int64
+}
+
+// achievementHistory is everything unlockAchievements looks at.
+type achievementHistory struct {
+	Habits []*Habit
+	// Done holds completed (or, for break habits, clean) days per habit.
+	Done map[int64]map[string]bool
+	// FirstCompletion and ThousandthCompletion are track record times,
+	// zero if there are not that many completions.
+	FirstCompletion      time.Time
+	ThousandthCompletion time.Time
+}
+
+// AchievementsHandler answers GET /achievements with every achievement,
+// earned or not. Earlier activity counts, so achievements added after the
+// fact are unlocked on the first request.
+func AchievementsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	c, err := authenticateCaller(r, authz.ScopeRead)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	if _, err := evaluateAchievements(r.Context(), c.UserID, c.Location()); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	earned, err := loadAchievements(r.Context(), c.UserID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	list := make([]Achievement, 0, len(achievementRules))
+	for _, rule := range achievementRules {
+		a := Achievement{Code: rule.Code, Name: rule.Name, Description: rule.Description}
+		if e, ok := earned[rule.Code]; ok {
+			a.Unlocked, a.UnlockedAt, a.HabitID = true, e.UnlockedAt, e.HabitID
+		}
+		list = append(list, a)
+	}
+	json.NewEncoder(w).Encode(AchievementListResponse{Achievements: list})
+}
+
+// evaluateAchievements unlocks the achievements userID has earned but not
+// yet been awarded and returns the new ones.
+func evaluateAchievements(ctx context.Context, userID int64, loc *time.Location) ([]Achievement, error) {
+	earned, err := loadAchievements(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(earned) == len(achievementRules) {
+		return nil, nil
+	}
+
+	history, err := loadAchievementHistory(ctx, userID, loc)
+	if err != nil {
+		return nil, err
+	}
+	unlocks := unlockAchievements(history, civilDay(time.Now(), loc))
+	return saveUnlocks(ctx, userID, earned, unlocks, loc)
+}
+
+// trackAchievements is evaluateAchievements for a track of habit, whose
+// done days are given: it checks only that habit's streak milestones and
+// counts completions, leaving the other habits and perfect_week to
+// GET /achievements.
+func trackAchievements(ctx context.Context, userID int64, habit *Habit, done map[string]bool, loc *time.Location) ([]Achievement, error) {
+	earned, err := loadAchievements(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	unlocks := make(map[string]unlock)
+
+	_, firstSeen := earned["first_track"]
+	_, thousandSeen := earned["completions_1000"]
+	if !firstSeen || !thousandSeen {
+		var count int
+		var first sql.NullTime
+		query := `SELECT COUNT(*), MIN(date) FROM track_records WHERE user_id = $1 AND completed`
+		if err := db.QueryRowContext(ctx, query, userID).Scan(&count, &first); err != nil {
+			return nil, err
+		}
+		if first.Valid {
+			unlocks["first_track"] = unlock{At: first.Time}
+		}
+		if !thousandSeen && count >= 1000 {
+			var thousandth time.Time
+			query := `SELECT date FROM track_records WHERE user_id = $1 AND completed ORDER BY date OFFSET 999 LIMIT 1`
+			if err := db.QueryRowContext(ctx, query, userID).Scan(&thousandth); err != nil {
+				return nil, err
+			}
+			unlocks["completions_1000"] = unlock{At: thousandth}
+		}
+	}
+
+	if habit.Schedule != ScheduleWeekly {
+		reached := streakMilestones(habit, done, civilDay(time.Now(), loc))
+		for _, s := range streakAchievements {
+			if day, ok := reached[s.Days]; ok {
+				id := habit.ID
+				unlocks[s.Code] = unlock{Day: day, HabitID: &id}
+			}
+		}
+	}
+	return saveUnlocks(ctx, userID, earned, unlocks, loc)
+}
+
+// saveUnlocks records the unlocks not already earned and returns them.
+func saveUnlocks(ctx context.Context, userID int64, earned map[string]earnedAchievement, unlocks map[string]unlock, loc *time.Location) ([]Achievement, error) {
+	now := time.Now().UTC()
+	today := civilDay(now, loc)
+	var unlocked []Achievement
+	for _, rule := range achievementRules {
+		code := rule.Code
+		u, ok := unlocks[code]
+		if _, seen := earned[code]; !ok || seen {
+			continue
+		}
+		at := u.At
+		if at.IsZero() {
+			// Earned today means earned just now.
+			at = now
+			if u.Day.Before(today) {
+				at = time.Date(u.Day.Year(), u.Day.Month(), u.Day.Day(), 0, 0, 0, 0, loc).UTC()
+			}
+		}
+		inserted, err := saveAchievement(ctx, userID, code, at, u.HabitID)
+		if err != nil {
+			return nil, err
+		}
+		if inserted {
+			unlocked = append(unlocked, Achievement{
+				Code:        code,
+				Name:        rule.Name,
+				Description: rule.Description,
+				Unlocked:    true,
+				UnlockedAt:  &at,
+				HabitID:     u.HabitID,
+			})
+		}
+	}
+	return unlocked, nil
+}
+
+// unlockAchievements returns, for each achievement the history earns, the
+// first time it was earned. It does no I/O.
+func unlockAchievements(h achievementHistory, today time.Time) map[string]unlock {
+	unlocks := make(map[string]unlock)
+	if !h.FirstCompletion.IsZero() {
+		unlocks["first_track"] = unlock{At: h.FirstCompletion}
+	}
+	if !h.ThousandthCompletion.IsZero() {
+		unlocks["completions_1000"] = unlock{At: h.ThousandthCompletion}
+	}
+
+	for _, habit := range h.Habits {
+		if habit.Schedule == ScheduleWeekly {
+			continue
+		}
+		reached := streakMilestones(habit, h.Done[habit.ID], today)
+		for _, s := range streakAchievements {
+			day, ok := reached[s.Days]
+			if !ok {
+				continue
+			}
+			if prev, seen := unlocks[s.Code]; !seen || day.Before(prev.Day) {
+				id := habit.ID
+				unlocks[s.Code] = unlock{Day: day, HabitID: &id}
+			}
+		}
+	}
+
+	if day, ok := firstPerfectWeek(h.Habits, h.Done, today); ok {
+		unlocks["perfect_week"] = unlock{Day: day}
+	}
+	return unlocks
+}
+
+// streakMilestones walks a daily-style habit's history and returns the day
+// its running streak first reached each of the streak achievements' lengths.
+// Unscheduled days neither extend nor break a streak.
+func streakMilestones(habit *Habit, done map[string]bool, today time.Time) map[int]time.Time {
+	reached := make(map[int]time.Time)
+	first := today
+	for day := range done {
+		if d, err := time.Parse(dateLayout, day); err == nil && d.Before(first) {
+			first = d
+		}
+	}
+	run := 0
+	for d := first; !d.After(today); d = d.AddDate(0, 0, 1) {
+		if !scheduledOn(habit.Schedule, d) {
+			continue
+		}
+		if !done[dayKey(d)] {
+			run = 0
+			continue
+		}
+		run++
+		for _, s := range streakAchievements {
+			if _, ok := reached[s.Days]; !ok && run >= s.Days {
+				reached[s.Days] = d
+			}
+		}
+	}
+	return reached
+}
+
+// firstPerfectWeek finds the first Monday-to-Sunday week, ending no later
+// than today, in which every habit active all week completed every
+// scheduled day (weekly habits: any day). It returns that Sunday.
+func firstPerfectWeek(list []*Habit, done map[int64]map[string]bool, today time.Time) (time.Time, bool) {
+	var first time.Time
+	for _, habit := range list {
+		if c := civilDay(habit.CreatedAt, time.UTC); first.IsZero() || c.Before(first) {
+			first = c
+		}
+	}
+	if first.IsZero() {
+		return time.Time{}, false
+	}
+
+	for week := weekStart(first); !week.AddDate(0, 0, 6).After(today); week = week.AddDate(0, 0, 7) {
+		sunday := week.AddDate(0, 0, 6)
+		active, perfect := 0, true
+		for _, habit := range list {
+			from, to, ok := activeRange(habit, summaryRange{From: week, To: sunday}, time.UTC)
+			if !ok || !from.Equal(week) || !to.Equal(sunday) {
+				continue
+			}
+			active++
+			completed := 0
+			for d := week; !d.After(sunday); d = d.AddDate(0, 0, 1) {
+				if done[habit.ID][dayKey(d)] {
+					completed++
+				} else if habit.Schedule != ScheduleWeekly && scheduledOn(habit.Schedule, d) {
+					perfect = false
+				}
+			}
+			if habit.Schedule == ScheduleWeekly && completed == 0 {
+				perfect = false
+			}
+		}
+		if active > 0 && perfect {
+			return sunday, true
+		}
+	}
+	return time.Time{}, false
+}
+
+type earnedAchievement struct {
+	UnlockedAt *time.Time
+	HabitID    *int64
+}
+
+func loadAchievements(ctx context.Context, userID int64) (map[string]earnedAchievement, error) {
+	rows, err := db.QueryContext(ctx, `SELECT code, unlocked_at, habit_id FROM achievements WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	earned := make(map[string]earnedAchievement)
+	for rows.Next() {
+		var code string
+		var at time.Time
+		var habitID sql.NullInt64
+		if err := rows.Scan(&code, &at, &habitID); err != nil {
+			return nil, err
+		}
+		e := earnedAchievement{UnlockedAt: &at}
+		if habitID.Valid {
+			e.HabitID = &habitID.Int64
+		}
+		earned[code] = e
+	}
+	return earned, rows.Err()
+}
+
+// saveAchievement records an unlock, reporting false if a concurrent
+// request recorded it first.
+func saveAchievement(ctx context.Context, userID int64, code string, at time.Time, habitID *int64) (bool, error) {
+	query := `
+		INSERT INTO achievements (user_id, code, unlocked_at, habit_id) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, code) DO NOTHING
+	`
+	result, err := db.ExecContext(ctx, query, userID, code, at, habitID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// loadAchievementHistory loads the user's habits, their done days and the
+// completion milestones in three queries.
+func loadAchievementHistory(ctx context.Context, userID int64, loc *time.Location) (achievementHistory, error) {
+	var h achievementHistory
+	list, _, err := queryHabits(ctx, userID, habitQuery{Sort: "created", Archived: "all"})
+	if err != nil {
+		return h, err
+	}
+	// Creation times are compared as calendar days in loc, like done days.
+	for _, habit := range list {
+		c := *habit
+		c.CreatedAt = civilDay(habit.CreatedAt, loc)
+		if habit.ArchivedAt != nil {
+			archived := civilDay(*habit.ArchivedAt, loc)
+			c.ArchivedAt = &archived
+		}
+		h.Habits = append(h.Habits, &c)
+	}
+	if h.Done, err = loadDoneDays(ctx, userID, list, loc, time.Time{}, civilDay(time.Now(), loc)); err != nil {
+		return h, err
+	}
+
+	var first, thousandth sql.NullTime
+	query := `
+		SELECT MIN(date),
+			(SELECT date FROM track_records WHERE user_id = $1 AND completed ORDER BY date OFFSET 999 LIMIT 1)
+		FROM track_records WHERE user_id = $1 AND completed
+	`
+	if err := db.QueryRowContext(ctx, query, userID).Scan(&first, &thousandth); err != nil {
+		return h, err
+	}
+	h.FirstCompletion, h.ThousandthCompletion = first.Time, thousandth.Time
+	return h, nil
+}
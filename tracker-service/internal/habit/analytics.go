@@ -0,0 +1,369 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+)
+
+const (
+	// minCorrelationSamples is the fewest paired days a correlation is
+	// reported for; below it the coefficient is mostly noise.
+	minCorrelationSamples = 7
+	maxHabitPairs         = 20
+)
+
+// MoodEffect relates a habit's completion to a daily rating. Correlation is
+// the point-biserial coefficient (Pearson's r with completion as 0/1), null
+// when there are too few samples or either series is constant.
+type MoodEffect struct {
+	Samples     int      `json:"samples"`
+	Correlation *float64 `json:"correlation"`
+	Completed   *float64 `json:"completed_average"`
+	Missed      *float64 `json:"missed_average"`
+}
+
+type HabitAnalytics struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Scheduled int        `json:"scheduled_days"`
+	Completed int        `json:"completed_days"`
+	Mood      MoodEffect `json:"mood"`
+	Energy    MoodEffect `json:"energy"`
+}
+
+// HabitPair describes how often two habits are completed on the same day.
+// Jaccard is Both/Either; Correlation is the phi coefficient of the two
+// completion series.
+type HabitPair struct {
+	HabitIDs    [2]int64 `json:"habit_ids"`
+	Days        int      `json:"days"`
+	Both        int      `json:"both"`
+	Either      int      `json:"either"`
+	Jaccard     float64  `json:"jaccard"`
+	Correlation *float64 `json:"correlation"`
+}
+
+type WeekdayPattern struct {
+	Day            string  `json:"day"`
+	Scheduled      int     `json:"scheduled"`
+	Completed      int     `json:"completed"`
+	CompletionRate float64 `json:"completion_rate"`
+}
+
+type AnalyticsResponse struct {
+	From         string           `json:"from"`
+	To           string           `json:"to"`
+	Tag          string           `json:"tag,omitempty"`
+	CategoryID   int64            `json:"category_id,omitempty"`
+	DaysWithMood int              `json:"days_with_mood"`
+	Habits       []HabitAnalytics `json:"habits"`
+	Pairs        []HabitPair      `json:"pairs"`
+	Weekdays     []WeekdayPattern `json:"weekdays"`
+}
+
+// dayRating is the average mood and energy of the records on one day.
+type dayRating struct {
+	Mood, Energy *float64
+}
+
+// AnalyticsHandler answers GET /analytics with mood and energy correlations
+// per habit, co-occurrence between pairs of habits and completion by day of
+// the week. It takes the same query as GET /stats.
+func AnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	c, err := authenticateCaller(r, authz.ScopeRead)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	loc := c.Location()
+	hq, rng, ok := parseSummaryQuery(w, r, loc)
+	if !ok {
+		return
+	}
+	list, done, err := loadSummaryData(r.Context(), c.UserID, hq, rng, loc)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	ratings, err := loadDailyRatings(r.Context(), c.UserID, rng, loc)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	response := analyze(list, done, ratings, rng, loc)
+	response.From = dayKey(rng.From)
+	response.To = dayKey(rng.To)
+	response.Tag = hq.Tag
+	response.CategoryID = hq.Category
+	json.NewEncoder(w).Encode(response)
+}
+
+// analyze computes the analytics for list from completed days and daily
+// ratings. It does no I/O, so the same inputs always give the same result.
+func analyze(list []*Habit, done map[int64]map[string]bool, ratings map[string]dayRating, rng summaryRange, loc *time.Location) AnalyticsResponse {
+	response := AnalyticsResponse{
+		DaysWithMood: len(ratings),
+		Habits:       make([]HabitAnalytics, 0, len(list)),
+		Pairs:        []HabitPair{},
+	}
+
+	// days[i] holds the dates habit i was due within rng.
+	days := make([][]string, len(list))
+	for i, habit := range list {
+		days[i] = dueDays(habit, rng, loc)
+		response.Habits = append(response.Habits, habitAnalytics(habit, days[i], done[habit.ID], ratings))
+	}
+
+	for i := range list {
+		for j := i + 1; j < len(list); j++ {
+			pair := habitPair(days[i], days[j], done[list[i].ID], done[list[j].ID])
+			if pair.Both == 0 {
+				continue
+			}
+			pair.HabitIDs = [2]int64{list[i].ID, list[j].ID}
+			response.Pairs = append(response.Pairs, pair)
+		}
+	}
+	sort.SliceStable(response.Pairs, func(a, b int) bool {
+		return response.Pairs[a].Jaccard > response.Pairs[b].Jaccard
+	})
+	if len(response.Pairs) > maxHabitPairs {
+		response.Pairs = response.Pairs[:maxHabitPairs]
+	}
+
+	response.Weekdays = weekdayPatterns(list, days, done)
+	return response
+}
+
+// dueDays lists the dates within rng on which habit was active and
+// scheduled. Weekly habits are due every day.
+func dueDays(habit *Habit, rng summaryRange, loc *time.Location) []string {
+	from, to, ok := activeRange(habit, rng, loc)
+	if !ok {
+		return nil
+	}
+	var days []string
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if scheduledOn(habit.Schedule, d) {
+			days = append(days, dayKey(d))
+		}
+	}
+	return days
+}
+
+func habitAnalytics(habit *Habit, days []string, done map[string]bool, ratings map[string]dayRating) HabitAnalytics {
+	result := HabitAnalytics{ID: habit.ID, Name: habit.Name, Scheduled: len(days)}
+	var moodDone, energyDone []bool
+	var moods, energies []float64
+	for _, day := range days {
+		if done[day] {
+			result.Completed++
+		}
+		rating := ratings[day]
+		if rating.Mood != nil {
+			moodDone = append(moodDone, done[day])
+			moods = append(moods, *rating.Mood)
+		}
+		if rating.Energy != nil {
+			energyDone = append(energyDone, done[day])
+			energies = append(energies, *rating.Energy)
+		}
+	}
+	result.Mood = moodEffect(moodDone, moods)
+	result.Energy = moodEffect(energyDone, energies)
+	return result
+}
+
+// moodEffect summarizes ratings split by whether the habit was completed on
+// the same day. done and ratings are parallel.
+func moodEffect(done []bool, ratings []float64) MoodEffect {
+	effect := MoodEffect{Samples: len(ratings)}
+	var with, without []float64
+	for i, v := range ratings {
+		if done[i] {
+			with = append(with, v)
+		} else {
+			without = append(without, v)
+		}
+	}
+	effect.Completed = mean(with)
+	effect.Missed = mean(without)
+	if len(ratings) >= minCorrelationSamples {
+		if r, ok := pearson(indicator(done), ratings); ok {
+			effect.Correlation = &r
+		}
+	}
+	return effect
+}
+
+// habitPair compares two habits over the days both were due.
+func habitPair(daysA, daysB []string, doneA, doneB map[string]bool) HabitPair {
+	due := make(map[string]bool, len(daysB))
+	for _, day := range daysB {
+		due[day] = true
+	}
+	var pair HabitPair
+	var a, b []bool
+	for _, day := range daysA {
+		if !due[day] {
+			continue
+		}
+		pair.Days++
+		a = append(a, doneA[day])
+		b = append(b, doneB[day])
+		switch {
+		case doneA[day] && doneB[day]:
+			pair.Both++
+			pair.Either++
+		case doneA[day] || doneB[day]:
+			pair.Either++
+		}
+	}
+	if pair.Either > 0 {
+		pair.Jaccard = round3(float64(pair.Both) / float64(pair.Either))
+	}
+	if pair.Days >= minCorrelationSamples {
+		if r, ok := pearson(indicator(a), indicator(b)); ok {
+			pair.Correlation = &r
+		}
+	}
+	return pair
+}
+
+// weekdayPatterns totals scheduled and completed days per weekday, Monday
+// first. Weekly habits are left out: they are not missed on any given day.
+func weekdayPatterns(list []*Habit, days [][]string, done map[int64]map[string]bool) []WeekdayPattern {
+	patterns := make([]WeekdayPattern, 7)
+	for i := range patterns {
+		patterns[i].Day = strings.ToLower(time.Weekday((i + 1) % 7).String())
+	}
+	for i, habit := range list {
+		if habit.Schedule == ScheduleWeekly {
+			continue
+		}
+		for _, day := range days[i] {
+			d, _ := time.Parse(dateLayout, day)
+			p := &patterns[(int(d.Weekday())+6)%7]
+			p.Scheduled++
+			if done[habit.ID][day] {
+				p.Completed++
+			}
+		}
+	}
+	for i := range patterns {
+		if patterns[i].Scheduled > 0 {
+			patterns[i].CompletionRate = round3(float64(patterns[i].Completed) / float64(patterns[i].Scheduled))
+		}
+	}
+	return patterns
+}
+
+// pearson returns the correlation coefficient of xs and ys, rounded to
+// three decimals. ok is false if there are fewer than two pairs or either
+// series has no variance.
+func pearson(xs, ys []float64) (float64, bool) {
+	n := len(xs)
+	if n < 2 || len(ys) != n {
+		return 0, false
+	}
+	var meanX, meanY float64
+	for i := range xs {
+		meanX += xs[i]
+		meanY += ys[i]
+	}
+	meanX /= float64(n)
+	meanY /= float64(n)
+
+	var cov, varX, varY float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0, false
+	}
+	r := cov / math.Sqrt(varX*varY)
+	return round3(math.Max(-1, math.Min(1, r))), true
+}
+
+func indicator(bs []bool) []float64 {
+	xs := make([]float64, len(bs))
+	for i, b := range bs {
+		if b {
+			xs[i] = 1
+		}
+	}
+	return xs
+}
+
+// mean returns the average of xs rounded to two decimals, or nil if xs is
+// empty.
+func mean(xs []float64) *float64 {
+	if len(xs) == 0 {
+		return nil
+	}
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	m := math.Round(sum/float64(len(xs))*100) / 100
+	return &m
+}
+
+func round3(x float64) float64 {
+	return math.Round(x*1000) / 1000
+}
+
+// loadDailyRatings averages mood and energy over all of the user's records
+// per calendar day in rng. Days without any rating are absent.
+func loadDailyRatings(ctx context.Context, userID int64, rng summaryRange, loc *time.Location) (map[string]dayRating, error) {
+	query := `
+		SELECT (date AT TIME ZONE 'UTC' AT TIME ZONE $2)::date AS day, AVG(mood), AVG(energy)
+		FROM track_records
+		WHERE user_id = $1 AND (mood IS NOT NULL OR energy IS NOT NULL)
+			AND (date AT TIME ZONE 'UTC' AT TIME ZONE $2)::date BETWEEN $3::date AND $4::date
+		GROUP BY day
+	`
+	rows, err := db.QueryContext(ctx, query, userID, loc.String(), dayKey(rng.From), dayKey(rng.To))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := make(map[string]dayRating)
+	for rows.Next() {
+		var day time.Time
+		var mood, energy sql.NullFloat64
+		if err := rows.Scan(&day, &mood, &energy); err != nil {
+			return nil, err
+		}
+		var rating dayRating
+		if mood.Valid {
+			rating.Mood = &mood.Float64
+		}
+		if energy.Valid {
+			rating.Energy = &energy.Float64
+		}
+		ratings[dayKey(day)] = rating
+	}
+	return ratings, rows.Err()
+}
@@ -0,0 +1,198 @@
+package habit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPearson(t *testing.T) {
+	tests := []struct {
+		name   string
+		xs, ys []float64
+		want   float64
+		wantOK bool
+	}{
+		{"perfect positive", []float64{1, 2, 3, 4}, []float64{2, 4, 6, 8}, 1, true},
+		{"perfect negative", []float64{1, 2, 3, 4}, []float64{8, 6, 4, 2}, -1, true},
+		{"uncorrelated", []float64{1, 2, 3, 4}, []float64{1, 3, 3, 1}, 0, true},
+		{"rounded", []float64{1, 2, 3, 4, 5}, []float64{2, 1, 4, 3, 5}, 0.8, true},
+		{"constant x", []float64{3, 3, 3, 3}, []float64{1, 2, 3, 4}, 0, false},
+		{"constant y", []float64{1, 2, 3, 4}, []float64{5, 5, 5, 5}, 0, false},
+		{"one pair", []float64{1}, []float64{2}, 0, false},
+		{"empty", nil, nil, 0, false},
+		{"length mismatch", []float64{1, 2, 3}, []float64{1, 2}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := pearson(tt.xs, tt.ys)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("pearson(%v, %v) = %v, %v; want %v, %v", tt.xs, tt.ys, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHabitPair(t *testing.T) {
+	week := []string{
+		"2026-10-05", "2026-10-06", "2026-10-07", "2026-10-08",
+		"2026-10-09", "2026-10-10", "2026-10-11",
+	}
+	weekdays := week[:5]
+	set := func(days ...string) map[string]bool {
+		m := make(map[string]bool, len(days))
+		for _, d := range days {
+			m[d] = true
+		}
+		return m
+	}
+
+	tests := []struct {
+		name         string
+		daysA, daysB []string
+		doneA, doneB map[string]bool
+		want         HabitPair
+		correlation  *float64
+	}{
+		{
+			name:  "identical",
+			daysA: week, daysB: week,
+			doneA: set(week[:4]...), doneB: set(week[:4]...),
+			want:        HabitPair{Days: 7, Both: 4, Either: 4, Jaccard: 1},
+			correlation: ptr(1.0),
+		},
+		{
+			name:  "opposite",
+			daysA: week, daysB: week,
+			doneA: set(week[:3]...), doneB: set(week[3:]...),
+			want:        HabitPair{Days: 7, Both: 0, Either: 7, Jaccard: 0},
+			correlation: ptr(-1.0),
+		},
+		{
+			name:  "constant series has no correlation",
+			daysA: week, daysB: week,
+			doneA: set(week...), doneB: set(week[:2]...),
+			want: HabitPair{Days: 7, Both: 2, Either: 7, Jaccard: 0.286},
+		},
+		{
+			name:  "too few shared days",
+			daysA: week, daysB: weekdays,
+			doneA: set(week[:2]...), doneB: set(weekdays[1:3]...),
+			want: HabitPair{Days: 5, Both: 1, Either: 3, Jaccard: 0.333},
+		},
+		{
+			name:  "nothing done",
+			daysA: week, daysB: week,
+			want: HabitPair{Days: 7},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := habitPair(tt.daysA, tt.daysB, tt.doneA, tt.doneB)
+			correlation := got.Correlation
+			got.Correlation = nil
+			if got != tt.want {
+				t.Errorf("habitPair() = %+v, want %+v", got, tt.want)
+			}
+			switch {
+			case (correlation == nil) != (tt.correlation == nil):
+				t.Errorf("correlation = %v, want %v", correlation, tt.correlation)
+			case correlation != nil && *correlation != *tt.correlation:
+				t.Errorf("correlation = %v, want %v", *correlation, *tt.correlation)
+			}
+		})
+	}
+}
+
+func TestWeekdayPatterns(t *testing.T) {
+	daily := &Habit{ID: 1, Schedule: ScheduleDaily}
+	weekends := &Habit{ID: 2, Schedule: ScheduleWeekends}
+	weekly := &Habit{ID: 3, Schedule: ScheduleWeekly}
+	list := []*Habit{daily, weekends, weekly}
+
+	// Two weeks from Monday 2026-10-05; weekly habits are due every day.
+	rng := summaryRange{From: time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)}
+	days := make([][]string, len(list))
+	for i, habit := range list {
+		days[i] = dueDays(habit, rng, time.UTC)
+	}
+	done := map[int64]map[string]bool{
+		// Both Mondays and one Wednesday.
+		1: {"2026-10-05": true, "2026-10-12": true, "2026-10-07": true},
+		// One Saturday, both Sundays.
+		2: {"2026-10-10": true, "2026-10-11": true, "2026-10-18": true},
+		3: {"2026-10-06": true, "2026-10-13": true},
+	}
+
+	want := []WeekdayPattern{
+		{"monday", 2, 2, 1},
+		{"tuesday", 2, 0, 0},
+		{"wednesday", 2, 1, 0.5},
+		{"thursday", 2, 0, 0},
+		{"friday", 2, 0, 0},
+		{"saturday", 4, 1, 0.25},
+		{"sunday", 4, 2, 0.5},
+	}
+	got := weekdayPatterns(list, days, done)
+	if len(got) != len(want) {
+		t.Fatalf("weekdayPatterns() returned %d days, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("weekdayPatterns()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWeekdayPatternsEmpty(t *testing.T) {
+	got := weekdayPatterns(nil, nil, nil)
+	if len(got) != 7 || got[0].Day != "monday" || got[6].Day != "sunday" {
+		t.Fatalf("weekdayPatterns(nil) = %+v, want seven empty days from monday", got)
+	}
+	for _, p := range got {
+		if p.Scheduled != 0 || p.Completed != 0 || p.CompletionRate != 0 {
+			t.Errorf("weekdayPatterns(nil) %s = %+v, want zero", p.Day, p)
+		}
+	}
+}
+
+func TestAnalyzeIsReproducible(t *testing.T) {
+	created := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	list := []*Habit{
+		{ID: 1, Name: "Run", Schedule: ScheduleDaily, CreatedAt: created},
+		{ID: 2, Name: "Read", Schedule: ScheduleDaily, CreatedAt: created},
+	}
+	rng := summaryRange{From: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)}
+	done := map[int64]map[string]bool{1: {}, 2: {}}
+	ratings := map[string]dayRating{}
+	for d := rng.From; !d.After(rng.To); d = d.AddDate(0, 0, 1) {
+		day := dayKey(d)
+		mood := 2.0
+		if d.Day()%2 == 0 {
+			done[1][day], done[2][day] = true, true
+			mood = 4
+		}
+		ratings[day] = dayRating{Mood: ptr(mood)}
+	}
+
+	first := analyze(list, done, ratings, rng, time.UTC)
+	for i := 0; i < 5; i++ {
+		again := analyze(list, done, ratings, rng, time.UTC)
+		if len(again.Pairs) != 1 || *again.Pairs[0].Correlation != *first.Pairs[0].Correlation {
+			t.Fatalf("analyze() is not reproducible: %+v then %+v", first.Pairs, again.Pairs)
+		}
+	}
+
+	if got := first.Habits[0].Mood.Correlation; got == nil || *got != 1 {
+		t.Errorf("mood correlation = %v, want 1", got)
+	}
+	if first.Habits[0].Energy.Correlation != nil {
+		t.Errorf("energy correlation = %v, want null without ratings", *first.Habits[0].Energy.Correlation)
+	}
+	if pair := first.Pairs[0]; pair.HabitIDs != [2]int64{1, 2} || pair.Jaccard != 1 {
+		t.Errorf("pair = %+v, want habits 1 and 2 with Jaccard 1", pair)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,233 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/validate"
+)
+
+// defaultCategoryColor is used when a category is created without a color.
+const defaultCategoryColor = "#808080"
+
+var colorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+func init() {
+	validate.Register("color", func(v reflect.Value, _ string) (string, string, bool) {
+		return "invalid_color", "must be a hex color such as #4CAF50", colorPattern.MatchString(v.String())
+	})
+}
+
+// Category groups habits under a colored heading such as health, work or
+// learning. A habit belongs to at most one category.
+type Category struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Color      string    `json:"color"`
+	HabitCount int       `json:"habit_count"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type CategoryRequest struct {
+	Name  string `json:"name" validate:"required,max=50"`
+	Color string `json:"color" validate:"color"`
+}
+
+type UpdateCategoryRequest struct {
+	Name  *string `json:"name" validate:"min=1,max=50"`
+	Color *string `json:"color" validate:"color"`
+}
+
+type CategoryListResponse struct {
+	Categories []Category `json:"categories"`
+}
+
+// CategoriesHandler lists (GET) and creates (POST) the caller's categories.
+func CategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	userID, err := authenticate(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		categories, err := listCategories(r.Context(), userID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		json.NewEncoder(w).Encode(CategoryListResponse{Categories: categories})
+	case http.MethodPost:
+		var req CategoryRequest
+		if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		category := Category{Name: strings.TrimSpace(req.Name), Color: strings.ToUpper(req.Color)}
+		if category.Color == "" {
+			category.Color = defaultCategoryColor
+		}
+		if err := createCategory(r.Context(), userID, &category); err != nil {
+			writeCategoryError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(category)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+// CategoryHandler updates (PATCH) or deletes (DELETE) a category. Habits in
+// a deleted category become uncategorized.
+func CategoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	categoryID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/categories/"), 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid category ID"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		var req UpdateCategoryRequest
+		if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if name == "" {
+				apierror.Write(w, r, apierror.Validation(apierror.FieldError{Field: "name", Code: "required", Message: "is required"}))
+				return
+			}
+			req.Name = &name
+		}
+		if req.Color != nil {
+			color := strings.ToUpper(*req.Color)
+			req.Color = &color
+		}
+		category, err := updateCategory(r.Context(), userID, categoryID, req)
+		if err != nil {
+			writeCategoryError(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(category)
+	case http.MethodDelete:
+		deleted, err := deleteCategory(r.Context(), userID, categoryID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		if !deleted {
+			apierror.Write(w, r, apierror.NotFound("Category not found"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+func writeCategoryError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == sql.ErrNoRows:
+		apierror.Write(w, r, apierror.NotFound("Category not found"))
+	case isUniqueViolation(err):
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "A category with this name already exists"))
+	default:
+		apierror.Write(w, r, apierror.Internal(err))
+	}
+}
+
+// checkCategory verifies that categoryID belongs to userID, writing a
+// validation error when it does not.
+func checkCategory(w http.ResponseWriter, r *http.Request, userID, categoryID int64) bool {
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM categories WHERE id = $1 AND user_id = $2)`
+	if err := db.QueryRowContext(r.Context(), query, categoryID, userID).Scan(&exists); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return false
+	}
+	if !exists {
+		apierror.Write(w, r, apierror.Validation(apierror.FieldError{Field: "category_id", Code: "not_found", Message: "category does not exist"}))
+	}
+	return exists
+}
+
+func listCategories(ctx context.Context, userID int64) ([]Category, error) {
+	query := `
+		SELECT c.id, c.name, c.color, c.created_at, COUNT(h.id)
+		FROM categories c LEFT JOIN habits h ON h.category_id = c.id AND h.user_id = c.user_id
+		WHERE c.user_id = $1
+		GROUP BY c.id
+		ORDER BY LOWER(c.name)
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Color, &c.CreatedAt, &c.HabitCount); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+func createCategory(ctx context.Context, userID int64, c *Category) error {
+	query := `INSERT INTO categories (user_id, name, color, created_at) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+	return db.QueryRowContext(ctx, query, userID, c.Name, c.Color, time.Now().UTC()).Scan(&c.ID, &c.CreatedAt)
+}
+
+// updateCategory returns sql.ErrNoRows when the category does not belong to
+// userID.
+func updateCategory(ctx context.Context, userID, categoryID int64, req UpdateCategoryRequest) (Category, error) {
+	c := Category{ID: categoryID}
+	query := `
+		UPDATE categories SET name = COALESCE($3, name), color = COALESCE($4, color)
+		WHERE id = $1 AND user_id = $2
+		RETURNING name, color, created_at,
+			(SELECT COUNT(*) FROM habits WHERE user_id = $2 AND category_id = $1)
+	`
+	err := db.QueryRowContext(ctx, query, categoryID, userID, req.Name, req.Color).
+		Scan(&c.Name, &c.Color, &c.CreatedAt, &c.HabitCount)
+	return c, err
+}
+
+func deleteCategory(ctx context.Context, userID, categoryID int64) (bool, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM categories WHERE id = $1 AND user_id = $2`, categoryID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
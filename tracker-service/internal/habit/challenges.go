@@ -0,0 +1,410 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/validate"
+)
+
+// Challenge metrics, also the leaderboard metrics.
+const (
+	MetricCompletions = "completions"
+	MetricStreak      = "streak"
+)
+
+const maxChallengeDays = 366
+
+// ChallengeRequest creates a group challenge. Participants each follow a
+// habit of their own for it, named HabitName by default; Target, if set,
+// is the score that completes the challenge.
+type ChallengeRequest struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=2000"`
+	HabitName   string `json:"habit_name" validate:"required,max=255"`
+	Metric      string `json:"metric" validate:"oneof=completions streak"`
+	Target      int    `json:"target" validate:"min=1,max=10000"`
+	// StartDate defaults to today in the group's time zone; both dates are
+	// inclusive.
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date" validate:"required"`
+}
+
+// JoinChallengeRequest links one of the caller's habits to a challenge.
+// Without HabitID a daily habit named after the challenge's habit is
+// created.
+type JoinChallengeRequest struct {
+	HabitID int64 `json:"habit_id" validate:"min=1"`
+}
+
+// Challenge is a time-boxed competition within a group. Status is
+// "upcoming", "active" or "ended" in the group's time zone; HabitID is the
+// caller's linked habit, if they take part.
+type Challenge struct {
+	ID           int64     `json:"id"`
+	GroupID      int64     `json:"group_id"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	HabitName    string    `json:"habit_name"`
+	Metric       string    `json:"metric"`
+	Target       *int      `json:"target"`
+	StartDate    string    `json:"start_date"`
+	EndDate      string    `json:"end_date"`
+	Status       string    `json:"status"`
+	CreatedBy    int64     `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+	Participants int       `json:"participants"`
+	HabitID      *int64    `json:"habit_id"`
+}
+
+type ChallengeListResponse struct {
+	Challenges []Challenge `json:"challenges"`
+}
+
+// GroupChallengesHandler lists a group's challenges (GET) and lets the
+// group's owner create one (POST).
+func GroupChallengesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	userID, err := authenticate(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/groups/"), "/challenges")
+	groupID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid group ID"))
+		return
+	}
+	group, ok := findGroup(w, r, userID, groupID)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := listChallenges(r.Context(), userID, group)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		json.NewEncoder(w).Encode(ChallengeListResponse{Challenges: list})
+	case http.MethodPost:
+		if group.Role != RoleOwner {
+			apierror.Write(w, r, apierror.Forbidden("Only the group's owner can create challenges"))
+			return
+		}
+		createChallenge(w, r, userID, group)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+func createChallenge(w http.ResponseWriter, r *http.Request, userID int64, group Group) {
+	var req ChallengeRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if req.Metric == "" {
+		req.Metric = MetricCompletions
+	}
+
+	today := civilDay(time.Now(), group.Location())
+	var details []apierror.FieldError
+	start := today
+	if req.StartDate != "" {
+		d, err := time.Parse(dateLayout, req.StartDate)
+		if err != nil {
+			details = append(details, apierror.FieldError{Field: "start_date", Code: "invalid_date", Message: "start_date must be a date in YYYY-MM-DD format"})
+		}
+		start = d
+	}
+	end, err := time.Parse(dateLayout, req.EndDate)
+	if err != nil {
+		details = append(details, apierror.FieldError{Field: "end_date", Code: "invalid_date", Message: "end_date must be a date in YYYY-MM-DD format"})
+	}
+	if len(details) == 0 {
+		switch {
+		case end.Before(start):
+			details = append(details, apierror.FieldError{Field: "end_date", Code: "out_of_range", Message: "end_date must not be before start_date"})
+		case end.Before(today):
+			details = append(details, apierror.FieldError{Field: "end_date", Code: "out_of_range", Message: "end_date must not be in the past"})
+		case end.Sub(start) >= maxChallengeDays*24*time.Hour:
+			details = append(details, apierror.FieldError{Field: "end_date", Code: "out_of_range", Message: "a challenge must not exceed " + strconv.Itoa(maxChallengeDays) + " days"})
+		}
+	}
+	if len(details) > 0 {
+		apierror.Write(w, r, apierror.Validation(details...))
+		return
+	}
+
+	challenge := Challenge{
+		GroupID:     group.ID,
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+		HabitName:   strings.TrimSpace(req.HabitName),
+		Metric:      req.Metric,
+		StartDate:   dayKey(start),
+		EndDate:     dayKey(end),
+		CreatedBy:   userID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if req.Target != 0 {
+		challenge.Target = &req.Target
+	}
+	challenge.Status = challengeStatus(challenge, today)
+
+	query := `
+		INSERT INTO group_challenges (group_id, name, description, habit_name, metric, target, start_date, end_date, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id
+	`
+	err = db.QueryRowContext(r.Context(), query, challenge.GroupID, challenge.Name, challenge.Description, challenge.HabitName,
+		challenge.Metric, challenge.Target, challenge.StartDate, challenge.EndDate, challenge.CreatedBy, challenge.CreatedAt).Scan(&challenge.ID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(challenge)
+}
+
+// GroupChallengeHandler returns (GET) or, for the group's owner, deletes
+// (DELETE) one challenge.
+func GroupChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	userID, err := authenticate(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	group, challenge, ok := findChallenge(w, r, userID, 3)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(challenge)
+	case http.MethodDelete:
+		if group.Role != RoleOwner {
+			apierror.Write(w, r, apierror.Forbidden("Only the group's owner can delete challenges"))
+			return
+		}
+		if _, err := db.ExecContext(r.Context(), `DELETE FROM group_challenges WHERE id = $1`, challenge.ID); err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+// JoinChallengeHandler takes part in a challenge (POST) or withdraws from
+// it (DELETE) at /groups/{id}/challenges/{challengeID}/join. Joining is
+// possible until the challenge ends.
+func JoinChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	_, challenge, ok := findChallenge(w, r, userID, 4)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if challenge.HabitID != nil {
+			apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "You have already joined this challenge"))
+			return
+		}
+		if challenge.Status == "ended" {
+			apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "This challenge has ended"))
+			return
+		}
+		// The body is optional.
+		var req JoinChallengeRequest
+		if r.ContentLength != 0 {
+			if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+				apierror.Write(w, r, err)
+				return
+			}
+		}
+		habitID, err := joinChallenge(r.Context(), userID, challenge, req.HabitID)
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		challenge.HabitID = &habitID
+		challenge.Participants++
+		json.NewEncoder(w).Encode(challenge)
+	case http.MethodDelete:
+		query := `DELETE FROM challenge_participants WHERE challenge_id = $1 AND user_id = $2`
+		result, err := db.ExecContext(r.Context(), query, challenge.ID, userID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			apierror.Write(w, r, apierror.NotFound("You have not joined this challenge"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+// joinChallenge enters userID into challenge and returns the habit they
+// take part with: habitID if it is one of their habits to build, or a new
+// daily habit, created in the same transaction as the entry.
+func joinChallenge(ctx context.Context, userID int64, challenge Challenge, habitID int64) (int64, error) {
+	join := func(q querier, habitID int64) error {
+		query := `
+			INSERT INTO challenge_participants (challenge_id, user_id, habit_id, joined_at)
+			VALUES ($1, $2, $3, $4)
+		`
+		_, err := q.ExecContext(ctx, query, challenge.ID, userID, habitID, time.Now().UTC())
+		if isUniqueViolation(err) {
+			return apierror.Conflict(apierror.CodeConflict, "You have already joined this challenge")
+		}
+		return err
+	}
+
+	if habitID == 0 {
+		habit, err := createLinkedHabit(ctx, userID, challenge.HabitName, challenge.Description, ScheduleDaily, join)
+		if err != nil {
+			return 0, err
+		}
+		return habit.ID, nil
+	}
+	habit, err := loadHabit(ctx, userID, habitID)
+	if err == sql.ErrNoRows {
+		return 0, apierror.Validation(apierror.FieldError{Field: "habit_id", Code: "not_found", Message: "habit_id must be one of your habits"})
+	}
+	if err != nil {
+		return 0, err
+	}
+	if habit.Polarity == PolarityBreak {
+		return 0, apierror.Validation(apierror.FieldError{Field: "habit_id", Code: "invalid_choice", Message: "break habits cannot take part in challenges"})
+	}
+	return habit.ID, join(db, habit.ID)
+}
+
+// findChallenge parses /groups/{id}/challenges/{challengeID}[/...] with
+// the given number of segments after /groups/ and loads the group and
+// challenge, writing the error to w if either is not found.
+func findChallenge(w http.ResponseWriter, r *http.Request, userID int64, segments int) (Group, Challenge, bool) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/groups/"), "/")
+	if len(parts) != segments {
+		apierror.Write(w, r, apierror.NotFound("Challenge not found"))
+		return Group{}, Challenge{}, false
+	}
+	groupID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid group ID"))
+		return Group{}, Challenge{}, false
+	}
+	challengeID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid challenge ID"))
+		return Group{}, Challenge{}, false
+	}
+	group, ok := findGroup(w, r, userID, groupID)
+	if !ok {
+		return Group{}, Challenge{}, false
+	}
+
+	query := `SELECT ` + challengeColumns + ` FROM group_challenges c WHERE c.group_id = $2 AND c.id = $3`
+	challenge, err := scanChallenge(db.QueryRowContext(r.Context(), query, userID, groupID, challengeID), civilDay(time.Now(), group.Location()))
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("Challenge not found"))
+		return Group{}, Challenge{}, false
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return Group{}, Challenge{}, false
+	}
+	return group, challenge, true
+}
+
+// challengeStatus places today relative to the challenge's dates.
+func challengeStatus(c Challenge, today time.Time) string {
+	switch key := dayKey(today); {
+	case key < c.StartDate:
+		return "upcoming"
+	case key > c.EndDate:
+		return "ended"
+	default:
+		return "active"
+	}
+}
+
+// challengeColumns expects the caller's user ID as $1.
+const challengeColumns = `c.id, c.group_id, c.name, c.description, c.habit_name, c.metric, c.target,
+	c.start_date, c.end_date, c.created_by, c.created_at,
+	(SELECT COUNT(*) FROM challenge_participants p WHERE p.challenge_id = c.id),
+	(SELECT p.habit_id FROM challenge_participants p WHERE p.challenge_id = c.id AND p.user_id = $1)`
+
+func scanChallenge(row rowScanner, today time.Time) (Challenge, error) {
+	var c Challenge
+	var target, habitID sql.NullInt64
+	var start, end time.Time
+	err := row.Scan(&c.ID, &c.GroupID, &c.Name, &c.Description, &c.HabitName, &c.Metric, &target,
+		&start, &end, &c.CreatedBy, &c.CreatedAt, &c.Participants, &habitID)
+	if err != nil {
+		return c, err
+	}
+	c.StartDate, c.EndDate = dayKey(start), dayKey(end)
+	if target.Valid {
+		n := int(target.Int64)
+		c.Target = &n
+	}
+	if habitID.Valid {
+		c.HabitID = &habitID.Int64
+	}
+	c.Status = challengeStatus(c, today)
+	return c, nil
+}
+
+func listChallenges(ctx context.Context, userID int64, group Group) ([]Challenge, error) {
+	query := `SELECT ` + challengeColumns + ` FROM group_challenges c WHERE c.group_id = $2 ORDER BY c.start_date DESC, c.id DESC`
+	rows, err := db.QueryContext(ctx, query, userID, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	today := civilDay(time.Now(), group.Location())
+	list := []Challenge{}
+	for rows.Next() {
+		c, err := scanChallenge(rows, today)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
@@ -1,11 +1,16 @@
 package habit
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+
+	"habit-tracker/pkg/tracing"
 )
 
 const (
@@ -18,83 +23,385 @@ const (
 
 var db *sql.DB
 
-func initDB() {
+// DB returns the connection pool opened by InitDB.
+func DB() *sql.DB {
+	return db
+}
+
+func InitDB() error {
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
 
 	var err error
-	db, err = sql.Open("postgres", psqlInfo)
+	db, err = tracing.OpenPostgres(psqlInfo)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	err = db.Ping()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	return createTables()
+}
+
+// schema is applied in order by createTables; every statement must be
+// idempotent.
+var schema = []string{
+	// Habits use a composite primary key: IDs count up per user.
+	`CREATE TABLE IF NOT EXISTS habits (
+		id BIGINT NOT NULL,
+		user_id BIGINT NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		description TEXT,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS track_records (
+		id SERIAL PRIMARY KEY,
+		habit_id BIGINT NOT NULL,
+		user_id BIGINT NOT NULL,
+		completed BOOLEAN NOT NULL,
+		date TIMESTAMP NOT NULL,
+		FOREIGN KEY (user_id, habit_id) REFERENCES habits(user_id, id) ON DELETE CASCADE
+	)`,
+	`ALTER TABLE habits
+		ADD COLUMN IF NOT EXISTS schedule VARCHAR(16) NOT NULL DEFAULT 'daily',
+		ADD COLUMN IF NOT EXISTS position INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP`,
+	// Keyset pagination indexes for each sort order of GET /habits.
+	`CREATE INDEX IF NOT EXISTS habits_user_created_idx ON habits (user_id, created_at, id)`,
+	`CREATE INDEX IF NOT EXISTS habits_user_name_idx ON habits (user_id, LOWER(name), id)`,
+	`CREATE INDEX IF NOT EXISTS habits_user_position_idx ON habits (user_id, position, id)`,
+	`CREATE TABLE IF NOT EXISTS tags (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL,
+		name VARCHAR(50) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS tags_user_name_idx ON tags (user_id, LOWER(name))`,
+	`CREATE TABLE IF NOT EXISTS habit_tags (
+		user_id BIGINT NOT NULL,
+		habit_id BIGINT NOT NULL,
+		tag_id BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+		PRIMARY KEY (user_id, habit_id, tag_id),
+		FOREIGN KEY (user_id, habit_id) REFERENCES habits(user_id, id) ON DELETE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS habit_tags_tag_id_idx ON habit_tags (tag_id)`,
+	`CREATE TABLE IF NOT EXISTS categories (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL,
+		name VARCHAR(50) NOT NULL,
+		color VARCHAR(7) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS categories_user_name_idx ON categories (user_id, LOWER(name))`,
+	`ALTER TABLE habits ADD COLUMN IF NOT EXISTS category_id BIGINT REFERENCES categories(id) ON DELETE SET NULL`,
+	`ALTER TABLE track_records
+		ADD COLUMN IF NOT EXISTS note TEXT,
+		ADD COLUMN IF NOT EXISTS mood SMALLINT CHECK (mood BETWEEN 1 AND 5),
+		ADD COLUMN IF NOT EXISTS energy SMALLINT CHECK (energy BETWEEN 1 AND 5),
+		ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}',
+		ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP`,
+	`CREATE INDEX IF NOT EXISTS track_records_user_habit_date_idx ON track_records (user_id, habit_id, date)`,
+	// Must match the expression searchRecords filters on.
+	`CREATE INDEX IF NOT EXISTS track_records_note_search_idx ON track_records
+		USING GIN (to_tsvector('simple', COALESCE(note, '')))`,
+	`ALTER TABLE habits ADD COLUMN IF NOT EXISTS polarity VARCHAR(8) NOT NULL DEFAULT 'build'`,
+	`CREATE TABLE IF NOT EXISTS goals (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		kind VARCHAR(16) NOT NULL,
+		target INTEGER NOT NULL,
+		start_date DATE NOT NULL,
+		deadline DATE NOT NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'active',
+		created_at TIMESTAMP NOT NULL,
+		completed_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS goals_user_id_idx ON goals (user_id, deadline)`,
+	`CREATE TABLE IF NOT EXISTS goal_habits (
+		goal_id BIGINT NOT NULL REFERENCES goals(id) ON DELETE CASCADE,
+		user_id BIGINT NOT NULL,
+		habit_id BIGINT NOT NULL,
+		PRIMARY KEY (goal_id, habit_id),
+		FOREIGN KEY (user_id, habit_id) REFERENCES habits(user_id, id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS achievements (
+		user_id BIGINT NOT NULL,
+		code VARCHAR(32) NOT NULL,
+		unlocked_at TIMESTAMP NOT NULL,
+		habit_id BIGINT,
+		PRIMARY KEY (user_id, code)
+	)`,
+	`CREATE TABLE IF NOT EXISTS vacations (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL,
+		start_date DATE NOT NULL,
+		end_date DATE NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS vacations_user_id_idx ON vacations (user_id, start_date)`,
+	// A freeze is earned by a habit's streak on earned_day and, once
+	// consumed, covers frozen_day of habit_id.
+	`CREATE TABLE IF NOT EXISTS streak_freezes (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL,
+		earned_at TIMESTAMP NOT NULL,
+		earned_habit_id BIGINT NOT NULL,
+		earned_day DATE NOT NULL,
+		habit_id BIGINT,
+		frozen_day DATE,
+		consumed_at TIMESTAMP,
+		UNIQUE (user_id, earned_habit_id, earned_day),
+		UNIQUE (user_id, habit_id, frozen_day)
+	)`,
+	// One row per pair of users whichever way the invitation went.
+	`CREATE TABLE IF NOT EXISTS partnerships (
+		id BIGSERIAL PRIMARY KEY,
+		requester_id BIGINT NOT NULL,
+		addressee_id BIGINT NOT NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'pending',
+		created_at TIMESTAMP NOT NULL,
+		accepted_at TIMESTAMP,
+		CHECK (requester_id <> addressee_id)
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS partnerships_pair_idx
+		ON partnerships (LEAST(requester_id, addressee_id), GREATEST(requester_id, addressee_id))`,
+	`CREATE INDEX IF NOT EXISTS partnerships_addressee_idx ON partnerships (addressee_id)`,
+	`CREATE TABLE IF NOT EXISTS habit_shares (
+		user_id BIGINT NOT NULL,
+		habit_id BIGINT NOT NULL,
+		partner_id BIGINT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, habit_id, partner_id),
+		FOREIGN KEY (user_id, habit_id) REFERENCES habits(user_id, id) ON DELETE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS habit_shares_partner_idx ON habit_shares (partner_id, user_id)`,
+	`CREATE TABLE IF NOT EXISTS shared_habits (
+		id BIGSERIAL PRIMARY KEY,
+		owner_id BIGINT NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		schedule VARCHAR(16) NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`,
+	// Invited members have no habit_id or joined_at yet. Deleting the
+	// member's own habit leaves the shared habit.
+	`CREATE TABLE IF NOT EXISTS shared_habit_members (
+		shared_habit_id BIGINT NOT NULL REFERENCES shared_habits(id) ON DELETE CASCADE,
+		user_id BIGINT NOT NULL,
+		habit_id BIGINT,
+		invited_at TIMESTAMP NOT NULL,
+		joined_at TIMESTAMP,
+		PRIMARY KEY (shared_habit_id, user_id),
+		FOREIGN KEY (user_id, habit_id) REFERENCES habits(user_id, id) ON DELETE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS shared_habit_members_user_idx ON shared_habit_members (user_id)`,
+	`CREATE TABLE IF NOT EXISTS groups (
+		id BIGSERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		owner_id BIGINT NOT NULL,
+		time_zone VARCHAR(64) NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`,
+	// Invited members have no joined_at yet and are never ranked.
+	`CREATE TABLE IF NOT EXISTS group_members (
+		group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+		user_id BIGINT NOT NULL,
+		role VARCHAR(16) NOT NULL,
+		visibility VARCHAR(16) NOT NULL,
+		invited_at TIMESTAMP NOT NULL,
+		joined_at TIMESTAMP,
+		PRIMARY KEY (group_id, user_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS group_members_user_idx ON group_members (user_id)`,
+	`CREATE TABLE IF NOT EXISTS group_challenges (
+		id BIGSERIAL PRIMARY KEY,
+		group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		habit_name VARCHAR(255) NOT NULL,
+		metric VARCHAR(16) NOT NULL,
+		target INTEGER,
+		start_date DATE NOT NULL,
+		end_date DATE NOT NULL,
+		created_by BIGINT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS group_challenges_group_idx ON group_challenges (group_id, start_date)`,
+	`CREATE TABLE IF NOT EXISTS challenge_participants (
+		challenge_id BIGINT NOT NULL REFERENCES group_challenges(id) ON DELETE CASCADE,
+		user_id BIGINT NOT NULL,
+		habit_id BIGINT NOT NULL,
+		joined_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (challenge_id, user_id),
+		FOREIGN KEY (user_id, habit_id) REFERENCES habits(user_id, id) ON DELETE CASCADE
+	)`,
+	// Leaderboards rank on completed records by user and date.
+	`CREATE INDEX IF NOT EXISTS track_records_user_completed_date_idx ON track_records (user_id, date) WHERE completed`,
+}
+
+// uniqueViolation is the Postgres SQLSTATE for unique constraint violations.
+const uniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+func createTables() error {
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// habitColumns is the column list read by scanHabit, for a habits table
+// aliased as h.
+const habitColumns = `h.id, h.user_id, h.name, h.description, h.created_at, h.schedule, h.position, h.archived_at, h.category_id, h.polarity`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanHabit(row rowScanner) (*Habit, error) {
+	var habit Habit
+	var archivedAt sql.NullTime
+	var categoryID sql.NullInt64
+	err := row.Scan(&habit.ID, &habit.UserID, &habit.Name, &habit.Description, &habit.CreatedAt,
+		&habit.Schedule, &habit.Position, &archivedAt, &categoryID, &habit.Polarity)
+	if archivedAt.Valid {
+		habit.ArchivedAt = &archivedAt.Time
+	}
+	if categoryID.Valid {
+		habit.CategoryID = &categoryID.Int64
+	}
+	habit.Tags = []string{}
+	return &habit, err
+}
+
+// saveHabit inserts habit with its tags, appending it after the user's
+// other habits in manual order.
+func saveHabit(ctx context.Context, habit *Habit) error {
+	return saveHabitWith(ctx, habit, nil)
+}
+
+// saveHabitWith is saveHabit that also runs then, if non-nil, in the same
+// transaction once habit has its ID, so either both are stored or neither.
+func saveHabitWith(ctx context.Context, habit *Habit, then func(q querier) error) error {
+	// Get the next habit ID for this user
+	nextID, err := getNextHabitID(ctx, habit.UserID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	createTables()
+	query := `
+		INSERT INTO habits (id, user_id, name, description, created_at, schedule, category_id, polarity, position)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8,
+			(SELECT COALESCE(MAX(position), 0) + 1 FROM habits WHERE user_id = $2))
+		RETURNING id, position
+	`
+	err = tx.QueryRowContext(ctx, query, nextID, habit.UserID, habit.Name, habit.Description, habit.CreatedAt, habit.Schedule, habit.CategoryID, habit.Polarity).
+		Scan(&habit.ID, &habit.Position)
+	if err != nil {
+		return err
+	}
+	if err := setHabitTags(ctx, tx, habit.UserID, habit.ID, habit.Tags); err != nil {
+		return err
+	}
+	if then != nil {
+		if err := then(tx); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func createTables() {
-	// Create habits table with composite primary key
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS habits (
-			id BIGINT NOT NULL,
-			user_id BIGINT NOT NULL,
-			name VARCHAR(255) NOT NULL,
-			description TEXT,
-			created_at TIMESTAMP NOT NULL,
-			PRIMARY KEY (user_id, id)
-		)
-	`)
+// loadHabit returns one of userID's habits with its tags, or sql.ErrNoRows.
+func loadHabit(ctx context.Context, userID, habitID int64) (*Habit, error) {
+	query := `SELECT ` + habitColumns + ` FROM habits h WHERE h.user_id = $1 AND h.id = $2`
+	habit, err := scanHabit(db.QueryRowContext(ctx, query, userID, habitID))
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Create track_records table with composite foreign key
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS track_records (
-			id SERIAL PRIMARY KEY,
-			habit_id BIGINT NOT NULL,
-			user_id BIGINT NOT NULL,
-			completed BOOLEAN NOT NULL,
-			date TIMESTAMP NOT NULL,
-			FOREIGN KEY (user_id, habit_id) REFERENCES habits(user_id, id) ON DELETE CASCADE
-		)
-	`)
+		return nil, err
+	}
+	tags, err := loadHabitTags(ctx, userID, []int64{habitID})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if t, ok := tags[habitID]; ok {
+		habit.Tags = t
 	}
+	return habit, nil
 }
 
-func saveHabit(habit *Habit) error {
-	// Get the next habit ID for this user
-	nextID, err := getNextHabitID(habit.UserID)
+// updateHabit writes the fields of req that are non-nil.
+func updateHabit(ctx context.Context, userID, habitID int64, req UpdateHabitRequest) error {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
+	var archived sql.NullBool
+	if req.Archived != nil {
+		archived = sql.NullBool{Bool: *req.Archived, Valid: true}
+	}
 	query := `
-		INSERT INTO habits (id, user_id, name, description, created_at)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id
+		UPDATE habits SET
+			name = COALESCE($3, name),
+			description = COALESCE($4, description),
+			schedule = COALESCE($5, schedule),
+			position = COALESCE($6, position),
+			archived_at = CASE
+				WHEN $7::boolean IS NULL THEN archived_at
+				WHEN $7 THEN COALESCE(archived_at, $8)
+				ELSE NULL
+			END,
+			category_id = CASE
+				WHEN $9::bigint IS NULL THEN category_id
+				ELSE NULLIF($9, 0)
+			END
+		WHERE user_id = $1 AND id = $2
 	`
-	err = db.QueryRow(query, nextID, habit.UserID, habit.Name, habit.Description, habit.CreatedAt).Scan(&habit.ID)
+	_, err = tx.ExecContext(ctx, query, userID, habitID, req.Name, req.Description, req.Schedule, req.Position, archived, time.Now(), req.CategoryID)
 	if err != nil {
 		return err
 	}
-	return nil
+	if req.Tags != nil {
+		if err := setHabitTags(ctx, tx, userID, habitID, *req.Tags); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func getNextHabitID(userID int64) (int64, error) {
+func getNextHabitID(ctx context.Context, userID int64) (int64, error) {
 	var maxID sql.NullInt64
 	query := `
 		SELECT MAX(id)
 		FROM habits
 		WHERE user_id = $1
 	`
-	err := db.QueryRow(query, userID).Scan(&maxID)
+	err := db.QueryRowContext(ctx, query, userID).Scan(&maxID)
 	if err != nil {
 		return 0, err
 	}
@@ -104,53 +411,32 @@ func getNextHabitID(userID int64) (int64, error) {
 	return maxID.Int64 + 1, nil
 }
 
-func saveTrackRecord(record *TrackRecord) error {
-	query := `
-		INSERT INTO track_records (habit_id, user_id, completed, date)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id
-	`
-	err := db.QueryRow(query, record.HabitID, record.UserID, record.Completed, record.Date).Scan(&record.ID)
+func saveTrackRecord(ctx context.Context, record *TrackRecord) error {
+	metadata, err := json.Marshal(record.Metadata)
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func loadHabits(userID int64) (map[int64]*Habit, error) {
-	habits := make(map[int64]*Habit)
 	query := `
-		SELECT id, name, description, created_at
-		FROM habits
-		WHERE user_id = $1
+		INSERT INTO track_records (habit_id, user_id, completed, date, note, mood, energy, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8::jsonb)
+		RETURNING id
 	`
-	rows, err := db.Query(query, userID)
+	err = db.QueryRowContext(ctx, query, record.HabitID, record.UserID, record.Completed, record.Date,
+		record.Note, record.Mood, record.Energy, string(metadata)).Scan(&record.ID)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var habit Habit
-		err := rows.Scan(&habit.ID, &habit.Name, &habit.Description, &habit.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		habit.UserID = userID
-		habits[habit.ID] = &habit
+		return err
 	}
-
-	return habits, nil
+	return nil
 }
 
-func loadTrackRecords(userID int64) (map[int64][]*TrackRecord, error) {
+func loadTrackRecords(ctx context.Context, userID int64) (map[int64][]*TrackRecord, error) {
 	records := make(map[int64][]*TrackRecord)
 	query := `
 		SELECT id, habit_id, completed, date
 		FROM track_records
 		WHERE user_id = $1
 	`
-	rows, err := db.Query(query, userID)
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -167,4 +453,66 @@ func loadTrackRecords(userID int64) (map[int64][]*TrackRecord, error) {
 	}
 
 	return records, nil
-} 
\ No newline at end of file
+}
+
+// loadHabitRecords returns the track records of a single habit.
+func loadHabitRecords(ctx context.Context, userID, habitID int64) ([]*TrackRecord, error) {
+	query := `SELECT ` + recordColumns + ` FROM track_records WHERE user_id = $1 AND habit_id = $2 ORDER BY date, id`
+	return queryRecords(ctx, query, userID, habitID)
+}
+
+// deleteUserData removes all habits and track records owned by userID.
+func deleteUserData(ctx context.Context, userID int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM track_records WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM habits WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM categories WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM goals WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM achievements WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM vacations WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM streak_freezes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM partnerships WHERE requester_id = $1 OR addressee_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM habit_shares WHERE user_id = $1 OR partner_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM shared_habits WHERE owner_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM shared_habit_members WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM groups WHERE owner_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM group_members WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM challenge_participants WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
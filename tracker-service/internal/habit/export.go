@@ -0,0 +1,453 @@
+package habit
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+)
+
+// exportWriteTimeout replaces the server's write timeout for exports, which
+// can take a while to stream for long histories.
+const exportWriteTimeout = 10 * time.Minute
+
+// exportField is a top-level member of a JSON export written before the
+// streamed track records.
+type exportField struct {
+	Name  string
+	Value any
+}
+
+// ExportHandler answers GET /export?format=json|csv|ics with all of the
+// caller's habits and track records. Records are streamed from the
+// database to the client as they are read, never held in memory together.
+//   - json: {"exported_at", "user_id", "time_zone", "habits", "track_records"}
+//   - csv: one row per track record with its habit's columns; habits
+//     without records get a row with the record columns empty
+//   - ics: an all-day event for every day a habit was completed, in the
+//     caller's time zone
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := authenticateCaller(r, authz.ScopeRead)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = "json"
+	case "json", "csv", "ics":
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		apierror.Write(w, r, apierror.Validation(apierror.FieldError{Field: "format", Code: "invalid_choice", Message: "format must be one of: json, csv, ics"}))
+		return
+	}
+
+	var fields []exportField
+	if format == "json" {
+		list, _, err := queryHabits(r.Context(), c.UserID, habitQuery{Sort: "created", Archived: "all"})
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		fields = []exportField{
+			{"exported_at", time.Now().UTC()},
+			{"user_id", c.UserID},
+			{"time_zone", c.Location().String()},
+			{"habits", list},
+		}
+	}
+
+	var rows *sql.Rows
+	switch format {
+	case "json":
+		rows, err = queryExportRecords(r.Context(), c.UserID)
+	case "csv":
+		rows, err = db.QueryContext(r.Context(), csvExportQuery, c.UserID)
+	case "ics":
+		rows, err = db.QueryContext(r.Context(), icsExportQuery, c.UserID, c.Location().String())
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	defer rows.Close()
+
+	bw := startExport(w, format, "habits")
+	switch format {
+	case "json":
+		err = writeJSONExport(bw, rows, fields)
+	case "csv":
+		err = writeCSVExport(bw, rows)
+	case "ics":
+		err = writeICSExport(bw, rows, c.UserID)
+	}
+	finishExport(r, bw, c.UserID, err)
+}
+
+// AccountExportHandler answers GET /export/account with everything held
+// about the caller in both services, as JSON: the user service's profile,
+// sessions, API keys and login history under "account", then all of the
+// tracker's data, with track records streamed last.
+func AccountExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	c, err := authenticateCaller(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	fields, err := accountExportFields(r.Context(), c)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	rows, err := queryExportRecords(r.Context(), c.UserID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	defer rows.Close()
+
+	bw := startExport(w, "json", "account")
+	finishExport(r, bw, c.UserID, writeJSONExport(bw, rows, fields))
+}
+
+// accountExportFields loads everything but the track records, so that a
+// failure is reported before the response starts.
+func accountExportFields(ctx context.Context, c caller) ([]exportField, error) {
+	loc := c.Location()
+	account, err := fetchAccountExport(ctx, c.UserID)
+	if err != nil {
+		return nil, err
+	}
+	habitList, _, err := queryHabits(ctx, c.UserID, habitQuery{Sort: "created", Archived: "all"})
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	tags, err := listTags(ctx, c.UserID)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	categories, err := listCategories(ctx, c.UserID)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	goals, err := listGoals(ctx, c.UserID, "all", loc)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	earned, err := loadAchievements(ctx, c.UserID)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	achievements := []Achievement{}
+	for _, rule := range achievementRules {
+		if e, ok := earned[rule.Code]; ok {
+			achievements = append(achievements, Achievement{Code: rule.Code, Name: rule.Name, Description: rule.Description,
+				Unlocked: true, UnlockedAt: e.UnlockedAt, HabitID: e.HabitID})
+		}
+	}
+	vacations, err := listVacations(ctx, c.UserID)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	freezes, err := listFreezes(ctx, c.UserID)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	partnerships, err := listPartnerships(ctx, c.UserID)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	partners, err := describePartners(ctx, c.UserID, partnerships)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := listGroups(ctx, c.UserID)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	shared, err := listSharedHabits(ctx, c.UserID)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+
+	return []exportField{
+		{"exported_at", time.Now().UTC()},
+		{"user_id", c.UserID},
+		{"account", account},
+		{"habits", habitList},
+		{"tags", tags},
+		{"categories", categories},
+		{"goals", goals},
+		{"achievements", achievements},
+		{"vacations", vacations},
+		{"freezes", freezes},
+		{"partners", partners},
+		{"groups", groups},
+		{"shared_habits", shared},
+	}, nil
+}
+
+// startExport sends the headers of a file download and lifts the server's
+// write timeout. Output is buffered in modest chunks that go out as they
+// fill.
+func startExport(w http.ResponseWriter, format, name string) *bufio.Writer {
+	contentType := map[string]string{
+		"json": "application/json",
+		"csv":  "text/csv; charset=utf-8",
+		"ics":  "text/calendar; charset=utf-8",
+	}[format]
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format(dateLayout), format)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	// Not every writer supports deadlines; the default timeout then applies.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	w.WriteHeader(http.StatusOK)
+	return bufio.NewWriterSize(w, 32<<10)
+}
+
+// finishExport flushes the export. The status line has already been sent,
+// so a failure part way through aborts the connection rather than end the
+// body cleanly: the client sees a broken download, not a short file.
+func finishExport(r *http.Request, bw *bufio.Writer, userID int64, err error) {
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "export failed", "user_id", userID, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// queryExportRecords starts reading all of userID's track records. The
+// rows arrive as the response is written, so the export is streamed end
+// to end.
+func queryExportRecords(ctx context.Context, userID int64) (*sql.Rows, error) {
+	query := `SELECT ` + recordColumns + ` FROM track_records WHERE user_id = $1 ORDER BY habit_id, date, id`
+	return db.QueryContext(ctx, query, userID)
+}
+
+// writeJSONExport writes fields as a JSON object followed by a
+// "track_records" array of rows.
+func writeJSONExport(w io.Writer, rows *sql.Rows, fields []exportField) error {
+	io.WriteString(w, "{")
+	for _, f := range fields {
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%q:%s,", f.Name, value)
+	}
+	io.WriteString(w, `"track_records":[`)
+
+	for first := true; rows.Next(); first = false {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if !first {
+			io.WriteString(w, ",")
+		}
+		if _, err := w.Write(value); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "]}\n")
+	return err
+}
+
+var csvHeader = []string{
+	"habit_id", "habit_name", "schedule", "polarity", "habit_created_at", "archived_at",
+	"record_id", "date", "completed", "note", "mood", "energy", "metadata",
+}
+
+// csvExportQuery joins records to their habits in the database so that
+// nothing is looked up per row.
+const csvExportQuery = `
+	SELECT h.id, h.name, h.schedule, h.polarity, h.created_at, h.archived_at,
+		t.id, t.date, t.completed, t.note, t.mood, t.energy, t.metadata
+	FROM habits h
+	LEFT JOIN track_records t ON t.user_id = h.user_id AND t.habit_id = h.id
+	WHERE h.user_id = $1
+	ORDER BY h.id, t.date, t.id
+`
+
+// writeCSVExport writes a header and one row per row of csvExportQuery.
+func writeCSVExport(w io.Writer, rows *sql.Rows) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var habitID int64
+		var name, schedule, polarity string
+		var createdAt time.Time
+		var archivedAt, date sql.NullTime
+		var recordID, mood, energy sql.NullInt64
+		var completed sql.NullBool
+		var note, metadata sql.NullString
+		if err := rows.Scan(&habitID, &name, &schedule, &polarity, &createdAt, &archivedAt,
+			&recordID, &date, &completed, &note, &mood, &energy, &metadata); err != nil {
+			return err
+		}
+		row := []string{
+			strconv.FormatInt(habitID, 10), name, schedule, polarity, createdAt.UTC().Format(time.RFC3339), csvTime(archivedAt),
+			csvInt(recordID), csvTime(date), "", note.String, csvInt(mood), csvInt(energy), "",
+		}
+		if completed.Valid {
+			row[8] = strconv.FormatBool(completed.Bool)
+		}
+		if metadata.Valid && metadata.String != "{}" {
+			row[12] = metadata.String
+		}
+		for i := range row {
+			row[i] = csvCell(row[i])
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvCell keeps spreadsheets from reading a value as a formula by
+// prefixing it with an apostrophe when it starts with =, +, - or @.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func csvTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.UTC().Format(time.RFC3339)
+}
+
+func csvInt(n sql.NullInt64) string {
+	if !n.Valid {
+		return ""
+	}
+	return strconv.FormatInt(n.Int64, 10)
+}
+
+// icsExportQuery groups completed records into days in the time zone $2,
+// so repeated tracking on one day is a single event. Break habits have no
+// completions and so no events.
+const icsExportQuery = `
+	SELECT h.id, h.name, (t.date AT TIME ZONE 'UTC' AT TIME ZONE $2)::date AS day
+	FROM track_records t
+	JOIN habits h ON h.user_id = t.user_id AND h.id = t.habit_id
+	WHERE t.user_id = $1 AND t.completed
+	GROUP BY h.id, h.name, day
+	ORDER BY day, h.id
+`
+
+// writeICSExport writes an iCalendar (RFC 5545) file with an all-day event
+// for every row of icsExportQuery.
+func writeICSExport(w io.Writer, rows *sql.Rows, userID int64) error {
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, line := range []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//habit-tracker//export//EN",
+		"CALSCALE:GREGORIAN",
+		"X-WR-CALNAME:Habits",
+	} {
+		writeICSLine(w, line)
+	}
+
+	for rows.Next() {
+		var habitID int64
+		var name string
+		var day time.Time
+		if err := rows.Scan(&habitID, &name, &day); err != nil {
+			return err
+		}
+		start := day.Format("20060102")
+		writeICSLine(w, "BEGIN:VEVENT")
+		writeICSLine(w, fmt.Sprintf("UID:%d-%d-%s@habit-tracker", userID, habitID, start))
+		writeICSLine(w, "DTSTAMP:"+stamp)
+		writeICSLine(w, "DTSTART;VALUE=DATE:"+start)
+		writeICSLine(w, "DTEND;VALUE=DATE:"+day.AddDate(0, 0, 1).Format("20060102"))
+		writeICSLine(w, "SUMMARY:"+icsText("✓ "+name))
+		writeICSLine(w, "TRANSP:TRANSPARENT")
+		if err := writeICSLine(w, "END:VEVENT"); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return writeICSLine(w, "END:VCALENDAR")
+}
+
+// icsText escapes a TEXT property value.
+func icsText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// writeICSLine writes a content line with CRLF, folding it into lines of
+// at most 75 octets without splitting a UTF-8 sequence.
+func writeICSLine(w io.Writer, line string) error {
+	var b strings.Builder
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts.
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
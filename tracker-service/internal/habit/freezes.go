@@ -0,0 +1,558 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/validate"
+)
+
+const (
+	// freezeEarnDays is the streak length (and every multiple of it) that
+	// earns a streak freeze.
+	freezeEarnDays = 7
+	// maxFreezes is how many unused freezes a user can hold.
+	maxFreezes      = 2
+	maxVacationDays = 90
+)
+
+// Freeze is a streak freeze token. Once consumed it records which habit's
+// missed day it covered.
+type Freeze struct {
+	ID            int64      `json:"id"`
+	EarnedAt      time.Time  `json:"earned_at"`
+	EarnedHabitID int64      `json:"earned_habit_id"`
+	ConsumedAt    *time.Time `json:"consumed_at"`
+	HabitID       *int64     `json:"habit_id"`
+	Day           *string    `json:"day"`
+}
+
+type FreezeListResponse struct {
+	Available int      `json:"available"`
+	Max       int      `json:"max"`
+	Freezes   []Freeze `json:"freezes"`
+}
+
+// Vacation is an inclusive range of dates on which no habit is due.
+type Vacation struct {
+	ID        int64     `json:"id"`
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type VacationRequest struct {
+	StartDate string `json:"start_date" validate:"required"`
+	EndDate   string `json:"end_date" validate:"required"`
+}
+
+type VacationListResponse struct {
+	Vacations []Vacation `json:"vacations"`
+}
+
+// FreezesHandler answers GET /freezes with the caller's freeze balance and
+// the history of earned and consumed freezes, newest first.
+func FreezesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeRead)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	freezes, err := listFreezes(r.Context(), userID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	response := FreezeListResponse{Max: maxFreezes, Freezes: freezes}
+	for _, f := range freezes {
+		if f.ConsumedAt == nil {
+			response.Available++
+		}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// VacationsHandler lists (GET) and schedules (POST) vacations.
+func VacationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	c, err := authenticateCaller(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	userID := c.UserID
+
+	switch r.Method {
+	case http.MethodGet:
+		vacations, err := listVacations(r.Context(), userID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		json.NewEncoder(w).Encode(VacationListResponse{Vacations: vacations})
+	case http.MethodPost:
+		var req VacationRequest
+		if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		start, end, details := parseVacation(req, civilDay(time.Now(), c.Location()))
+		if len(details) > 0 {
+			apierror.Write(w, r, apierror.Validation(details...))
+			return
+		}
+		vacation, err := createVacation(r.Context(), userID, start, end)
+		if err == errVacationOverlap {
+			apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "The dates overlap another vacation"))
+			return
+		}
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(vacation)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+// VacationHandler cancels a vacation with DELETE /vacations/{id}.
+func VacationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	vacationID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/vacations/"), 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid vacation ID"))
+		return
+	}
+	result, err := db.ExecContext(r.Context(), `DELETE FROM vacations WHERE id = $1 AND user_id = $2`, vacationID, userID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, r, apierror.NotFound("Vacation not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseVacation validates a vacation request. A vacation cannot start
+// before today: taking past days off would mend streaks already broken.
+func parseVacation(req VacationRequest, today time.Time) (time.Time, time.Time, []apierror.FieldError) {
+	var details []apierror.FieldError
+	start, err := time.Parse(dateLayout, req.StartDate)
+	if err != nil {
+		details = append(details, apierror.FieldError{Field: "start_date", Code: "invalid_date", Message: "start_date must be a date in YYYY-MM-DD format"})
+	}
+	end, err := time.Parse(dateLayout, req.EndDate)
+	if err != nil {
+		details = append(details, apierror.FieldError{Field: "end_date", Code: "invalid_date", Message: "end_date must be a date in YYYY-MM-DD format"})
+	}
+	if len(details) == 0 {
+		if start.Before(today) {
+			details = append(details, apierror.FieldError{Field: "start_date", Code: "out_of_range", Message: "start_date must not be in the past"})
+		} else if end.Before(start) {
+			details = append(details, apierror.FieldError{Field: "end_date", Code: "out_of_range", Message: "end_date must not be before start_date"})
+		} else if end.Sub(start) >= maxVacationDays*24*time.Hour {
+			details = append(details, apierror.FieldError{Field: "end_date", Code: "out_of_range", Message: "a vacation must not exceed " + strconv.Itoa(maxVacationDays) + " days"})
+		}
+	}
+	return start, end, details
+}
+
+// freezeGap returns the missed due days just before a habit's current
+// streak (or just before today, if the streak is already broken) when
+// freezing them would reconnect the streak to an earlier completed day.
+// It returns nil when nothing needs freezing or more than max days would
+// be needed.
+func freezeGap(schedule string, done, off map[string]bool, today time.Time, max int) []time.Time {
+	var earliest time.Time
+	for day := range done {
+		if d, err := time.Parse(dateLayout, day); err == nil && (earliest.IsZero() || d.Before(earliest)) {
+			earliest = d
+		}
+	}
+	if earliest.IsZero() {
+		return nil
+	}
+
+	d := today
+	if !due(schedule, off, d) || !done[dayKey(d)] {
+		d = prevDue(schedule, off, d)
+	}
+	for ; done[dayKey(d)]; d = prevDue(schedule, off, d) {
+	}
+	var gap []time.Time
+	for ; !done[dayKey(d)]; d = prevDue(schedule, off, d) {
+		if d.Before(earliest) || len(gap) == max {
+			return nil
+		}
+		gap = append(gap, d)
+	}
+	return gap
+}
+
+// frozenDays returns done with the days already covered by spent freezes
+// added; done itself is not modified. It never spends a freeze, so reads
+// can use it.
+func frozenDays(ctx context.Context, userID int64, list []*Habit, done map[int64]map[string]bool) (map[int64]map[string]bool, error) {
+	frozen, _, err := loadFreezeState(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return addFrozenDays(list, done, frozen), nil
+}
+
+func addFrozenDays(list []*Habit, done, frozen map[int64]map[string]bool) map[int64]map[string]bool {
+	result := make(map[int64]map[string]bool, len(done))
+	for id, days := range done {
+		result[id] = days
+	}
+	for _, habit := range list {
+		if habit.Polarity == PolarityBreak || habit.Schedule == ScheduleWeekly || len(frozen[habit.ID]) == 0 {
+			continue
+		}
+		days := make(map[string]bool, len(done[habit.ID])+len(frozen[habit.ID]))
+		for day := range done[habit.ID] {
+			days[day] = true
+		}
+		for day := range frozen[habit.ID] {
+			days[day] = true
+		}
+		result[habit.ID] = days
+	}
+	return result
+}
+
+// applyFreezes covers the missed days that would break each habit's streak
+// with the user's unused freezes and returns done with every frozen day,
+// new or old, added; done itself is not modified. Only freezes earned
+// before a missed day can cover it, and a gap is either covered completely
+// or not at all. It writes, so only tracking calls it.
+func applyFreezes(ctx context.Context, userID int64, list []*Habit, done map[int64]map[string]bool, off map[string]bool, today time.Time) (map[int64]map[string]bool, error) {
+	frozen, available, err := loadFreezeState(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	result := addFrozenDays(list, done, frozen)
+	for _, habit := range list {
+		if habit.Polarity == PolarityBreak || habit.Schedule == ScheduleWeekly || habit.ArchivedAt != nil {
+			continue
+		}
+		gap := freezeGap(habit.Schedule, result[habit.ID], off, today, len(available))
+		if len(gap) == 0 {
+			continue
+		}
+		usable, ok := coverGap(available, gap)
+		if !ok {
+			continue
+		}
+		days := make(map[string]bool, len(result[habit.ID])+len(gap))
+		for day := range result[habit.ID] {
+			days[day] = true
+		}
+		result[habit.ID] = days
+		for i, day := range gap {
+			ok, err := consumeFreeze(ctx, userID, usable[i].ID, habit.ID, day)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				days[dayKey(day)] = true
+			}
+		}
+		available = removeFreezes(available, usable)
+	}
+	return result, nil
+}
+
+// coverGap picks the oldest of available, which is sorted by earned day,
+// to cover gap. Only freezes earned before the gap's earliest day qualify;
+// it reports false when too few do.
+func coverGap(available []availableFreeze, gap []time.Time) ([]availableFreeze, bool) {
+	// gap runs backwards, so its last day is the earliest.
+	var usable []availableFreeze
+	for _, f := range available {
+		if f.EarnedDay.Before(gap[len(gap)-1]) && len(usable) < len(gap) {
+			usable = append(usable, f)
+		}
+	}
+	return usable, len(usable) == len(gap)
+}
+
+// earnFreeze awards a freeze when habit's streak, given its done days, has
+// just reached a multiple of freezeEarnDays, unless the user already holds
+// the maximum. Tracking the same habit again on the same day cannot earn a
+// second one.
+func earnFreeze(ctx context.Context, userID int64, habit *Habit, done map[string]bool, loc *time.Location) (bool, error) {
+	today := civilDay(time.Now(), loc)
+	if habit.Polarity == PolarityBreak || habit.Schedule == ScheduleWeekly || !done[dayKey(today)] {
+		return false, nil
+	}
+	off, err := loadVacationDays(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	withFreezes, err := applyFreezes(ctx, userID, []*Habit{habit}, map[int64]map[string]bool{habit.ID: done}, off, today)
+	if err != nil {
+		return false, err
+	}
+	streak := currentStreak(habit.Schedule, withFreezes[habit.ID], off, today)
+	if streak == 0 || streak%freezeEarnDays != 0 {
+		return false, nil
+	}
+
+	query := `
+		INSERT INTO streak_freezes (user_id, earned_at, earned_habit_id, earned_day)
+		SELECT $1, $2, $3, $4
+		WHERE (SELECT COUNT(*) FROM streak_freezes WHERE user_id = $1 AND consumed_at IS NULL) < $5
+		ON CONFLICT (user_id, earned_habit_id, earned_day) DO NOTHING
+	`
+	result, err := db.ExecContext(ctx, query, userID, time.Now().UTC(), habit.ID, dayKey(today), maxFreezes)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// habitStreak computes a habit's streaks from its track records, counting
+// the days covered by freezes already spent.
+func habitStreak(ctx context.Context, userID int64, habit *Habit, records []*TrackRecord, loc *time.Location) (*StreakStats, error) {
+	today := civilDay(time.Now(), loc)
+	done := make(map[string]bool)
+	for _, record := range records {
+		if record.Completed {
+			done[dayKey(civilDay(record.Date, loc))] = true
+		}
+	}
+	off, err := loadVacationDays(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	withFreezes, err := frozenDays(ctx, userID, []*Habit{habit}, map[int64]map[string]bool{habit.ID: done})
+	if err != nil {
+		return nil, err
+	}
+	days := withFreezes[habit.ID]
+
+	from, to, _ := activeRange(habit, summaryRange{To: today}, loc)
+	stats := &StreakStats{
+		Current:    currentStreak(habit.Schedule, days, off, today),
+		Longest:    longestStreak(habit.Schedule, days, off, from, to),
+		Unit:       "days",
+		FrozenDays: []string{},
+	}
+	if habit.Schedule == ScheduleWeekly {
+		stats.Unit = "weeks"
+	}
+	for day := range days {
+		if !done[day] {
+			stats.FrozenDays = append(stats.FrozenDays, day)
+		}
+	}
+	sort.Strings(stats.FrozenDays)
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if off[dayKey(d)] && scheduledOn(habit.Schedule, d) {
+			stats.VacationDays++
+		}
+	}
+	return stats, nil
+}
+
+type availableFreeze struct {
+	ID        int64
+	EarnedDay time.Time
+}
+
+func removeFreezes(available, used []availableFreeze) []availableFreeze {
+	gone := make(map[int64]bool, len(used))
+	for _, f := range used {
+		gone[f.ID] = true
+	}
+	var rest []availableFreeze
+	for _, f := range available {
+		if !gone[f.ID] {
+			rest = append(rest, f)
+		}
+	}
+	return rest
+}
+
+// loadFreezeState returns the days covered by consumed freezes per habit
+// and the unused freezes, oldest first.
+func loadFreezeState(ctx context.Context, userID int64) (map[int64]map[string]bool, []availableFreeze, error) {
+	query := `
+		SELECT id, earned_day, habit_id, frozen_day FROM streak_freezes
+		WHERE user_id = $1 ORDER BY earned_day, id
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	frozen := make(map[int64]map[string]bool)
+	var available []availableFreeze
+	for rows.Next() {
+		var f availableFreeze
+		var habitID sql.NullInt64
+		var day sql.NullTime
+		if err := rows.Scan(&f.ID, &f.EarnedDay, &habitID, &day); err != nil {
+			return nil, nil, err
+		}
+		if !habitID.Valid {
+			available = append(available, f)
+			continue
+		}
+		if frozen[habitID.Int64] == nil {
+			frozen[habitID.Int64] = make(map[string]bool)
+		}
+		frozen[habitID.Int64][dayKey(day.Time)] = true
+	}
+	return frozen, available, rows.Err()
+}
+
+// consumeFreeze spends a freeze on a habit's missed day. It reports false
+// if a concurrent request spent the freeze or froze the day first.
+func consumeFreeze(ctx context.Context, userID, freezeID, habitID int64, day time.Time) (bool, error) {
+	query := `
+		UPDATE streak_freezes SET habit_id = $3, frozen_day = $4, consumed_at = $5
+		WHERE id = $1 AND user_id = $2 AND consumed_at IS NULL
+	`
+	result, err := db.ExecContext(ctx, query, freezeID, userID, habitID, dayKey(day), time.Now().UTC())
+	if isUniqueViolation(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func listFreezes(ctx context.Context, userID int64) ([]Freeze, error) {
+	query := `
+		SELECT id, earned_at, earned_habit_id, consumed_at, habit_id, frozen_day FROM streak_freezes
+		WHERE user_id = $1 ORDER BY earned_at DESC, id DESC
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	freezes := []Freeze{}
+	for rows.Next() {
+		var f Freeze
+		var consumedAt, day sql.NullTime
+		var habitID sql.NullInt64
+		if err := rows.Scan(&f.ID, &f.EarnedAt, &f.EarnedHabitID, &consumedAt, &habitID, &day); err != nil {
+			return nil, err
+		}
+		if consumedAt.Valid {
+			f.ConsumedAt = &consumedAt.Time
+		}
+		if habitID.Valid {
+			f.HabitID = &habitID.Int64
+		}
+		if day.Valid {
+			d := dayKey(day.Time)
+			f.Day = &d
+		}
+		freezes = append(freezes, f)
+	}
+	return freezes, rows.Err()
+}
+
+// loadVacationDays returns every date covered by one of the user's
+// vacations.
+func loadVacationDays(ctx context.Context, userID int64) (map[string]bool, error) {
+	vacations, err := listVacations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	off := make(map[string]bool)
+	for _, v := range vacations {
+		start, _ := time.Parse(dateLayout, v.StartDate)
+		end, _ := time.Parse(dateLayout, v.EndDate)
+		for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+			off[dayKey(d)] = true
+		}
+	}
+	return off, nil
+}
+
+func listVacations(ctx context.Context, userID int64) ([]Vacation, error) {
+	query := `SELECT id, start_date, end_date, created_at FROM vacations WHERE user_id = $1 ORDER BY start_date`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vacations := []Vacation{}
+	for rows.Next() {
+		var v Vacation
+		var start, end time.Time
+		if err := rows.Scan(&v.ID, &start, &end, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		v.StartDate, v.EndDate = dayKey(start), dayKey(end)
+		vacations = append(vacations, v)
+	}
+	return vacations, rows.Err()
+}
+
+var errVacationOverlap = errors.New("vacation overlaps another")
+
+// createVacation inserts a vacation unless it overlaps an existing one.
+func createVacation(ctx context.Context, userID int64, start, end time.Time) (Vacation, error) {
+	v := Vacation{StartDate: dayKey(start), EndDate: dayKey(end), CreatedAt: time.Now().UTC()}
+	query := `
+		INSERT INTO vacations (user_id, start_date, end_date, created_at)
+		SELECT $1, $2, $3, $4
+		WHERE NOT EXISTS (
+			SELECT 1 FROM vacations WHERE user_id = $1 AND start_date <= $3::date AND end_date >= $2::date
+		)
+		RETURNING id
+	`
+	err := db.QueryRowContext(ctx, query, userID, v.StartDate, v.EndDate, v.CreatedAt).Scan(&v.ID)
+	if err == sql.ErrNoRows {
+		return Vacation{}, errVacationOverlap
+	}
+	return v, err
+}
@@ -0,0 +1,148 @@
+package habit
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func parseDay(s string) time.Time {
+	d, err := time.Parse(dateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func daySet(list ...string) map[string]bool {
+	m := make(map[string]bool, len(list))
+	for _, d := range list {
+		m[d] = true
+	}
+	return m
+}
+
+func dayKeys(list []time.Time) []string {
+	keys := make([]string, len(list))
+	for i, d := range list {
+		keys[i] = dayKey(d)
+	}
+	return keys
+}
+
+func TestFreezeGap(t *testing.T) {
+	// 2026-10-15 is a Thursday.
+	today := parseDay("2026-10-15")
+	tests := []struct {
+		name     string
+		schedule string
+		done     []string
+		off      []string
+		max      int
+		want     []string
+	}{
+		{
+			name:     "unbroken streak",
+			schedule: ScheduleDaily,
+			done:     []string{"2026-10-12", "2026-10-13", "2026-10-14"},
+			max:      2,
+		},
+		{
+			name:     "one missed day inside the streak",
+			schedule: ScheduleDaily,
+			done:     []string{"2026-10-10", "2026-10-11", "2026-10-12", "2026-10-14"},
+			max:      2,
+			want:     []string{"2026-10-13"},
+		},
+		{
+			name:     "today is still open",
+			schedule: ScheduleDaily,
+			done:     []string{"2026-10-10", "2026-10-11", "2026-10-12"},
+			max:      2,
+			want:     []string{"2026-10-14", "2026-10-13"},
+		},
+		{
+			name:     "gap wider than the available freezes",
+			schedule: ScheduleDaily,
+			done:     []string{"2026-10-10", "2026-10-11", "2026-10-12"},
+			max:      1,
+		},
+		{
+			name:     "no freezes",
+			schedule: ScheduleDaily,
+			done:     []string{"2026-10-10", "2026-10-11", "2026-10-12", "2026-10-14"},
+		},
+		{
+			name:     "vacation covers the whole gap",
+			schedule: ScheduleDaily,
+			done:     []string{"2026-10-10", "2026-10-11", "2026-10-12", "2026-10-14"},
+			off:      []string{"2026-10-13"},
+			max:      2,
+		},
+		{
+			name:     "vacation day inside the gap is skipped",
+			schedule: ScheduleDaily,
+			done:     []string{"2026-10-09", "2026-10-10", "2026-10-14"},
+			off:      []string{"2026-10-12"},
+			max:      2,
+			want:     []string{"2026-10-13", "2026-10-11"},
+		},
+		{
+			name:     "gap before the earliest record",
+			schedule: ScheduleDaily,
+			done:     []string{"2026-10-13", "2026-10-14"},
+			max:      2,
+		},
+		{
+			name:     "no records",
+			schedule: ScheduleDaily,
+			max:      2,
+		},
+		{
+			name:     "weekend is not due for weekdays",
+			schedule: ScheduleWeekdays,
+			done:     []string{"2026-10-08", "2026-10-09", "2026-10-13", "2026-10-14"},
+			max:      2,
+			want:     []string{"2026-10-12"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dayKeys(freezeGap(tt.schedule, daySet(tt.done...), daySet(tt.off...), today, tt.max))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("freezeGap = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoverGap(t *testing.T) {
+	gap := []time.Time{parseDay("2026-10-14"), parseDay("2026-10-13")}
+	freeze := func(id int64, earned string) availableFreeze {
+		return availableFreeze{ID: id, EarnedDay: parseDay(earned)}
+	}
+	tests := []struct {
+		name      string
+		available []availableFreeze
+		want      []int64
+		wantOK    bool
+	}{
+		{"enough", []availableFreeze{freeze(1, "2026-10-01"), freeze(2, "2026-10-07")}, []int64{1, 2}, true},
+		{"oldest first", []availableFreeze{freeze(1, "2026-10-01"), freeze(2, "2026-10-07"), freeze(3, "2026-10-08")}, []int64{1, 2}, true},
+		{"too few", []availableFreeze{freeze(1, "2026-10-01")}, []int64{1}, false},
+		{"earned on the gap's first day", []availableFreeze{freeze(1, "2026-10-01"), freeze(2, "2026-10-13")}, []int64{1}, false},
+		{"earned after the gap", []availableFreeze{freeze(1, "2026-10-14"), freeze(2, "2026-10-15")}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usable, ok := coverGap(tt.available, gap)
+			var got []int64
+			for _, f := range usable {
+				got = append(got, f.ID)
+			}
+			if !slices.Equal(got, tt.want) || ok != tt.wantOK {
+				t.Errorf("coverGap = %v, %v; want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,517 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/validate"
+)
+
+// Goal kinds: a count goal is reached by completing its habits Target times
+// between the start date and the deadline; a challenge requires every
+// scheduled day in that span to be completed.
+const (
+	GoalCount     = "count"
+	GoalChallenge = "challenge"
+)
+
+// Goal statuses. A goal is settled as soon as its outcome is certain and at
+// the latest once its deadline has passed.
+const (
+	GoalActive    = "active"
+	GoalSucceeded = "succeeded"
+	GoalFailed    = "failed"
+)
+
+const maxGoalDays = 366
+
+type GoalRequest struct {
+	Name     string  `json:"name" validate:"required,max=100"`
+	Kind     string  `json:"kind" validate:"required,oneof=count challenge"`
+	HabitIDs []int64 `json:"habit_ids" validate:"required,min=1,max=20"`
+	Target   int     `json:"target" validate:"min=1,max=10000"`
+	// StartDate defaults to today; both dates are inclusive.
+	StartDate string `json:"start_date"`
+	Deadline  string `json:"deadline" validate:"required"`
+}
+
+// GoalProgress is computed from track records on every read. Current counts
+// completions (count goals) or fully completed days (challenges) so far.
+type GoalProgress struct {
+	Current       int     `json:"current"`
+	Target        int     `json:"target"`
+	Percent       float64 `json:"percent"`
+	DaysLeft      int     `json:"days_left"`
+	ProjectedDate *string `json:"projected_date"`
+	OnTrack       bool    `json:"on_track"`
+}
+
+type Goal struct {
+	ID          int64        `json:"id"`
+	Name        string       `json:"name"`
+	Kind        string       `json:"kind"`
+	HabitIDs    []int64      `json:"habit_ids"`
+	Target      int          `json:"target"`
+	StartDate   string       `json:"start_date"`
+	Deadline    string       `json:"deadline"`
+	Status      string       `json:"status"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CompletedAt *time.Time   `json:"completed_at"`
+	Progress    GoalProgress `json:"progress"`
+}
+
+type GoalListResponse struct {
+	Goals []*Goal `json:"goals"`
+}
+
+// GoalsHandler lists (GET) and creates (POST) goals. GET takes
+// status=active (default), completed or all.
+func GoalsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	c, err := authenticateCaller(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "":
+			status = GoalActive
+		case GoalActive, "completed", "all":
+		default:
+			apierror.Write(w, r, apierror.Validation(apierror.FieldError{Field: "status", Code: "invalid_choice", Message: "status must be one of: active, completed, all"}))
+			return
+		}
+		goals, err := listGoals(r.Context(), c.UserID, status, c.Location())
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		json.NewEncoder(w).Encode(GoalListResponse{Goals: goals})
+	case http.MethodPost:
+		createGoal(w, r, c)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+// GoalHandler returns (GET) or deletes (DELETE) one goal.
+func GoalHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	c, err := authenticateCaller(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	goalID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/goals/"), 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid goal ID"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		goals, err := loadGoals(r.Context(), c.UserID, `AND g.id = $2`, goalID)
+		if err == nil && len(goals) == 1 {
+			err = evaluateGoals(r.Context(), c.UserID, goals, c.Location())
+		}
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		if len(goals) == 0 {
+			apierror.Write(w, r, apierror.NotFound("Goal not found"))
+			return
+		}
+		json.NewEncoder(w).Encode(goals[0])
+	case http.MethodDelete:
+		result, err := db.ExecContext(r.Context(), `DELETE FROM goals WHERE id = $1 AND user_id = $2`, goalID, c.UserID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			apierror.Write(w, r, apierror.NotFound("Goal not found"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+func createGoal(w http.ResponseWriter, r *http.Request, c caller) {
+	var req GoalRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	loc := c.Location()
+	today := civilDay(time.Now(), loc)
+	start := today
+	var details []apierror.FieldError
+	if req.StartDate != "" {
+		d, err := time.Parse(dateLayout, req.StartDate)
+		if err != nil {
+			details = append(details, apierror.FieldError{Field: "start_date", Code: "invalid_date", Message: "start_date must be a date in YYYY-MM-DD format"})
+		}
+		start = d
+	}
+	deadline, err := time.Parse(dateLayout, req.Deadline)
+	if err != nil {
+		details = append(details, apierror.FieldError{Field: "deadline", Code: "invalid_date", Message: "deadline must be a date in YYYY-MM-DD format"})
+	} else if len(details) == 0 {
+		switch {
+		case deadline.Before(today) || deadline.Before(start):
+			details = append(details, apierror.FieldError{Field: "deadline", Code: "out_of_range", Message: "deadline must not be before today or start_date"})
+		case deadline.Sub(start) >= maxGoalDays*24*time.Hour:
+			details = append(details, apierror.FieldError{Field: "deadline", Code: "out_of_range", Message: "a goal must not span more than " + strconv.Itoa(maxGoalDays) + " days"})
+		}
+	}
+	if req.Kind == GoalCount && req.Target == 0 {
+		details = append(details, apierror.FieldError{Field: "target", Code: "required", Message: "target is required for count goals"})
+	}
+	if len(details) > 0 {
+		apierror.Write(w, r, apierror.Validation(details...))
+		return
+	}
+
+	list, err := loadHabitsByID(r.Context(), c.UserID, req.HabitIDs)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if len(list) != len(uniqueIDs(req.HabitIDs)) {
+		apierror.Write(w, r, apierror.Validation(apierror.FieldError{Field: "habit_ids", Code: "not_found", Message: "every habit must exist"}))
+		return
+	}
+	if req.Kind == GoalChallenge {
+		for _, habit := range list {
+			if habit.Schedule == ScheduleWeekly {
+				apierror.Write(w, r, apierror.Validation(apierror.FieldError{Field: "habit_ids", Code: "invalid_choice", Message: "challenges cannot include weekly habits"}))
+				return
+			}
+		}
+		// A challenge's target is its length in days, counted from when
+		// all of its habits could be tracked.
+		start = challengeStart(start, list, loc)
+		req.Target = int(deadline.Sub(start).Hours()/24) + 1
+	}
+
+	goal := &Goal{
+		Name:      strings.TrimSpace(req.Name),
+		Kind:      req.Kind,
+		HabitIDs:  uniqueIDs(req.HabitIDs),
+		Target:    req.Target,
+		StartDate: dayKey(start),
+		Deadline:  dayKey(deadline),
+		Status:    GoalActive,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := saveGoal(r.Context(), c.UserID, goal); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if err := evaluateGoals(r.Context(), c.UserID, []*Goal{goal}, loc); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(goal)
+}
+
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	out := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// evaluateGoals fills in the progress of goals and settles those whose
+// outcome is now certain. Track records are loaded in one query for all of
+// the goals' habits.
+func evaluateGoals(ctx context.Context, userID int64, goals []*Goal, loc *time.Location) error {
+	if len(goals) == 0 {
+		return nil
+	}
+	var ids []int64
+	since := civilDay(time.Now(), loc)
+	for _, goal := range goals {
+		ids = append(ids, goal.HabitIDs...)
+		if start, _ := time.Parse(dateLayout, goal.StartDate); start.Before(since) {
+			since = start
+		}
+	}
+	list, err := loadHabitsByID(ctx, userID, ids)
+	if err != nil {
+		return err
+	}
+	today := civilDay(time.Now(), loc)
+	done, err := loadDoneDays(ctx, userID, list, loc, since, today)
+	if err != nil {
+		return err
+	}
+	byID := make(map[int64]*Habit, len(list))
+	for _, habit := range list {
+		byID[habit.ID] = habit
+	}
+
+	for _, goal := range goals {
+		var goalHabits []*Habit
+		for _, id := range goal.HabitIDs {
+			if habit, ok := byID[id]; ok {
+				goalHabits = append(goalHabits, habit)
+			}
+		}
+		status := goalProgress(goal, goalHabits, done, today, loc)
+		if goal.Status == GoalActive && status != GoalActive {
+			if err := settleGoal(ctx, userID, goal, status); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// goalProgress computes goal.Progress as of today and returns the status
+// the goal should have. It does no I/O.
+func goalProgress(goal *Goal, list []*Habit, done map[int64]map[string]bool, today time.Time, loc *time.Location) string {
+	start, _ := time.Parse(dateLayout, goal.StartDate)
+	if goal.Kind == GoalChallenge {
+		start = challengeStart(start, list, loc)
+	}
+	deadline, _ := time.Parse(dateLayout, goal.Deadline)
+	end := deadline
+	if today.Before(end) {
+		end = today
+	}
+
+	p := GoalProgress{Target: goal.Target}
+	missed := false
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		key := dayKey(d)
+		if goal.Kind == GoalCount {
+			for _, habit := range list {
+				if done[habit.ID][key] {
+					p.Current++
+				}
+			}
+			continue
+		}
+		complete := true
+		for _, habit := range list {
+			if scheduledOn(habit.Schedule, d) && !done[habit.ID][key] {
+				complete = false
+			}
+		}
+		if complete {
+			p.Current++
+		} else if d.Before(today) {
+			// Today can still be completed; earlier days cannot.
+			missed = true
+		}
+	}
+	if p.Target > 0 {
+		p.Percent = math.Min(100, math.Round(float64(p.Current)/float64(p.Target)*1000)/10)
+	}
+	if !deadline.Before(today) {
+		p.DaysLeft = int(deadline.Sub(today).Hours()/24) + 1
+	}
+
+	status := GoalActive
+	switch {
+	case goal.Kind == GoalCount && p.Current >= p.Target:
+		status = GoalSucceeded
+	case goal.Kind == GoalChallenge && missed:
+		status = GoalFailed
+	case today.After(deadline) && goal.Kind == GoalChallenge:
+		status = GoalSucceeded
+	case today.After(deadline):
+		status = GoalFailed
+	}
+
+	switch {
+	case status == GoalSucceeded:
+		p.OnTrack = true
+	case status == GoalFailed || today.Before(start):
+	case goal.Kind == GoalChallenge:
+		p.ProjectedDate = &goal.Deadline
+		p.OnTrack = true
+	default:
+		p.ProjectedDate, p.OnTrack = projectDate(p.Current, p.Target, start, today, deadline)
+	}
+	goal.Progress = p
+	if goal.Status == GoalActive {
+		return status
+	}
+	return goal.Status
+}
+
+// challengeStart moves a challenge's start to the first day all of its
+// habits existed. Days before a habit was created could never have been
+// tracked, so they must not fail the challenge.
+func challengeStart(start time.Time, list []*Habit, loc *time.Location) time.Time {
+	for _, habit := range list {
+		if created := civilDay(habit.CreatedAt, loc); created.After(start) {
+			start = created
+		}
+	}
+	return start
+}
+
+// projectDate extrapolates the pace so far (completions per day since
+// start, including today) to the day the target would be reached.
+func projectDate(current, target int, start, today, deadline time.Time) (*string, bool) {
+	if current == 0 {
+		return nil, false
+	}
+	elapsed := today.Sub(start).Hours()/24 + 1
+	pace := float64(current) / elapsed
+	remaining := float64(target - current)
+	projected := today.AddDate(0, 0, int(math.Ceil(remaining/pace)))
+	key := dayKey(projected)
+	return &key, !projected.After(deadline)
+}
+
+const goalColumns = `g.id, g.name, g.kind, g.target, g.start_date, g.deadline, g.status, g.created_at, g.completed_at,
+	ARRAY(SELECT gh.habit_id FROM goal_habits gh WHERE gh.goal_id = g.id ORDER BY gh.habit_id)`
+
+// loadGoals returns userID's goals matching the extra WHERE clause, newest
+// first. Progress is not filled in.
+func loadGoals(ctx context.Context, userID int64, where string, args ...any) ([]*Goal, error) {
+	query := `SELECT ` + goalColumns + ` FROM goals g WHERE g.user_id = $1 ` + where + ` ORDER BY g.deadline, g.id`
+	rows, err := db.QueryContext(ctx, query, append([]any{userID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	goals := []*Goal{}
+	for rows.Next() {
+		var goal Goal
+		var start, deadline time.Time
+		var completedAt sql.NullTime
+		err := rows.Scan(&goal.ID, &goal.Name, &goal.Kind, &goal.Target, &start, &deadline, &goal.Status,
+			&goal.CreatedAt, &completedAt, pq.Array(&goal.HabitIDs))
+		if err != nil {
+			return nil, err
+		}
+		goal.StartDate, goal.Deadline = dayKey(start), dayKey(deadline)
+		if completedAt.Valid {
+			goal.CompletedAt = &completedAt.Time
+		}
+		goals = append(goals, &goal)
+	}
+	return goals, rows.Err()
+}
+
+// listGoals evaluates active goals before filtering, so goals that have
+// just been settled move to the completed list.
+func listGoals(ctx context.Context, userID int64, status string, loc *time.Location) ([]*Goal, error) {
+	goals, err := loadGoals(ctx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := evaluateGoals(ctx, userID, goals, loc); err != nil {
+		return nil, err
+	}
+	if status == "all" {
+		return goals, nil
+	}
+	filtered := []*Goal{}
+	for _, goal := range goals {
+		if (goal.Status == GoalActive) == (status == GoalActive) {
+			filtered = append(filtered, goal)
+		}
+	}
+	return filtered, nil
+}
+
+func saveGoal(ctx context.Context, userID int64, goal *Goal) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO goals (user_id, name, kind, target, start_date, deadline, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id
+	`
+	err = tx.QueryRowContext(ctx, query, userID, goal.Name, goal.Kind, goal.Target, goal.StartDate, goal.Deadline, goal.Status, goal.CreatedAt).
+		Scan(&goal.ID)
+	if err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO goal_habits (goal_id, user_id, habit_id)
+		SELECT $1, $2, UNNEST($3::bigint[])
+	`
+	if _, err := tx.ExecContext(ctx, query, goal.ID, userID, pq.Array(goal.HabitIDs)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// settleGoal records the outcome of an active goal.
+func settleGoal(ctx context.Context, userID int64, goal *Goal, status string) error {
+	now := time.Now().UTC()
+	query := `UPDATE goals SET status = $3, completed_at = $4 WHERE id = $1 AND user_id = $2 AND status = 'active'`
+	if _, err := db.ExecContext(ctx, query, goal.ID, userID, status, now); err != nil {
+		return err
+	}
+	goal.Status = status
+	goal.CompletedAt = &now
+	return nil
+}
+
+// loadHabitsByID returns those of ids that are userID's habits.
+func loadHabitsByID(ctx context.Context, userID int64, ids []int64) ([]*Habit, error) {
+	query := `SELECT ` + habitColumns + ` FROM habits h WHERE h.user_id = $1 AND h.id = ANY($2) ORDER BY h.id`
+	rows, err := db.QueryContext(ctx, query, userID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*Habit{}
+	for rows.Next() {
+		habit, err := scanHabit(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, habit)
+	}
+	return list, rows.Err()
+}
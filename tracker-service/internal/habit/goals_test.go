@@ -0,0 +1,209 @@
+package habit
+
+import (
+	"testing"
+	"time"
+)
+
+// dayRange returns the dates from first to last inclusive, minus skip.
+func dayRange(first, last string, skip ...string) []string {
+	skipped := daySet(skip...)
+	var list []string
+	for d := parseDay(first); !d.After(parseDay(last)); d = d.AddDate(0, 0, 1) {
+		if !skipped[dayKey(d)] {
+			list = append(list, dayKey(d))
+		}
+	}
+	return list
+}
+
+func TestGoalProgress(t *testing.T) {
+	// 2026-10-15 is a Thursday.
+	today := parseDay("2026-10-15")
+	created := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	daily := &Habit{ID: 1, Schedule: ScheduleDaily, Polarity: PolarityBuild, CreatedAt: created}
+	late := &Habit{ID: 2, Schedule: ScheduleDaily, Polarity: PolarityBuild, CreatedAt: time.Date(2026, 10, 10, 18, 0, 0, 0, time.UTC)}
+	quit := &Habit{ID: 3, Schedule: ScheduleDaily, Polarity: PolarityBreak, CreatedAt: created}
+
+	tests := []struct {
+		name   string
+		goal   Goal
+		habits []*Habit
+		// done holds completed days, or clean days for break habits.
+		done      map[int64][]string
+		want      string
+		current   int
+		daysLeft  int
+		projected *string
+		onTrack   bool
+	}{
+		{
+			name:   "count goal reached early",
+			goal:   Goal{Kind: GoalCount, Target: 5, StartDate: "2026-10-10", Deadline: "2026-10-31"},
+			habits: []*Habit{daily},
+			done:   map[int64][]string{1: dayRange("2026-10-10", "2026-10-14")},
+			want:   GoalSucceeded, current: 5, daysLeft: 17, onTrack: true,
+		},
+		{
+			name:   "count goal on pace",
+			goal:   Goal{Kind: GoalCount, Target: 10, StartDate: "2026-10-11", Deadline: "2026-10-31"},
+			habits: []*Habit{daily},
+			done:   map[int64][]string{1: dayRange("2026-10-11", "2026-10-15")},
+			want:   GoalActive, current: 5, daysLeft: 17, projected: ptr("2026-10-20"), onTrack: true,
+		},
+		{
+			name:   "count goal past its deadline",
+			goal:   Goal{Kind: GoalCount, Target: 10, StartDate: "2026-10-10", Deadline: "2026-10-14"},
+			habits: []*Habit{daily},
+			done:   map[int64][]string{1: dayRange("2026-10-10", "2026-10-12")},
+			want:   GoalFailed, current: 3,
+		},
+		{
+			name:   "challenge missed yesterday",
+			goal:   Goal{Kind: GoalChallenge, Target: 11, StartDate: "2026-10-10", Deadline: "2026-10-20"},
+			habits: []*Habit{daily},
+			done:   map[int64][]string{1: dayRange("2026-10-10", "2026-10-13")},
+			want:   GoalFailed, current: 4, daysLeft: 6,
+		},
+		{
+			name:   "challenge with today still open",
+			goal:   Goal{Kind: GoalChallenge, Target: 11, StartDate: "2026-10-10", Deadline: "2026-10-20"},
+			habits: []*Habit{daily},
+			done:   map[int64][]string{1: dayRange("2026-10-10", "2026-10-14")},
+			want:   GoalActive, current: 5, daysLeft: 6, projected: ptr("2026-10-20"), onTrack: true,
+		},
+		{
+			name:   "challenge completed by its deadline",
+			goal:   Goal{Kind: GoalChallenge, Target: 5, StartDate: "2026-10-10", Deadline: "2026-10-14"},
+			habits: []*Habit{daily},
+			done:   map[int64][]string{1: dayRange("2026-10-10", "2026-10-14")},
+			want:   GoalSucceeded, current: 5, onTrack: true,
+		},
+		{
+			name:   "challenge habit created after the start date",
+			goal:   Goal{Kind: GoalChallenge, Target: 11, StartDate: "2026-10-05", Deadline: "2026-10-15"},
+			habits: []*Habit{daily, late},
+			done: map[int64][]string{
+				1: dayRange("2026-10-05", "2026-10-15"),
+				2: dayRange("2026-10-10", "2026-10-14"),
+			},
+			want: GoalActive, current: 5, daysLeft: 1, projected: ptr("2026-10-15"), onTrack: true,
+		},
+		{
+			name:   "break habit relapse fails a challenge",
+			goal:   Goal{Kind: GoalChallenge, Target: 11, StartDate: "2026-10-10", Deadline: "2026-10-20"},
+			habits: []*Habit{daily, quit},
+			done: map[int64][]string{
+				1: dayRange("2026-10-10", "2026-10-15"),
+				3: dayRange("2026-10-10", "2026-10-15", "2026-10-12"),
+			},
+			want: GoalFailed, current: 5, daysLeft: 6,
+		},
+		{
+			name:   "break habit kept clean",
+			goal:   Goal{Kind: GoalChallenge, Target: 11, StartDate: "2026-10-10", Deadline: "2026-10-20"},
+			habits: []*Habit{daily, quit},
+			done: map[int64][]string{
+				1: dayRange("2026-10-10", "2026-10-14"),
+				3: dayRange("2026-10-10", "2026-10-15"),
+			},
+			want: GoalActive, current: 5, daysLeft: 6, projected: ptr("2026-10-20"), onTrack: true,
+		},
+		{
+			name:   "settled goal keeps its status",
+			goal:   Goal{Kind: GoalCount, Status: GoalFailed, Target: 3, StartDate: "2026-10-10", Deadline: "2026-10-31"},
+			habits: []*Habit{daily},
+			done:   map[int64][]string{1: dayRange("2026-10-10", "2026-10-14")},
+			want:   GoalFailed, current: 5, daysLeft: 17, onTrack: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goal := tt.goal
+			if goal.Status == "" {
+				goal.Status = GoalActive
+			}
+			done := make(map[int64]map[string]bool, len(tt.done))
+			for id, list := range tt.done {
+				done[id] = daySet(list...)
+			}
+
+			got := goalProgress(&goal, tt.habits, done, today, time.UTC)
+			p := goal.Progress
+			if got != tt.want {
+				t.Errorf("status = %q, want %q", got, tt.want)
+			}
+			if p.Current != tt.current || p.DaysLeft != tt.daysLeft || p.OnTrack != tt.onTrack {
+				t.Errorf("progress = current %d, days left %d, on track %v; want %d, %d, %v",
+					p.Current, p.DaysLeft, p.OnTrack, tt.current, tt.daysLeft, tt.onTrack)
+			}
+			if (p.ProjectedDate == nil) != (tt.projected == nil) || (p.ProjectedDate != nil && *p.ProjectedDate != *tt.projected) {
+				t.Errorf("projected date = %v, want %v", deref(p.ProjectedDate), deref(tt.projected))
+			}
+		})
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return "<nil>"
+	}
+	return *s
+}
+
+func TestProjectDate(t *testing.T) {
+	today := parseDay("2026-10-15")
+	tests := []struct {
+		name            string
+		current, target int
+		start, deadline string
+		want            *string
+		onTrack         bool
+	}{
+		{"nothing done yet", 0, 10, "2026-10-12", "2026-10-31", nil, false},
+		{"ahead of pace", 4, 10, "2026-10-12", "2026-10-31", ptr("2026-10-21"), true},
+		{"reached on the deadline", 2, 10, "2026-10-12", "2026-10-31", ptr("2026-10-31"), true},
+		{"behind pace", 2, 10, "2026-10-12", "2026-10-30", ptr("2026-10-31"), false},
+		{"started today", 1, 3, "2026-10-15", "2026-10-31", ptr("2026-10-17"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, onTrack := projectDate(tt.current, tt.target, parseDay(tt.start), today, parseDay(tt.deadline))
+			if deref(got) != deref(tt.want) || onTrack != tt.onTrack {
+				t.Errorf("projectDate = %s, %v; want %s, %v", deref(got), onTrack, deref(tt.want), tt.onTrack)
+			}
+		})
+	}
+}
+
+func TestChallengeStart(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	start := parseDay("2026-10-05")
+	habit := func(created time.Time) *Habit { return &Habit{CreatedAt: created} }
+
+	tests := []struct {
+		name   string
+		habits []*Habit
+		loc    *time.Location
+		want   string
+	}{
+		{"habits older than the start", []*Habit{habit(time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC))}, time.UTC, "2026-10-05"},
+		{"habit created on the start date", []*Habit{habit(time.Date(2026, 10, 5, 23, 0, 0, 0, time.UTC))}, time.UTC, "2026-10-05"},
+		{"latest habit wins", []*Habit{
+			habit(time.Date(2026, 10, 8, 9, 0, 0, 0, time.UTC)),
+			habit(time.Date(2026, 10, 7, 9, 0, 0, 0, time.UTC)),
+		}, time.UTC, "2026-10-08"},
+		// 23:30 UTC on the 9th is already the 10th in Berlin.
+		{"creation day in the user's zone", []*Habit{habit(time.Date(2026, 10, 9, 23, 30, 0, 0, time.UTC))}, berlin, "2026-10-10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dayKey(challengeStart(start, tt.habits, tt.loc)); got != tt.want {
+				t.Errorf("challengeStart = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,576 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/validate"
+)
+
+// Group roles.
+const (
+	RoleOwner  = "owner"
+	RoleMember = "member"
+)
+
+// Leaderboard visibility of a group member: named, ranked without a name,
+// or left out of every leaderboard of the group.
+const (
+	VisibilityVisible   = "visible"
+	VisibilityAnonymous = "anonymous"
+	VisibilityHidden    = "hidden"
+)
+
+const maxGroupMembers = 100
+
+type GroupRequest struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=2000"`
+}
+
+type AddGroupMemberRequest struct {
+	Username string `json:"username" validate:"required,max=50"`
+}
+
+type GroupPrivacyRequest struct {
+	Leaderboard string `json:"leaderboard" validate:"required,oneof=visible anonymous hidden"`
+}
+
+// Group is a set of users who compete on leaderboards and challenges.
+// Calendar days are counted in TimeZone, the creator's at creation. Role,
+// Status and Visibility are the caller's; MemberCount counts joined
+// members only.
+type Group struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	OwnerID     int64     `json:"owner_id"`
+	TimeZone    string    `json:"time_zone"`
+	CreatedAt   time.Time `json:"created_at"`
+	Role        string    `json:"role"`
+	Status      string    `json:"status"`
+	Visibility  string    `json:"visibility"`
+	MemberCount int       `json:"member_count"`
+}
+
+// Location is the group's time zone, falling back to UTC like caller.
+func (g Group) Location() *time.Location {
+	return caller{TimeZone: g.TimeZone}.Location()
+}
+
+type GroupListResponse struct {
+	Groups []Group `json:"groups"`
+}
+
+// GroupMember is a member as listed to the rest of the group. Joining
+// makes membership visible to the group; leaderboard visibility only
+// affects results. Pending invitations are listed to the owner alone.
+type GroupMember struct {
+	UserID      int64      `json:"user_id"`
+	Username    string     `json:"username"`
+	DisplayName string     `json:"display_name"`
+	Role        string     `json:"role"`
+	Status      string     `json:"status"`
+	InvitedAt   time.Time  `json:"invited_at"`
+	JoinedAt    *time.Time `json:"joined_at"`
+}
+
+type GroupDetailResponse struct {
+	Group
+	Members []GroupMember `json:"members"`
+}
+
+// GroupsHandler lists the caller's groups (GET) and creates a group with
+// the caller as its owner (POST).
+func GroupsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	c, err := authenticateCaller(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		groups, err := listGroups(r.Context(), c.UserID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		json.NewEncoder(w).Encode(GroupListResponse{Groups: groups})
+	case http.MethodPost:
+		var req GroupRequest
+		if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		group := Group{
+			Name:        strings.TrimSpace(req.Name),
+			Description: req.Description,
+			OwnerID:     c.UserID,
+			TimeZone:    c.Location().String(),
+			CreatedAt:   time.Now().UTC(),
+			Role:        RoleOwner,
+			Status:      MemberJoined,
+			Visibility:  VisibilityVisible,
+			MemberCount: 1,
+		}
+		if err := saveGroup(r.Context(), &group); err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(group)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+// GroupHandler returns a group with its members (GET) or, for its owner,
+// deletes it with its challenges (DELETE).
+func GroupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	userID, err := authenticate(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/groups/"), 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid group ID"))
+		return
+	}
+	group, ok := findGroup(w, r, userID, id)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		members, err := loadGroupMembers(r.Context(), id, group.Role == RoleOwner)
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(GroupDetailResponse{Group: group, Members: members})
+	case http.MethodDelete:
+		if group.Role != RoleOwner {
+			apierror.Write(w, r, apierror.Forbidden("Only the group's owner can delete it"))
+			return
+		}
+		if _, err := db.ExecContext(r.Context(), `DELETE FROM groups WHERE id = $1`, id); err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+// GroupMembersHandler lets a group's owner invite a user by username with
+// POST /groups/{id}/members. Nothing of theirs is ranked, and they are not
+// listed to the group, until they accept with POST /groups/{id}/join.
+func GroupMembersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/groups/"), "/members")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid group ID"))
+		return
+	}
+	group, ok := findGroup(w, r, userID, id)
+	if !ok {
+		return
+	}
+	if group.Role != RoleOwner {
+		apierror.Write(w, r, apierror.Forbidden("Only the group's owner can invite members"))
+		return
+	}
+
+	var req AddGroupMemberRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	users, err := lookupUsers(r.Context(), []string{strings.TrimSpace(req.Username)}, nil)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	var member *userInfo
+	for _, u := range users {
+		member = &u
+	}
+	if member == nil {
+		apierror.Write(w, r, apierror.NotFound("User not found"))
+		return
+	}
+
+	// Pending invitations count towards the limit.
+	invitedAt := time.Now().UTC()
+	query := `
+		INSERT INTO group_members (group_id, user_id, role, visibility, invited_at)
+		SELECT $1, $2, 'member', 'anonymous', $3
+		WHERE (SELECT COUNT(*) FROM group_members WHERE group_id = $1) < $4
+	`
+	result, err := db.ExecContext(r.Context(), query, id, member.ID, invitedAt, maxGroupMembers)
+	if isUniqueViolation(err) {
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "User is already a member of or invited to this group"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "Group member limit reached"))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(GroupMember{
+		UserID:      member.ID,
+		Username:    member.Username,
+		DisplayName: member.DisplayName,
+		Role:        RoleMember,
+		Status:      MemberInvited,
+		InvitedAt:   invitedAt,
+	})
+}
+
+// JoinGroupHandler accepts an invitation with POST /groups/{id}/join. The
+// new member starts out anonymous on the group's leaderboards.
+func JoinGroupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/groups/"), "/join")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid group ID"))
+		return
+	}
+
+	group, err := loadGroup(r.Context(), userID, id)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("Group not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if group.Status == MemberJoined {
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "You have already joined this group"))
+		return
+	}
+	query := `UPDATE group_members SET joined_at = $3 WHERE group_id = $1 AND user_id = $2 AND joined_at IS NULL`
+	if _, err := db.ExecContext(r.Context(), query, id, userID, time.Now().UTC()); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	group.Status = MemberJoined
+	group.MemberCount++
+	json.NewEncoder(w).Encode(group)
+}
+
+// GroupMemberHandler removes a member with DELETE
+// /groups/{id}/members/{userID}: the owner can remove anyone else or
+// withdraw an invitation, and members can leave or decline. The member's
+// challenge entries in the group go too; their habits and track records
+// stay.
+func GroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	// /groups/{id}/members/{userID}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/groups/"), "/")
+	if len(parts) != 3 {
+		apierror.Write(w, r, apierror.NotFound("Member not found"))
+		return
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid group ID"))
+		return
+	}
+	memberID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid user ID"))
+		return
+	}
+	// Invitees may only decline, so they need not have joined.
+	group, err := loadGroup(r.Context(), userID, id)
+	if err == sql.ErrNoRows || (err == nil && group.Status != MemberJoined && memberID != userID) {
+		apierror.Write(w, r, apierror.NotFound("Group not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	switch {
+	case memberID == group.OwnerID:
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "The owner cannot leave the group; delete it instead"))
+		return
+	case memberID != userID && group.Role != RoleOwner:
+		apierror.Write(w, r, apierror.Forbidden("Only the group's owner can remove other members"))
+		return
+	}
+
+	removed, err := removeGroupMember(r.Context(), id, memberID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if !removed {
+		apierror.Write(w, r, apierror.NotFound("Member not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GroupPrivacyHandler sets the caller's leaderboard visibility in a group
+// with PUT /groups/{id}/privacy.
+func GroupPrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodPut {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/groups/"), "/privacy")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid group ID"))
+		return
+	}
+
+	var req GroupPrivacyRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	query := `UPDATE group_members SET visibility = $3 WHERE group_id = $1 AND user_id = $2 AND joined_at IS NOT NULL`
+	result, err := db.ExecContext(r.Context(), query, id, userID, req.Leaderboard)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, r, apierror.NotFound("Group not found"))
+		return
+	}
+	group, ok := findGroup(w, r, userID, id)
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(group)
+}
+
+// findGroup loads group id for a member, writing 404 to w if userID has
+// not joined it.
+func findGroup(w http.ResponseWriter, r *http.Request, userID, id int64) (Group, bool) {
+	group, err := loadGroup(r.Context(), userID, id)
+	if err == sql.ErrNoRows || (err == nil && group.Status != MemberJoined) {
+		apierror.Write(w, r, apierror.NotFound("Group not found"))
+		return Group{}, false
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return Group{}, false
+	}
+	return group, true
+}
+
+const groupColumns = `g.id, g.name, g.description, g.owner_id, g.time_zone, g.created_at, m.role,
+	CASE WHEN m.joined_at IS NULL THEN 'invited' ELSE 'joined' END, m.visibility,
+	(SELECT COUNT(*) FROM group_members c WHERE c.group_id = g.id AND c.joined_at IS NOT NULL)`
+
+func scanGroup(row rowScanner) (Group, error) {
+	var g Group
+	err := row.Scan(&g.ID, &g.Name, &g.Description, &g.OwnerID, &g.TimeZone, &g.CreatedAt, &g.Role, &g.Status, &g.Visibility, &g.MemberCount)
+	return g, err
+}
+
+func listGroups(ctx context.Context, userID int64) ([]Group, error) {
+	query := `
+		SELECT ` + groupColumns + `
+		FROM groups g JOIN group_members m ON m.group_id = g.id AND m.user_id = $1
+		ORDER BY g.created_at, g.id
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []Group{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// loadGroup returns group id as seen by userID, or sql.ErrNoRows if userID
+// is neither a member nor invited.
+func loadGroup(ctx context.Context, userID, id int64) (Group, error) {
+	query := `
+		SELECT ` + groupColumns + `
+		FROM groups g JOIN group_members m ON m.group_id = g.id AND m.user_id = $1
+		WHERE g.id = $2
+	`
+	return scanGroup(db.QueryRowContext(ctx, query, userID, id))
+}
+
+// loadGroupMembers lists a group's members, and its pending invitations if
+// invited is set, with their names from one directory lookup. Members
+// whose account no longer exists are left out.
+func loadGroupMembers(ctx context.Context, id int64, invited bool) ([]GroupMember, error) {
+	query := `
+		SELECT user_id, role, invited_at, joined_at FROM group_members
+		WHERE group_id = $1 AND ($2 OR joined_at IS NOT NULL)
+		ORDER BY joined_at NULLS LAST, invited_at, user_id
+	`
+	rows, err := db.QueryContext(ctx, query, id, invited)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	defer rows.Close()
+
+	var list []GroupMember
+	var ids []int64
+	for rows.Next() {
+		var m GroupMember
+		var joinedAt sql.NullTime
+		if err := rows.Scan(&m.UserID, &m.Role, &m.InvitedAt, &joinedAt); err != nil {
+			return nil, apierror.Internal(err)
+		}
+		m.Status = MemberInvited
+		if joinedAt.Valid {
+			m.Status, m.JoinedAt = MemberJoined, &joinedAt.Time
+		}
+		list = append(list, m)
+		ids = append(ids, m.UserID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierror.Internal(err)
+	}
+
+	users, err := lookupUsers(ctx, nil, ids)
+	if err != nil {
+		return nil, err
+	}
+	members := []GroupMember{}
+	for _, m := range list {
+		if info, ok := users[m.UserID]; ok {
+			m.Username, m.DisplayName = info.Username, info.DisplayName
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+// saveGroup inserts group with its owner as the first member.
+func saveGroup(ctx context.Context, group *Group) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO groups (name, description, owner_id, time_zone, created_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id
+	`
+	err = tx.QueryRowContext(ctx, query, group.Name, group.Description, group.OwnerID, group.TimeZone, group.CreatedAt).Scan(&group.ID)
+	if err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO group_members (group_id, user_id, role, visibility, invited_at, joined_at)
+		VALUES ($1, $2, 'owner', 'visible', $3, $3)
+	`
+	if _, err := tx.ExecContext(ctx, query, group.ID, group.OwnerID, group.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeGroupMember removes userID from group id and from its challenges.
+func removeGroupMember(ctx context.Context, id, userID int64) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM group_members WHERE group_id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	query := `
+		DELETE FROM challenge_participants
+		WHERE user_id = $2 AND challenge_id IN (SELECT id FROM group_challenges WHERE group_id = $1)
+	`
+	if _, err := tx.ExecContext(ctx, query, id, userID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -1,24 +1,76 @@
 package habit
 
 import (
+	"database/sql"
 	"encoding/json"
-	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/metrics"
+	"habit-tracker/pkg/tracing"
+	"habit-tracker/pkg/validate"
 )
 
+// maxBodyBytes caps habit payloads; the largest field is a 2000 character description.
+const maxBodyBytes = 16 << 10
+
 type Habit struct {
-	ID          int64     `json:"id"`
-	UserID      int64     `json:"user_id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Schedule    string     `json:"schedule"`
+	Tags        []string   `json:"tags"`
+	Position    int        `json:"position"`
+	ArchivedAt  *time.Time `json:"archived_at"`
+	CategoryID  *int64     `json:"category_id"`
+	Polarity    string     `json:"polarity"`
 }
 
+// Schedules a habit can follow.
+const (
+	ScheduleDaily    = "daily"
+	ScheduleWeekdays = "weekdays"
+	ScheduleWeekends = "weekends"
+	ScheduleWeekly   = "weekly"
+)
+
+// Polarities: build habits are tracked when done, break habits (ones being
+// quit) are tracked when the user relapses.
+const (
+	PolarityBuild = "build"
+	PolarityBreak = "break"
+)
+
+// Name matches the VARCHAR(255) column; Description is TEXT but capped to
+// keep habit lists small. Schedule defaults to daily and Polarity to build;
+// break habits are always daily.
 type HabitRequest struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Name        string   `json:"name" validate:"required,max=255"`
+	Description string   `json:"description" validate:"max=2000"`
+	Schedule    string   `json:"schedule" validate:"oneof=daily weekdays weekends weekly"`
+	Tags        []string `json:"tags" validate:"max=20"`
+	CategoryID  int64    `json:"category_id" validate:"min=1"`
+	Polarity    string   `json:"polarity" validate:"oneof=build break"`
+}
+
+// UpdateHabitRequest is a partial update; nil fields are left unchanged.
+// Tags, when present, replace the habit's tags.
+type UpdateHabitRequest struct {
+	Name        *string   `json:"name" validate:"min=1,max=255"`
+	Description *string   `json:"description" validate:"max=2000"`
+	Schedule    *string   `json:"schedule" validate:"oneof=daily weekdays weekends weekly"`
+	Tags        *[]string `json:"tags" validate:"max=20"`
+	Position    *int      `json:"position" validate:"min=0,max=1000000"`
+	Archived    *bool     `json:"archived"`
+	// CategoryID 0 removes the habit from its category.
+	CategoryID *int64 `json:"category_id" validate:"min=0"`
 }
 
 type HabitResponse struct {
@@ -27,92 +79,98 @@ type HabitResponse struct {
 }
 
 type TrackRecord struct {
-	ID        int64     `json:"id"`
-	HabitID   int64     `json:"habit_id"`
-	UserID    int64     `json:"user_id"`
-	Completed bool      `json:"completed"`
-	Date      time.Time `json:"date"`
+	ID        int64             `json:"id"`
+	HabitID   int64             `json:"habit_id"`
+	UserID    int64             `json:"user_id"`
+	Completed bool              `json:"completed"`
+	Date      time.Time         `json:"date"`
+	Note      *string           `json:"note"`
+	Mood      *int              `json:"mood"`
+	Energy    *int              `json:"energy"`
+	Metadata  map[string]string `json:"metadata"`
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"`
 }
 
-type StatsResponse struct {
-	HabitName     string `json:"habit_name"`
-	TotalTrackings int    `json:"total_trackings"`
-	CompletedDays  int    `json:"completed_days"`
-	SkippedDays    int    `json:"skipped_days"`
-	FirstTracked   string `json:"first_tracked"`
-	LastTracked    string `json:"last_tracked"`
+// TrackRequest is the optional body of POST /habits/{id}/track. Mood and
+// energy are ratings from 1 (low) to 5 (high).
+type TrackRequest struct {
+	Note     string            `json:"note" validate:"max=2000"`
+	Mood     int               `json:"mood" validate:"min=1,max=5"`
+	Energy   int               `json:"energy" validate:"min=1,max=5"`
+	Metadata map[string]string `json:"metadata" validate:"max=20"`
 }
 
-type MotivationResponse struct {
-	Quote     string `json:"quote"`
-	Author    string `json:"author"`
-	Category  string `json:"category"`
+// StatsResponse describes a habit's whole history. Abstinence is only set
+// for break habits, whose track records are relapses, and Streak only for
+// the others.
+type StatsResponse struct {
+	HabitName      string           `json:"habit_name"`
+	TotalTrackings int              `json:"total_trackings"`
+	CompletedDays  int              `json:"completed_days"`
+	SkippedDays    int              `json:"skipped_days"`
+	FirstTracked   string           `json:"first_tracked"`
+	LastTracked    string           `json:"last_tracked"`
+	Abstinence     *AbstinenceStats `json:"abstinence,omitempty"`
+	Streak         *StreakStats     `json:"streak,omitempty"`
 }
 
-// In-memory storage
-var habits = make(map[int64]map[int64]*Habit)  // userID -> habitID -> Habit
-var trackRecords = make(map[int64]map[int64][]*TrackRecord) // userID -> habitID -> []TrackRecord
-var nextHabitIDs = make(map[int64]int64) // userID -> nextHabitID
-var nextTrackID int64 = 1
-
-func init() {
-	initDB()
+// StreakStats are a habit's current and longest streaks. Vacation days are
+// not scheduled and frozen days count as completed.
+type StreakStats struct {
+	Current      int      `json:"current"`
+	Longest      int      `json:"longest"`
+	Unit         string   `json:"unit"`
+	FrozenDays   []string `json:"frozen_days"`
+	VacationDays int      `json:"vacation_days"`
 }
 
-// Get the last logged-in user from the User Service
-func getLastLoggedInUser() (int64, error) {
-	// In a real application, this would be a proper service call
-	// For now, we'll use a simple HTTP request
-	resp, err := http.Get("http://localhost:8080/me")
-	if err != nil {
-		return 0, fmt.Errorf("failed to get last logged in user: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("failed to get last logged in user: status %d", resp.StatusCode)
-	}
-
-	var user struct {
-		ID int64 `json:"id"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return 0, fmt.Errorf("failed to decode user response: %v", err)
-	}
+type MotivationResponse struct {
+	Quote    string `json:"quote"`
+	Author   string `json:"author"`
+	Category string `json:"category"`
+}
 
-	return user.ID, nil
+// quoteClient fetches quotes for MotivationHandler.
+var quoteClient = &http.Client{
+	Timeout:   5 * time.Second,
+	Transport: tracing.Transport(&metrics.Transport{Upstream: "zenquotes"}),
 }
 
 func HabitsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	userID, err := getLastLoggedInUser()
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	c, err := authenticateCaller(r, scope)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "%v"}`, err), http.StatusUnauthorized)
+		apierror.Write(w, r, err)
 		return
 	}
 
 	switch r.Method {
 	case http.MethodPost:
-		createHabit(w, r, userID)
+		createHabit(w, r, c.UserID)
 	case http.MethodGet:
-		listHabits(w, r, userID)
+		listHabits(w, r, c)
 	default:
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		apierror.Write(w, r, apierror.MethodNotAllowed())
 	}
 }
 
 func TrackHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	userID, err := getLastLoggedInUser()
+	c, err := authenticateCaller(r, authz.ScopeTrack)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "%v"}`, err), http.StatusUnauthorized)
+		apierror.Write(w, r, err)
 		return
 	}
+	userID := c.UserID
 
 	if r.Method != http.MethodPost {
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		apierror.Write(w, r, apierror.MethodNotAllowed())
 		return
 	}
 
@@ -121,56 +179,97 @@ func TrackHandler(w http.ResponseWriter, r *http.Request) {
 	idStr = idStr[:len(idStr)-len("/track")]
 	habitID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid habit ID"}`, http.StatusBadRequest)
+		apierror.Write(w, r, apierror.BadRequest("Invalid habit ID"))
 		return
 	}
 
 	// Check if habit exists and belongs to current user
-	userHabits, exists := habits[userID]
-	if !exists {
-		http.Error(w, `{"error": "Habit not found"}`, http.StatusNotFound)
+	habit, err := loadHabit(r.Context(), userID, habitID)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("Habit not found"))
 		return
 	}
-	habit, exists := userHabits[habitID]
-	if !exists {
-		http.Error(w, `{"error": "Habit not found"}`, http.StatusNotFound)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
 		return
 	}
 
-	// Initialize user's track records map if it doesn't exist
-	if _, exists := trackRecords[userID]; !exists {
-		trackRecords[userID] = make(map[int64][]*TrackRecord)
+	// The journal fields are optional; tracking without a body still works.
+	var req TrackRequest
+	if r.Body != http.NoBody && r.ContentLength != 0 {
+		if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		if err := checkMetadata(req.Metadata); err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
 	}
 
 	// Create new track record
 	record := &TrackRecord{
 		HabitID:   habitID,
 		UserID:    userID,
-		Completed: true,
-		Date:      time.Now(),
+		Completed: habit.Polarity != PolarityBreak,
+		Date:      time.Now().UTC(),
+		Metadata:  req.Metadata,
+	}
+	if req.Note != "" {
+		record.Note = &req.Note
+	}
+	if req.Mood != 0 {
+		record.Mood = &req.Mood
+	}
+	if req.Energy != 0 {
+		record.Energy = &req.Energy
+	}
+	if record.Metadata == nil {
+		record.Metadata = map[string]string{}
 	}
 
 	// Save to database
-	err = saveTrackRecord(record)
+	err = saveTrackRecord(r.Context(), record)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to save track record: %v"}`, err), http.StatusInternalServerError)
+		apierror.Write(w, r, apierror.Internal(err))
 		return
 	}
 
-	// Update in-memory storage
-	trackRecords[userID][habitID] = append(trackRecords[userID][habitID], record)
-	nextTrackID = record.ID + 1
+	habitsTracked.Inc()
+
+	// The record is saved either way; a failed evaluation is retried by the
+	// next track or GET /achievements. Both look at this habit's history
+	// only, loaded once.
+	var unlocked []Achievement
+	var freezeEarned bool
+	done, err := loadDoneDays(r.Context(), userID, []*Habit{habit}, c.Location(), time.Time{}, civilDay(time.Now(), c.Location()))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading habit history failed", "user_id", userID, "error", err)
+	} else {
+		if unlocked, err = trackAchievements(r.Context(), userID, habit, done[habit.ID], c.Location()); err != nil {
+			slog.ErrorContext(r.Context(), "achievement evaluation failed", "user_id", userID, "error", err)
+		}
+		if freezeEarned, err = earnFreeze(r.Context(), userID, habit, done[habit.ID], c.Location()); err != nil {
+			slog.ErrorContext(r.Context(), "streak freeze evaluation failed", "user_id", userID, "error", err)
+		}
+	}
+	if unlocked == nil {
+		unlocked = []Achievement{}
+	}
 
 	// Create response with formatted dates
 	response := map[string]interface{}{
-		"message": "Habit tracked successfully",
+		"message": trackMessage(habit),
 		"habit": map[string]interface{}{
 			"id":          habit.ID,
 			"name":        habit.Name,
 			"description": habit.Description,
 			"created_at":  habit.CreatedAt.Format("2006-01-02 15:04:05"),
 		},
-		"tracked_at": record.Date.Format("2006-01-02 15:04:05"),
+		"tracked_at":    record.Date.Format("2006-01-02 15:04:05"),
+		"record":        record,
+		"achievements":  unlocked,
+		"freeze_earned": freezeEarned,
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -179,14 +278,15 @@ func TrackHandler(w http.ResponseWriter, r *http.Request) {
 func StatsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	userID, err := getLastLoggedInUser()
+	c, err := authenticateCaller(r, authz.ScopeRead)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "%v"}`, err), http.StatusUnauthorized)
+		apierror.Write(w, r, err)
 		return
 	}
+	userID := c.UserID
 
 	if r.Method != http.MethodGet {
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		apierror.Write(w, r, apierror.MethodNotAllowed())
 		return
 	}
 
@@ -195,33 +295,28 @@ func StatsHandler(w http.ResponseWriter, r *http.Request) {
 	idStr = idStr[:len(idStr)-len("/stats")]
 	habitID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid habit ID"}`, http.StatusBadRequest)
+		apierror.Write(w, r, apierror.BadRequest("Invalid habit ID"))
 		return
 	}
 
 	// Check if habit exists and belongs to current user
-	userHabits, exists := habits[userID]
-	if !exists {
-		http.Error(w, `{"error": "Habit not found"}`, http.StatusNotFound)
+	habit, err := loadHabit(r.Context(), userID, habitID)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("Habit not found"))
 		return
 	}
-	habit, exists := userHabits[habitID]
-	if !exists {
-		http.Error(w, `{"error": "Habit not found"}`, http.StatusNotFound)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
 		return
 	}
 
-	// Load track records from database
-	userTrackRecords, err := loadTrackRecords(userID)
+	// Load this habit's track records from database
+	records, err := loadHabitRecords(r.Context(), userID, habitID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to load track records: %v"}`, err), http.StatusInternalServerError)
+		apierror.Write(w, r, apierror.Internal(err))
 		return
 	}
 
-	// Update in-memory storage
-	trackRecords[userID] = userTrackRecords
-	records := userTrackRecords[habitID]
-
 	// Calculate stats
 	totalTrackings := len(records)
 	completedDays := 0
@@ -260,13 +355,31 @@ func StatsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := StatsResponse{
-		HabitName:     habit.Name,
+		HabitName:      habit.Name,
 		TotalTrackings: totalTrackings,
 		CompletedDays:  completedDays,
 		SkippedDays:    skippedDays,
 		FirstTracked:   firstTracked.Format("2006-01-02 15:04:05"),
 		LastTracked:    lastTracked.Format("2006-01-02 15:04:05"),
 	}
+	if habit.Polarity == PolarityBreak {
+		loc := c.Location()
+		relapses := make(map[string]bool, len(records))
+		for _, record := range records {
+			relapses[dayKey(civilDay(record.Date, loc))] = true
+		}
+		from, to, _ := activeRange(habit, summaryRange{To: civilDay(time.Now(), loc)}, loc)
+		stats := abstinence(relapses, from, to)
+		stats.Relapses = len(records)
+		response.Abstinence = &stats
+	} else {
+		streak, err := habitStreak(r.Context(), userID, habit, records, c.Location())
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		response.Streak = streak
+	}
 
 	json.NewEncoder(w).Encode(response)
 }
@@ -275,14 +388,19 @@ func MotivationHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		apierror.Write(w, r, apierror.MethodNotAllowed())
 		return
 	}
 
 	// Fetch quote from ZenQuotes API
-	resp, err := http.Get("https://zenquotes.io/api/random")
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, "https://zenquotes.io/api/random", nil)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to fetch motivation quote"}`, http.StatusInternalServerError)
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	resp, err := quoteClient.Do(req)
+	if err != nil {
+		apierror.Write(w, r, apierror.Upstream("Failed to fetch motivation quote", err))
 		return
 	}
 	defer resp.Body.Close()
@@ -292,15 +410,15 @@ func MotivationHandler(w http.ResponseWriter, r *http.Request) {
 		Author string `json:"a"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&quotes); err != nil || len(quotes) == 0 {
-		http.Error(w, `{"error": "Failed to decode motivation quote"}`, http.StatusInternalServerError)
+		apierror.Write(w, r, apierror.Upstream("Failed to decode motivation quote", err))
 		return
 	}
 
 	// Create response with the fetched quote
 	response := MotivationResponse{
-		Quote:     quotes[0].Quote,
-		Author:    quotes[0].Author,
-		Category:  "Motivation",
+		Quote:    quotes[0].Quote,
+		Author:   quotes[0].Author,
+		Category: "Motivation",
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -308,20 +426,28 @@ func MotivationHandler(w http.ResponseWriter, r *http.Request) {
 
 func createHabit(w http.ResponseWriter, r *http.Request, userID int64) {
 	var req HabitRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
 		return
 	}
 
-	if req.Name == "" {
-		http.Error(w, `{"error": "Name is required"}`, http.StatusBadRequest)
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		apierror.Write(w, r, err)
 		return
 	}
-
-	// Initialize user's habit map if it doesn't exist
-	if _, exists := habits[userID]; !exists {
-		habits[userID] = make(map[int64]*Habit)
-		nextHabitIDs[userID] = 1
+	if req.Polarity == "" {
+		req.Polarity = PolarityBuild
+	}
+	if req.Polarity == PolarityBreak && req.Schedule != "" && req.Schedule != ScheduleDaily {
+		apierror.Write(w, r, errBreakSchedule)
+		return
+	}
+	if req.Schedule == "" {
+		req.Schedule = ScheduleDaily
+	}
+	if req.CategoryID != 0 && !checkCategory(w, r, userID, req.CategoryID) {
+		return
 	}
 
 	habit := &Habit{
@@ -329,18 +455,22 @@ func createHabit(w http.ResponseWriter, r *http.Request, userID int64) {
 		Name:        req.Name,
 		Description: req.Description,
 		CreatedAt:   time.Now(),
+		Schedule:    req.Schedule,
+		Tags:        tags,
+		Polarity:    req.Polarity,
+	}
+	if req.CategoryID != 0 {
+		habit.CategoryID = &req.CategoryID
 	}
 
 	// Save to database
-	err := saveHabit(habit)
+	err = saveHabit(r.Context(), habit)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to save habit: %v"}`, err), http.StatusInternalServerError)
+		apierror.Write(w, r, apierror.Internal(err))
 		return
 	}
 
-	// Update in-memory storage
-	habits[userID][habit.ID] = habit
-	nextHabitIDs[userID] = habit.ID + 1
+	habitsCreated.Inc()
 
 	// Create response with formatted date
 	response := map[string]interface{}{
@@ -350,6 +480,11 @@ func createHabit(w http.ResponseWriter, r *http.Request, userID int64) {
 			"name":        habit.Name,
 			"description": habit.Description,
 			"created_at":  habit.CreatedAt.Format("2006-01-02 15:04:05"),
+			"schedule":    habit.Schedule,
+			"tags":        habit.Tags,
+			"position":    habit.Position,
+			"category_id": habit.CategoryID,
+			"polarity":    habit.Polarity,
 		},
 	}
 
@@ -357,30 +492,71 @@ func createHabit(w http.ResponseWriter, r *http.Request, userID int64) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func listHabits(w http.ResponseWriter, r *http.Request, userID int64) {
-	// Load habits from database
-	userHabits, err := loadHabits(userID)
+// HabitHandler updates a single habit with PATCH /habits/{id}.
+func HabitHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to load habits: %v"}`, err), http.StatusInternalServerError)
+		apierror.Write(w, r, err)
 		return
 	}
 
-	// Update in-memory storage
-	habits[userID] = userHabits
+	if r.Method != http.MethodPatch {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
 
-	habitList := make([]*Habit, 0, len(userHabits))
-	for _, habit := range userHabits {
-		habitList = append(habitList, habit)
+	habitID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/habits/"), 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid habit ID"))
+		return
 	}
 
-	json.NewEncoder(w).Encode(habitList)
-}
+	var req UpdateHabitRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		apierror.Write(w, r, apierror.Validation(apierror.FieldError{Field: "name", Code: "required", Message: "is required"}))
+		return
+	}
+	if req.Tags != nil {
+		tags, err := normalizeTags(*req.Tags)
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		req.Tags = &tags
+	}
+
+	if req.CategoryID != nil && *req.CategoryID != 0 && !checkCategory(w, r, userID, *req.CategoryID) {
+		return
+	}
+
+	current, err := loadHabit(r.Context(), userID, habitID)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("Habit not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if current.Polarity == PolarityBreak && req.Schedule != nil && *req.Schedule != ScheduleDaily {
+		apierror.Write(w, r, errBreakSchedule)
+		return
+	}
+	if err := updateHabit(r.Context(), userID, habitID, req); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
 
-func ClearAllHabits() {
-	// Clear all maps
-	habits = make(map[int64]map[int64]*Habit)
-	trackRecords = make(map[int64]map[int64][]*TrackRecord)
-	nextHabitIDs = make(map[int64]int64)
-	// Reset counters
-	nextTrackID = 1
-} 
\ No newline at end of file
+	habit, err := loadHabit(r.Context(), userID, habitID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	json.NewEncoder(w).Encode(HabitResponse{Message: "Habit updated successfully", Habit: habit})
+}
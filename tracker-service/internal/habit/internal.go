@@ -0,0 +1,37 @@
+package habit
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"habit-tracker/pkg/apierror"
+)
+
+// DeleteUserDataHandler erases everything the tracker stores for a user. It
+// is called by the User Service when an account is deleted, must be mounted
+// behind internalauth, and is idempotent so deliveries can be retried.
+func DeleteUserDataHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodDelete {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/internal/users/")
+	userID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid user ID"))
+		return
+	}
+
+	if err := deleteUserData(r.Context(), userID); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	slog.InfoContext(r.Context(), "user data erased", "user_id", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,310 @@
+package habit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+)
+
+const (
+	defaultLeaderboardLimit = 20
+	maxLeaderboardLimit     = 50
+)
+
+// LeaderboardEntry is one ranked member. Anonymous members have no user ID
+// or name; the caller's own entry always has them.
+type LeaderboardEntry struct {
+	Rank        int      `json:"rank"`
+	UserID      *int64   `json:"user_id,omitempty"`
+	Username    string   `json:"username,omitempty"`
+	DisplayName string   `json:"display_name,omitempty"`
+	Anonymous   bool     `json:"anonymous"`
+	Score       int      `json:"score"`
+	Progress    *float64 `json:"progress,omitempty"`
+	You         bool     `json:"you"`
+}
+
+// LeaderboardResponse lists the top Limit entries. You is the caller's
+// entry wherever it ranks, or null if they are hidden or not taking part.
+type LeaderboardResponse struct {
+	GroupID     int64              `json:"group_id"`
+	ChallengeID int64              `json:"challenge_id,omitempty"`
+	Metric      string             `json:"metric"`
+	From        string             `json:"from"`
+	To          string             `json:"to"`
+	Ranked      int                `json:"ranked"`
+	Entries     []LeaderboardEntry `json:"entries"`
+	You         *LeaderboardEntry  `json:"you"`
+}
+
+// rankQuery selects the members to rank and the completions that count.
+// ChallengeID 0 ranks every member on all of their habits; otherwise only
+// the challenge's participants, on the habit each of them linked.
+type rankQuery struct {
+	GroupID     int64
+	ChallengeID int64
+	Metric      string
+	From, To    time.Time
+	Loc         *time.Location
+	CallerID    int64
+	Limit       int
+}
+
+// rankedMember is a row of the ranking query.
+type rankedMember struct {
+	UserID     int64
+	Visibility string
+	Score      int
+	Rank       int
+}
+
+// GroupLeaderboardHandler answers GET /groups/{id}/leaderboard, ranking
+// members by completions between from and to (default the last 30 days)
+// or by current streak.
+func GroupLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeRead)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/groups/"), "/leaderboard")
+	groupID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid group ID"))
+		return
+	}
+	group, ok := findGroup(w, r, userID, groupID)
+	if !ok {
+		return
+	}
+
+	loc := group.Location()
+	today := civilDay(time.Now(), loc)
+	rng, details := parseSummaryRange(r, today)
+	q := rankQuery{GroupID: groupID, Metric: MetricCompletions, From: rng.From, To: rng.To, Loc: loc, CallerID: userID}
+	details = append(details, parseLeaderboardQuery(r, &q)...)
+	if len(details) > 0 {
+		apierror.Write(w, r, apierror.Validation(details...))
+		return
+	}
+	// A streak is as long as it is: look back as far as a range may.
+	if q.Metric == MetricStreak && r.URL.Query().Get("from") == "" {
+		q.From = q.To.AddDate(0, 0, -(maxSummaryDays - 1))
+	}
+
+	response, err := leaderboard(r.Context(), q, nil)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// ChallengeLeaderboardHandler answers GET
+// /groups/{id}/challenges/{challengeID}/leaderboard, ranking participants
+// on their linked habit from the challenge's start to its end (or today).
+// metric defaults to the challenge's.
+func ChallengeLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeRead)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+	group, challenge, ok := findChallenge(w, r, userID, 4)
+	if !ok {
+		return
+	}
+
+	loc := group.Location()
+	from, _ := time.Parse(dateLayout, challenge.StartDate)
+	to, _ := time.Parse(dateLayout, challenge.EndDate)
+	if today := civilDay(time.Now(), loc); today.Before(to) {
+		to = today
+	}
+	q := rankQuery{GroupID: group.ID, ChallengeID: challenge.ID, Metric: challenge.Metric, From: from, To: to, Loc: loc, CallerID: userID}
+	if details := parseLeaderboardQuery(r, &q); len(details) > 0 {
+		apierror.Write(w, r, apierror.Validation(details...))
+		return
+	}
+
+	var target *int
+	if q.Metric == challenge.Metric {
+		target = challenge.Target
+	}
+	response, err := leaderboard(r.Context(), q, target)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseLeaderboardQuery reads ?metric= and ?limit= into q.
+func parseLeaderboardQuery(r *http.Request, q *rankQuery) []apierror.FieldError {
+	var details []apierror.FieldError
+	switch v := r.URL.Query().Get("metric"); v {
+	case "":
+	case MetricCompletions, MetricStreak:
+		q.Metric = v
+	default:
+		details = append(details, apierror.FieldError{Field: "metric", Code: "invalid_choice", Message: "metric must be one of: completions, streak"})
+	}
+	q.Limit = defaultLeaderboardLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLeaderboardLimit {
+			details = append(details, apierror.FieldError{Field: "limit", Code: "out_of_range", Message: "limit must be between 1 and " + strconv.Itoa(maxLeaderboardLimit)})
+		}
+		q.Limit = n
+	}
+	return details
+}
+
+// leaderboard ranks the members in the database and names the ones on the
+// page with one directory lookup. Progress is the share of target reached.
+func leaderboard(ctx context.Context, q rankQuery, target *int) (LeaderboardResponse, error) {
+	response := LeaderboardResponse{
+		GroupID:     q.GroupID,
+		ChallengeID: q.ChallengeID,
+		Metric:      q.Metric,
+		From:        dayKey(q.From),
+		To:          dayKey(q.To),
+		Entries:     []LeaderboardEntry{},
+	}
+	rows, ranked, err := rankMembers(ctx, q)
+	if err != nil {
+		return response, apierror.Internal(err)
+	}
+	response.Ranked = ranked
+
+	var ids []int64
+	for _, m := range rows {
+		if m.Visibility == VisibilityVisible || m.UserID == q.CallerID {
+			ids = append(ids, m.UserID)
+		}
+	}
+	users, err := lookupUsers(ctx, nil, ids)
+	if err != nil {
+		return response, err
+	}
+
+	for i, m := range rows {
+		entry := LeaderboardEntry{Rank: m.Rank, Score: m.Score, You: m.UserID == q.CallerID}
+		if info, ok := users[m.UserID]; ok && (m.Visibility == VisibilityVisible || entry.You) {
+			entry.UserID = &rows[i].UserID
+			entry.Username, entry.DisplayName = info.Username, info.DisplayName
+		}
+		entry.Anonymous = m.Visibility != VisibilityVisible
+		if target != nil {
+			p := round3(min(1, float64(m.Score)/float64(*target)))
+			entry.Progress = &p
+		}
+		if entry.You {
+			you := entry
+			response.You = &you
+		}
+		// The caller's row is also returned when it ranks below the page.
+		if len(response.Entries) < q.Limit {
+			response.Entries = append(response.Entries, entry)
+		}
+	}
+	return response, nil
+}
+
+// rankMembers ranks the group's joined members, hidden ones excluded,
+// without loading their records: the database reduces track records to
+// distinct completed days and scores them. Completions count each habit
+// once per day; a streak is the run of consecutive days with a completion
+// that ends on q.To or the day before. Only days from when a member joined
+// the group, and the challenge if any, count. It returns the top q.Limit
+// rows plus the caller's, in rank order, and how many members were ranked.
+func rankMembers(ctx context.Context, q rankQuery) ([]rankedMember, int, error) {
+	scores := completionScores
+	if q.Metric == MetricStreak {
+		scores = streakScores
+	}
+	query := fmt.Sprintf(rankingQuery, scores)
+	rows, err := db.QueryContext(ctx, query, q.GroupID, q.ChallengeID, q.Loc.String(),
+		dayKey(q.From), dayKey(q.To), q.Limit, q.CallerID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var list []rankedMember
+	total := 0
+	for rows.Next() {
+		var m rankedMember
+		if err := rows.Scan(&m.UserID, &m.Visibility, &m.Score, &m.Rank, &total); err != nil {
+			return nil, 0, err
+		}
+		list = append(list, m)
+	}
+	return list, total, rows.Err()
+}
+
+// rankingQuery takes the scores CTE as its only verb. The range filter on
+// the raw timestamp, a day wider than the calendar range on either side,
+// lets the partial index on (user_id, date) narrow the scan before the time zone
+// conversion.
+const rankingQuery = `
+	WITH members AS (
+		SELECT m.user_id, m.visibility, p.habit_id,
+			GREATEST($4::date, (m.joined_at AT TIME ZONE 'UTC' AT TIME ZONE $3)::date,
+				(p.joined_at AT TIME ZONE 'UTC' AT TIME ZONE $3)::date) AS since
+		FROM group_members m
+		LEFT JOIN challenge_participants p ON p.challenge_id = $2 AND p.user_id = m.user_id
+		WHERE m.group_id = $1 AND m.joined_at IS NOT NULL AND m.visibility <> 'hidden'
+			AND ($2 = 0 OR p.habit_id IS NOT NULL)
+	), days AS (
+		SELECT DISTINCT t.user_id, t.habit_id, (t.date AT TIME ZONE 'UTC' AT TIME ZONE $3)::date AS day
+		FROM members m
+		JOIN track_records t ON t.user_id = m.user_id AND (m.habit_id IS NULL OR t.habit_id = m.habit_id)
+		WHERE t.completed
+			AND t.date >= $4::date - 1 AND t.date < $5::date + 2
+			AND (t.date AT TIME ZONE 'UTC' AT TIME ZONE $3)::date BETWEEN m.since AND $5::date
+	), %s, ranked AS (
+		SELECT m.user_id, m.visibility, COALESCE(s.score, 0) AS score,
+			RANK() OVER (ORDER BY COALESCE(s.score, 0) DESC) AS rank,
+			ROW_NUMBER() OVER (ORDER BY COALESCE(s.score, 0) DESC, m.user_id) AS position,
+			COUNT(*) OVER () AS total
+		FROM members m LEFT JOIN scores s ON s.user_id = m.user_id
+	)
+	SELECT user_id, visibility, score, rank, total FROM ranked
+	WHERE position <= $6 OR user_id = $7
+	ORDER BY position
+`
+
+const completionScores = `scores AS (
+		SELECT user_id, COUNT(*) AS score FROM days GROUP BY user_id
+	)`
+
+// Consecutive days minus their row number are constant within a run.
+const streakScores = `active AS (
+		SELECT DISTINCT user_id, day FROM days
+	), runs AS (
+		SELECT user_id, day, day - (ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY day))::int AS run
+		FROM active
+	), scores AS (
+		SELECT user_id, COUNT(*) AS score FROM runs
+		GROUP BY user_id, run HAVING MAX(day) >= $5::date - 1
+	)`
@@ -0,0 +1,322 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+)
+
+const (
+	defaultHabitPageSize = 50
+	maxHabitPageSize     = 200
+
+	// cursorTimeLayout round-trips TIMESTAMP values exactly.
+	cursorTimeLayout = "2006-01-02T15:04:05.999999"
+)
+
+// HabitListResponse is the paginated form of GET /habits. NextCursor is null
+// on the last page.
+type HabitListResponse struct {
+	Habits     []HabitSummary `json:"habits"`
+	NextCursor *string        `json:"next_cursor"`
+}
+
+// habitQuery is a parsed GET /habits request.
+type habitQuery struct {
+	Sort     string // created, name or position
+	Desc     bool
+	Archived string // false, true or all
+	Tag      string
+	Category int64
+	Schedule string
+	Limit    int // 0 returns every match
+	Cursor   *habitCursor
+	Envelope bool
+	Include  includes
+}
+
+// habitCursor points just past the last habit of a page. It records the sort
+// it was issued for so it cannot be replayed against a different order.
+type habitCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d,omitempty"`
+	Key  string `json:"k"`
+	ID   int64  `json:"i"`
+}
+
+func (c habitCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeHabitCursor(s string) (*habitCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c habitCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// sortKeys maps each sort to its SQL key expression, the placeholder type
+// the cursor key is cast to, and how a habit's key is written into a cursor.
+// A nil key means the cursor takes the expression's value as selected by the
+// query: LOWER under the database's collation need not match Go's.
+var sortKeys = map[string]struct {
+	expr, cast string
+	key        func(*Habit) string
+}{
+	"created":  {"h.created_at", "timestamp", func(h *Habit) string { return h.CreatedAt.Format(cursorTimeLayout) }},
+	"name":     {"LOWER(h.name)", "text", nil},
+	"position": {"h.position", "integer", func(h *Habit) string { return strconv.Itoa(h.Position) }},
+}
+
+// keyScanner scans a row of habitColumns followed by the sort key.
+type keyScanner struct {
+	rows *sql.Rows
+	key  *string
+}
+
+func (s keyScanner) Scan(dest ...any) error {
+	return s.rows.Scan(append(dest, s.key)...)
+}
+
+// parseHabitQuery reads the list parameters. Without limit, cursor or
+// format=envelope the response stays the bare array older clients expect.
+func parseHabitQuery(r *http.Request) (habitQuery, error) {
+	q := r.URL.Query()
+	hq := habitQuery{Sort: "created", Archived: "false", Tag: q.Get("tag"), Schedule: q.Get("schedule")}
+
+	var details []apierror.FieldError
+	if v := q.Get("sort"); v != "" {
+		if _, ok := sortKeys[v]; !ok {
+			details = append(details, apierror.FieldError{Field: "sort", Code: "invalid_choice", Message: "sort must be one of: created, name, position"})
+		}
+		hq.Sort = v
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		hq.Desc = true
+	default:
+		details = append(details, apierror.FieldError{Field: "order", Code: "invalid_choice", Message: "order must be one of: asc, desc"})
+	}
+	switch v := q.Get("archived"); v {
+	case "":
+	case "false", "true", "all":
+		hq.Archived = v
+	default:
+		details = append(details, apierror.FieldError{Field: "archived", Code: "invalid_choice", Message: "archived must be one of: false, true, all"})
+	}
+	if v := q.Get("category"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			details = append(details, apierror.FieldError{Field: "category", Code: "invalid_type", Message: "category must be a category ID"})
+		}
+		hq.Category = n
+	}
+	switch hq.Schedule {
+	case "", ScheduleDaily, ScheduleWeekdays, ScheduleWeekends, ScheduleWeekly:
+	default:
+		details = append(details, apierror.FieldError{Field: "schedule", Code: "invalid_choice", Message: "schedule must be one of: daily, weekdays, weekends, weekly"})
+	}
+	switch q.Get("format") {
+	case "":
+		hq.Envelope = q.Has("limit") || q.Has("cursor")
+	case "envelope":
+		hq.Envelope = true
+	case "array":
+	default:
+		details = append(details, apierror.FieldError{Field: "format", Code: "invalid_choice", Message: "format must be one of: array, envelope"})
+	}
+	if hq.Envelope {
+		hq.Limit = defaultHabitPageSize
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxHabitPageSize {
+			details = append(details, apierror.FieldError{Field: "limit", Code: "out_of_range", Message: fmt.Sprintf("limit must be between 1 and %d", maxHabitPageSize)})
+		}
+		hq.Limit = n
+	}
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeHabitCursor(v)
+		if err != nil || c.Sort != hq.Sort || c.Desc != hq.Desc || !validCursorKey(c) {
+			details = append(details, apierror.FieldError{Field: "cursor", Code: "invalid_cursor", Message: "cursor is malformed or was issued for a different sort order"})
+		}
+		hq.Cursor = c
+	}
+	if v := q.Get("include"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			switch strings.TrimSpace(field) {
+			case "today":
+				hq.Include.Today = true
+			case "streak":
+				hq.Include.Streak = true
+			case "week":
+				hq.Include.Week = true
+			default:
+				details = append(details, apierror.FieldError{Field: "include", Code: "invalid_choice", Message: "include must be a comma-separated list of: today, streak, week"})
+			}
+		}
+	}
+	if len(details) > 0 {
+		return habitQuery{}, apierror.Validation(details...)
+	}
+	return hq, nil
+}
+
+func validCursorKey(c *habitCursor) bool {
+	switch c.Sort {
+	case "created":
+		_, err := time.Parse(cursorTimeLayout, c.Key)
+		return err == nil
+	case "position":
+		_, err := strconv.Atoi(c.Key)
+		return err == nil
+	}
+	return true
+}
+
+func listHabits(w http.ResponseWriter, r *http.Request, c caller) {
+	hq, err := parseHabitQuery(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	list, next, err := queryHabits(r.Context(), c.UserID, hq)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	summaries, err := summarizeHabits(r.Context(), c.UserID, list, hq.Include, c.Location(), time.Now())
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	if !hq.Envelope {
+		json.NewEncoder(w).Encode(summaries)
+		return
+	}
+	response := HabitListResponse{Habits: summaries}
+	if next != nil {
+		cursor := next.encode()
+		response.NextCursor = &cursor
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// queryHabits returns one page of habits with their tags, plus the cursor
+// of the next page if there is one. Pages are keyset-paginated on the sort
+// key with the habit ID as tie-breaker, so order is stable across requests.
+func queryHabits(ctx context.Context, userID int64, hq habitQuery) ([]*Habit, *habitCursor, error) {
+	sk := sortKeys[hq.Sort]
+	dir, cmp := "ASC", ">"
+	if hq.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	args := []any{userID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := []string{"h.user_id = $1"}
+	switch hq.Archived {
+	case "false":
+		where = append(where, "h.archived_at IS NULL")
+	case "true":
+		where = append(where, "h.archived_at IS NOT NULL")
+	}
+	if hq.Schedule != "" {
+		where = append(where, "h.schedule = "+arg(hq.Schedule))
+	}
+	if hq.Category != 0 {
+		where = append(where, "h.category_id = "+arg(hq.Category))
+	}
+	if hq.Tag != "" {
+		where = append(where, `EXISTS (
+			SELECT 1 FROM habit_tags ht JOIN tags t ON t.id = ht.tag_id
+			WHERE ht.user_id = h.user_id AND ht.habit_id = h.id AND LOWER(t.name) = LOWER(`+arg(hq.Tag)+`))`)
+	}
+	if c := hq.Cursor; c != nil {
+		where = append(where, fmt.Sprintf("(%s, h.id) %s (%s::%s, %s)", sk.expr, cmp, arg(c.Key), sk.cast, arg(c.ID)))
+	}
+
+	columns := habitColumns
+	if sk.key == nil {
+		columns += ", " + sk.expr
+	}
+	query := `SELECT ` + columns + ` FROM habits h WHERE ` + strings.Join(where, " AND ") +
+		fmt.Sprintf(" ORDER BY %s %s, h.id %s", sk.expr, dir, dir)
+	if hq.Limit > 0 {
+		// One extra row tells us whether another page follows.
+		query += " LIMIT " + arg(hq.Limit+1)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	list := []*Habit{}
+	var keys []string
+	for rows.Next() {
+		var row rowScanner = rows
+		var key string
+		if sk.key == nil {
+			row = keyScanner{rows: rows, key: &key}
+		}
+		habit, err := scanHabit(row)
+		if err != nil {
+			return nil, nil, err
+		}
+		list = append(list, habit)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var next *habitCursor
+	if hq.Limit > 0 && len(list) > hq.Limit {
+		list = list[:hq.Limit]
+		last := list[len(list)-1]
+		key := keys[len(list)-1]
+		if sk.key != nil {
+			key = sk.key(last)
+		}
+		next = &habitCursor{Sort: hq.Sort, Desc: hq.Desc, Key: key, ID: last.ID}
+	}
+
+	ids := make([]int64, len(list))
+	for i, habit := range list {
+		ids[i] = habit.ID
+	}
+	tags, err := loadHabitTags(ctx, userID, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, habit := range list {
+		if t, ok := tags[habit.ID]; ok {
+			habit.Tags = t
+		}
+	}
+	return list, next, nil
+}
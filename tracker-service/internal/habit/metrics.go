@@ -0,0 +1,18 @@
+package habit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	habitsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "habits_created_total",
+		Help: "Habits created.",
+	})
+
+	habitsTracked = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "habit_tracks_total",
+		Help: "Track records saved.",
+	})
+)
@@ -0,0 +1,599 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/validate"
+)
+
+// Partnership statuses.
+const (
+	PartnerPending  = "pending"
+	PartnerAccepted = "accepted"
+)
+
+const maxPartners = 50
+
+// Partner is one side of a partnership as seen by the other. ID is the
+// partnership's ID; Direction is "incoming" or "outgoing" for pending
+// invitations.
+type Partner struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	Username    string     `json:"username"`
+	DisplayName string     `json:"display_name"`
+	Status      string     `json:"status"`
+	Direction   string     `json:"direction,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+}
+
+type PartnerListResponse struct {
+	Partners []Partner `json:"partners"`
+}
+
+type InvitePartnerRequest struct {
+	Username string `json:"username" validate:"required,max=50"`
+}
+
+// HabitSharesRequest replaces the partners a habit is shared with.
+type HabitSharesRequest struct {
+	PartnerIDs []int64 `json:"partner_ids" validate:"max=50"`
+}
+
+type HabitSharesResponse struct {
+	HabitID    int64   `json:"habit_id"`
+	PartnerIDs []int64 `json:"partner_ids"`
+}
+
+// PartnerHabitsResponse is a partner's shared habits with their progress,
+// computed in the partner's time zone.
+type PartnerHabitsResponse struct {
+	Partner Partner        `json:"partner"`
+	Habits  []HabitSummary `json:"habits"`
+}
+
+// partnership is a row of the partnerships table.
+type partnership struct {
+	ID          int64
+	RequesterID int64
+	AddresseeID int64
+	Status      string
+	CreatedAt   time.Time
+	AcceptedAt  *time.Time
+}
+
+// other returns the user on the other side of p from userID.
+func (p partnership) other(userID int64) int64 {
+	if p.RequesterID == userID {
+		return p.AddresseeID
+	}
+	return p.RequesterID
+}
+
+// PartnersHandler lists partners and invitations (GET) and invites a user
+// by username (POST). Inviting someone who has already invited the caller
+// accepts their invitation.
+func PartnersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	userID, err := authenticate(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := listPartnerships(r.Context(), userID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		partners, err := describePartners(r.Context(), userID, list)
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(PartnerListResponse{Partners: partners})
+	case http.MethodPost:
+		invitePartner(w, r, userID)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+func invitePartner(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req InvitePartnerRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	users, err := lookupUsers(r.Context(), []string{strings.TrimSpace(req.Username)}, nil)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	var invitee *userInfo
+	for _, u := range users {
+		invitee = &u
+	}
+	if invitee == nil {
+		apierror.Write(w, r, apierror.NotFound("User not found"))
+		return
+	}
+	if invitee.ID == userID {
+		apierror.Write(w, r, apierror.Validation(apierror.FieldError{Field: "username", Code: "invalid_choice", Message: "you cannot invite yourself"}))
+		return
+	}
+
+	existing, err := findPartnership(r.Context(), userID, invitee.ID)
+	switch {
+	case err == nil && existing.Status == PartnerPending && existing.AddresseeID == userID:
+		p, err := acceptPartnership(r.Context(), userID, existing.ID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		json.NewEncoder(w).Encode(partnerView(userID, p, *invitee))
+		return
+	case err == nil:
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "You are already partners or have a pending invitation"))
+		return
+	case err != sql.ErrNoRows:
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	var count int
+	query := `SELECT COUNT(*) FROM partnerships WHERE requester_id = $1 OR addressee_id = $1`
+	if err := db.QueryRowContext(r.Context(), query, userID).Scan(&count); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if count >= maxPartners {
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "Partner limit reached"))
+		return
+	}
+
+	p := partnership{RequesterID: userID, AddresseeID: invitee.ID, Status: PartnerPending, CreatedAt: time.Now().UTC()}
+	query = `
+		INSERT INTO partnerships (requester_id, addressee_id, status, created_at)
+		VALUES ($1, $2, $3, $4) RETURNING id
+	`
+	err = db.QueryRowContext(r.Context(), query, p.RequesterID, p.AddresseeID, p.Status, p.CreatedAt).Scan(&p.ID)
+	if isUniqueViolation(err) {
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "You are already partners or have a pending invitation"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(partnerView(userID, p, *invitee))
+}
+
+// PartnerHandler ends a partnership, declines an invitation or withdraws
+// one with DELETE /partners/{id}. Habits shared either way stop being
+// shared.
+func PartnerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/partners/"), 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid partner ID"))
+		return
+	}
+
+	deleted, err := deletePartnership(r.Context(), userID, id)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if !deleted {
+		apierror.Write(w, r, apierror.NotFound("Partner not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptPartnerHandler accepts an incoming invitation with POST
+// /partners/{id}/accept.
+func AcceptPartnerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/partners/"), "/accept")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid partner ID"))
+		return
+	}
+
+	p, err := acceptPartnership(r.Context(), userID, id)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("Invitation not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	partners, err := describePartners(r.Context(), userID, []partnership{p})
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(partners[0])
+}
+
+// PartnerHabitsHandler answers GET /partners/{id}/habits with the habits
+// the partner shares with the caller, including today's status, streak and
+// the trailing week. The view is read-only: streaks count the partner's
+// spent freezes but never spend one.
+func PartnerHabitsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeRead)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/partners/"), "/habits")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid partner ID"))
+		return
+	}
+
+	p, err := loadPartnership(r.Context(), userID, id)
+	if err == sql.ErrNoRows || (err == nil && p.Status != PartnerAccepted) {
+		apierror.Write(w, r, apierror.NotFound("Partner not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	partnerID := p.other(userID)
+	users, err := lookupUsers(r.Context(), nil, []int64{partnerID})
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	info, ok := users[partnerID]
+	if !ok {
+		apierror.Write(w, r, apierror.NotFound("Partner not found"))
+		return
+	}
+
+	list, err := loadSharedHabits(r.Context(), partnerID, userID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	summaries, err := summarizeHabits(r.Context(), partnerID, list, includes{Today: true, Streak: true, Week: true}, info.Location(), time.Now())
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	json.NewEncoder(w).Encode(PartnerHabitsResponse{Partner: partnerView(userID, p, info), Habits: summaries})
+}
+
+// HabitSharesHandler lists (GET) or replaces (PUT) the partners one of the
+// caller's habits is shared with. Only accepted partners can be added.
+func HabitSharesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	userID, err := authenticate(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/habits/"), "/shares")
+	habitID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid habit ID"))
+		return
+	}
+	if _, err := loadHabit(r.Context(), userID, habitID); err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("Habit not found"))
+		return
+	} else if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req HabitSharesRequest
+		if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		ids := uniqueIDs(req.PartnerIDs)
+		partners, err := acceptedPartnerIDs(r.Context(), userID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		for _, id := range ids {
+			if !partners[id] {
+				apierror.Write(w, r, apierror.Validation(apierror.FieldError{Field: "partner_ids", Code: "not_found", Message: "every ID must be an accepted partner's user ID"}))
+				return
+			}
+		}
+		if err := setHabitShares(r.Context(), userID, habitID, ids); err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	ids, err := loadHabitShares(r.Context(), userID, habitID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	json.NewEncoder(w).Encode(HabitSharesResponse{HabitID: habitID, PartnerIDs: ids})
+}
+
+func partnerView(userID int64, p partnership, info userInfo) Partner {
+	view := Partner{
+		ID:          p.ID,
+		UserID:      info.ID,
+		Username:    info.Username,
+		DisplayName: info.DisplayName,
+		Status:      p.Status,
+		CreatedAt:   p.CreatedAt,
+		AcceptedAt:  p.AcceptedAt,
+	}
+	if p.Status == PartnerPending {
+		view.Direction = "outgoing"
+		if p.AddresseeID == userID {
+			view.Direction = "incoming"
+		}
+	}
+	return view
+}
+
+// describePartners adds the other users' names with one directory lookup.
+// Partnerships with accounts that no longer exist are left out.
+func describePartners(ctx context.Context, userID int64, list []partnership) ([]Partner, error) {
+	ids := make([]int64, len(list))
+	for i, p := range list {
+		ids[i] = p.other(userID)
+	}
+	users, err := lookupUsers(ctx, nil, ids)
+	if err != nil {
+		return nil, err
+	}
+	partners := []Partner{}
+	for _, p := range list {
+		if info, ok := users[p.other(userID)]; ok {
+			partners = append(partners, partnerView(userID, p, info))
+		}
+	}
+	return partners, nil
+}
+
+const partnershipColumns = `id, requester_id, addressee_id, status, created_at, accepted_at`
+
+func scanPartnership(row rowScanner) (partnership, error) {
+	var p partnership
+	var acceptedAt sql.NullTime
+	err := row.Scan(&p.ID, &p.RequesterID, &p.AddresseeID, &p.Status, &p.CreatedAt, &acceptedAt)
+	if acceptedAt.Valid {
+		p.AcceptedAt = &acceptedAt.Time
+	}
+	return p, err
+}
+
+func listPartnerships(ctx context.Context, userID int64) ([]partnership, error) {
+	query := `SELECT ` + partnershipColumns + ` FROM partnerships WHERE requester_id = $1 OR addressee_id = $1 ORDER BY created_at, id`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []partnership
+	for rows.Next() {
+		p, err := scanPartnership(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+// loadPartnership returns partnership id if userID is on either side of it.
+func loadPartnership(ctx context.Context, userID, id int64) (partnership, error) {
+	query := `SELECT ` + partnershipColumns + ` FROM partnerships WHERE id = $1 AND (requester_id = $2 OR addressee_id = $2)`
+	return scanPartnership(db.QueryRowContext(ctx, query, id, userID))
+}
+
+// findPartnership returns the partnership between two users, in either
+// direction.
+func findPartnership(ctx context.Context, a, b int64) (partnership, error) {
+	query := `
+		SELECT ` + partnershipColumns + ` FROM partnerships
+		WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)
+	`
+	return scanPartnership(db.QueryRowContext(ctx, query, a, b))
+}
+
+// acceptPartnership accepts a pending invitation addressed to userID.
+func acceptPartnership(ctx context.Context, userID, id int64) (partnership, error) {
+	query := `
+		UPDATE partnerships SET status = 'accepted', accepted_at = $3
+		WHERE id = $1 AND addressee_id = $2 AND status = 'pending'
+		RETURNING ` + partnershipColumns
+	return scanPartnership(db.QueryRowContext(ctx, query, id, userID, time.Now().UTC()))
+}
+
+// deletePartnership removes a partnership userID is part of, with the
+// habit shares between the two users.
+func deletePartnership(ctx context.Context, userID, id int64) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var a, b int64
+	query := `DELETE FROM partnerships WHERE id = $1 AND (requester_id = $2 OR addressee_id = $2) RETURNING requester_id, addressee_id`
+	err = tx.QueryRowContext(ctx, query, id, userID).Scan(&a, &b)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	query = `
+		DELETE FROM habit_shares
+		WHERE (user_id = $1 AND partner_id = $2) OR (user_id = $2 AND partner_id = $1)
+	`
+	if _, err := tx.ExecContext(ctx, query, a, b); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// acceptedPartnerIDs returns the user IDs of userID's accepted partners.
+func acceptedPartnerIDs(ctx context.Context, userID int64) (map[int64]bool, error) {
+	query := `
+		SELECT CASE WHEN requester_id = $1 THEN addressee_id ELSE requester_id END
+		FROM partnerships WHERE (requester_id = $1 OR addressee_id = $1) AND status = 'accepted'
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+func setHabitShares(ctx context.Context, userID, habitID int64, partnerIDs []int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM habit_shares WHERE user_id = $1 AND habit_id = $2 AND NOT (partner_id = ANY($3))`
+	if _, err := tx.ExecContext(ctx, query, userID, habitID, pq.Array(partnerIDs)); err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO habit_shares (user_id, habit_id, partner_id, created_at)
+		SELECT $1, $2, UNNEST($3::bigint[]), $4
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, query, userID, habitID, pq.Array(partnerIDs), time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func loadHabitShares(ctx context.Context, userID, habitID int64) ([]int64, error) {
+	query := `SELECT partner_id FROM habit_shares WHERE user_id = $1 AND habit_id = $2 ORDER BY partner_id`
+	rows, err := db.QueryContext(ctx, query, userID, habitID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// loadSharedHabits returns ownerID's unarchived habits shared with
+// partnerID, in the owner's manual order.
+func loadSharedHabits(ctx context.Context, ownerID, partnerID int64) ([]*Habit, error) {
+	query := `
+		SELECT ` + habitColumns + ` FROM habits h
+		JOIN habit_shares s ON s.user_id = h.user_id AND s.habit_id = h.id
+		WHERE h.user_id = $1 AND s.partner_id = $2 AND h.archived_at IS NULL
+		ORDER BY h.position, h.id
+	`
+	rows, err := db.QueryContext(ctx, query, ownerID, partnerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*Habit{}
+	for rows.Next() {
+		habit, err := scanHabit(rows)
+		if err != nil {
+			return nil, err
+		}
+		// Categories and tags are private to the owner.
+		habit.CategoryID = nil
+		habit.Tags = []string{}
+		list = append(list, habit)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,277 @@
+package habit
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const dateLayout = "2006-01-02"
+
+// TodayStatus says whether a habit is due and done on the caller's today.
+// For break habits Completed means no relapse so far today.
+type TodayStatus struct {
+	Date      string `json:"date"`
+	Scheduled bool   `json:"scheduled"`
+	Completed bool   `json:"completed"`
+}
+
+// StreakStatus is the number of consecutive scheduled periods completed,
+// or for break habits the days since the last relapse. Unit is "days", or
+// "weeks" for weekly habits.
+type StreakStatus struct {
+	Current int    `json:"current"`
+	Unit    string `json:"unit"`
+}
+
+// DayStatus is one day of the trailing week.
+type DayStatus struct {
+	Date      string `json:"date"`
+	Scheduled bool   `json:"scheduled"`
+	Completed bool   `json:"completed"`
+}
+
+// HabitSummary is a habit in GET /habits with the computed fields asked for
+// by ?include=. Without includes it encodes exactly like Habit.
+type HabitSummary struct {
+	*Habit
+	Today  *TodayStatus  `json:"today,omitempty"`
+	Streak *StreakStatus `json:"streak,omitempty"`
+	Week   []DayStatus   `json:"week,omitempty"`
+}
+
+// includes are the computed fields GET /habits can add.
+type includes struct {
+	Today, Streak, Week bool
+}
+
+func (in includes) any() bool {
+	return in.Today || in.Streak || in.Week
+}
+
+// dayKey truncates t to its calendar date in t's location.
+func dayKey(t time.Time) string {
+	return t.Format(dateLayout)
+}
+
+// civilDay returns midnight UTC of the calendar date of t in loc. Day
+// arithmetic on the result is free of DST surprises.
+func civilDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// scheduledOn reports whether a habit with schedule is due on day.
+// Weekly habits are due every day; any completion in the week counts.
+func scheduledOn(schedule string, day time.Time) bool {
+	switch schedule {
+	case ScheduleWeekdays:
+		return day.Weekday() != time.Saturday && day.Weekday() != time.Sunday
+	case ScheduleWeekends:
+		return day.Weekday() == time.Saturday || day.Weekday() == time.Sunday
+	}
+	return true
+}
+
+// weekStart returns the Monday of day's ISO week.
+func weekStart(day time.Time) time.Time {
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+// currentStreak counts consecutive completed periods ending at today. The
+// period containing today is still open: an unfinished today does not break
+// the streak, it just does not count yet. done holds completed dates and off
+// the dates that are not scheduled at all, such as vacation days.
+func currentStreak(schedule string, done, off map[string]bool, today time.Time) int {
+	if schedule == ScheduleWeekly {
+		weeks := make(map[string]bool, len(done))
+		for day := range done {
+			d, _ := time.Parse(dateLayout, day)
+			weeks[dayKey(weekStart(d))] = true
+		}
+		w := weekStart(today)
+		if !weeks[dayKey(w)] {
+			w = w.AddDate(0, 0, -7)
+		}
+		n := 0
+		for ; weeks[dayKey(w)] || weekOff(w, off); w = w.AddDate(0, 0, -7) {
+			if weeks[dayKey(w)] {
+				n++
+			}
+		}
+		return n
+	}
+
+	d := today
+	if !due(schedule, off, d) || !done[dayKey(d)] {
+		d = prevDue(schedule, off, d)
+	}
+	n := 0
+	for ; done[dayKey(d)]; d = prevDue(schedule, off, d) {
+		n++
+	}
+	return n
+}
+
+// longestStreak is the longest run of completed periods between from and
+// to. The period containing to is still open and does not end a run.
+func longestStreak(schedule string, done, off map[string]bool, from, to time.Time) int {
+	best, run := 0, 0
+	if schedule == ScheduleWeekly {
+		for w := weekStart(from); !w.After(to); w = w.AddDate(0, 0, 7) {
+			completed := false
+			for d := w; d.Before(w.AddDate(0, 0, 7)); d = d.AddDate(0, 0, 1) {
+				completed = completed || done[dayKey(d)]
+			}
+			switch {
+			case completed:
+				run++
+				best = max(best, run)
+			case weekOff(w, off), !w.Before(weekStart(to)):
+			default:
+				run = 0
+			}
+		}
+		return best
+	}
+
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		switch {
+		case !due(schedule, off, d):
+		case done[dayKey(d)]:
+			run++
+			best = max(best, run)
+		case d.Before(to):
+			run = 0
+		}
+	}
+	return best
+}
+
+// due reports whether a habit with schedule is due on day, given the days
+// that are off.
+func due(schedule string, off map[string]bool, day time.Time) bool {
+	return scheduledOn(schedule, day) && !off[dayKey(day)]
+}
+
+// prevDue returns the last day before d on which the habit is due.
+func prevDue(schedule string, off map[string]bool, d time.Time) time.Time {
+	for d = d.AddDate(0, 0, -1); !due(schedule, off, d); d = d.AddDate(0, 0, -1) {
+	}
+	return d
+}
+
+// weekOff reports whether every day of the week starting at monday is off.
+func weekOff(monday time.Time, off map[string]bool) bool {
+	if len(off) == 0 {
+		return false
+	}
+	for d := monday; d.Before(monday.AddDate(0, 0, 7)); d = d.AddDate(0, 0, 1) {
+		if !off[dayKey(d)] {
+			return false
+		}
+	}
+	return true
+}
+
+// summarizeHabits computes the requested fields for list with a single
+// query over the habits' track records.
+func summarizeHabits(ctx context.Context, userID int64, list []*Habit, in includes, loc *time.Location, now time.Time) ([]HabitSummary, error) {
+	summaries := make([]HabitSummary, len(list))
+	for i, habit := range list {
+		summaries[i] = HabitSummary{Habit: habit}
+	}
+	if !in.any() || len(list) == 0 {
+		return summaries, nil
+	}
+
+	today := civilDay(now, loc)
+	var since time.Time
+	if !in.Streak {
+		// Today and the trailing week only need the last seven days.
+		since = today.AddDate(0, 0, -6)
+	}
+	done, err := loadDoneDays(ctx, userID, list, loc, since, today)
+	if err != nil {
+		return nil, err
+	}
+	off, err := loadVacationDays(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	streakDone := done
+	if in.Streak {
+		if streakDone, err = frozenDays(ctx, userID, list, done); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range summaries {
+		habit := summaries[i].Habit
+		days := done[habit.ID]
+		if in.Today {
+			summaries[i].Today = &TodayStatus{
+				Date:      dayKey(today),
+				Scheduled: due(habit.Schedule, off, today),
+				Completed: days[dayKey(today)],
+			}
+		}
+		if in.Streak {
+			unit := "days"
+			if habit.Schedule == ScheduleWeekly {
+				unit = "weeks"
+			}
+			streak := currentStreak(habit.Schedule, streakDone[habit.ID], off, today)
+			if habit.Polarity == PolarityBreak {
+				streak = cleanStreak(days, today)
+			}
+			summaries[i].Streak = &StreakStatus{Current: streak, Unit: unit}
+		}
+		if in.Week {
+			week := make([]DayStatus, 0, 7)
+			for d := today.AddDate(0, 0, -6); !d.After(today); d = d.AddDate(0, 0, 1) {
+				week = append(week, DayStatus{
+					Date:      dayKey(d),
+					Scheduled: due(habit.Schedule, off, d),
+					Completed: days[dayKey(d)],
+				})
+			}
+			summaries[i].Week = week
+		}
+	}
+	return summaries, nil
+}
+
+// loadTrackedDays returns, per habit, the set of calendar dates in loc with
+// a track record whose completed flag matches completed, from since onwards
+// (zero for all history). Track records are stored as UTC wall time.
+func loadTrackedDays(ctx context.Context, userID int64, habitIDs []int64, loc *time.Location, since time.Time, completed bool) (map[int64]map[string]bool, error) {
+	query := `
+		SELECT habit_id, (date AT TIME ZONE 'UTC' AT TIME ZONE $3)::date AS day
+		FROM track_records
+		WHERE user_id = $1 AND habit_id = ANY($2) AND completed = $5
+			AND (date AT TIME ZONE 'UTC' AT TIME ZONE $3)::date >= $4::date
+		GROUP BY habit_id, day
+	`
+	rows, err := db.QueryContext(ctx, query, userID, pq.Array(habitIDs), loc.String(), since.Format(dateLayout), completed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int64]map[string]bool)
+	for rows.Next() {
+		var habitID int64
+		var day time.Time
+		if err := rows.Scan(&habitID, &day); err != nil {
+			return nil, err
+		}
+		if done[habitID] == nil {
+			done[habitID] = make(map[string]bool)
+		}
+		done[habitID][dayKey(day)] = true
+	}
+	return done, rows.Err()
+}
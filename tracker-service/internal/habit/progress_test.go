@@ -0,0 +1,76 @@
+package habit
+
+import "testing"
+
+// In October 2026 the 5th and 12th are Mondays; the 10th-11th and 17th-18th
+// are weekends.
+
+func TestCurrentStreak(t *testing.T) {
+	vacationWeek := dayRange("2026-10-05", "2026-10-11")
+	tests := []struct {
+		name     string
+		schedule string
+		today    string
+		done     []string
+		off      []string
+		want     int
+	}{
+		{"daily, today done", ScheduleDaily, "2026-10-15", []string{"2026-10-13", "2026-10-14", "2026-10-15"}, nil, 3},
+		{"daily, today still open", ScheduleDaily, "2026-10-15", []string{"2026-10-13", "2026-10-14"}, nil, 2},
+		{"daily, missed yesterday", ScheduleDaily, "2026-10-15", []string{"2026-10-12", "2026-10-13"}, nil, 0},
+		{"daily, nothing done", ScheduleDaily, "2026-10-15", nil, nil, 0},
+		{"daily, off-day inside the streak", ScheduleDaily, "2026-10-15", []string{"2026-10-12", "2026-10-14"}, []string{"2026-10-13"}, 2},
+		{"daily, today off", ScheduleDaily, "2026-10-15", []string{"2026-10-13", "2026-10-14"}, []string{"2026-10-15"}, 2},
+		{"weekdays, weekend skipped", ScheduleWeekdays, "2026-10-12", []string{"2026-10-08", "2026-10-09"}, nil, 2},
+		{"weekdays, Monday done", ScheduleWeekdays, "2026-10-12", []string{"2026-10-08", "2026-10-09", "2026-10-12"}, nil, 3},
+		{"weekdays, Saturday does not count", ScheduleWeekdays, "2026-10-12", []string{"2026-10-09", "2026-10-10"}, nil, 1},
+		{"weekends, midweek", ScheduleWeekends, "2026-10-15", []string{"2026-10-10", "2026-10-11"}, nil, 2},
+		{"weekends, Sunday still open", ScheduleWeekends, "2026-10-11", []string{"2026-10-03", "2026-10-04", "2026-10-10"}, nil, 3},
+		{"weekends, missed Sunday", ScheduleWeekends, "2026-10-15", []string{"2026-10-10"}, nil, 0},
+		{"weekly, this week done", ScheduleWeekly, "2026-10-15", []string{"2026-10-07", "2026-10-13"}, nil, 2},
+		{"weekly, this week still open", ScheduleWeekly, "2026-10-15", []string{"2026-09-30", "2026-10-07"}, nil, 2},
+		{"weekly, missed last week", ScheduleWeekly, "2026-10-15", []string{"2026-09-30", "2026-10-13"}, nil, 1},
+		{"weekly, vacation week", ScheduleWeekly, "2026-10-15", []string{"2026-09-30", "2026-10-13"}, vacationWeek, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := currentStreak(tt.schedule, daySet(tt.done...), daySet(tt.off...), parseDay(tt.today))
+			if got != tt.want {
+				t.Errorf("currentStreak = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLongestStreak(t *testing.T) {
+	from, to := parseDay("2026-10-01"), parseDay("2026-10-15")
+	vacationWeek := dayRange("2026-10-05", "2026-10-11")
+	tests := []struct {
+		name     string
+		schedule string
+		done     []string
+		off      []string
+		want     int
+	}{
+		{"daily, earlier run is longest", ScheduleDaily, append(dayRange("2026-10-01", "2026-10-04"), "2026-10-06", "2026-10-07"), nil, 4},
+		{"daily, to still open", ScheduleDaily, append(dayRange("2026-10-01", "2026-10-04"), dayRange("2026-10-10", "2026-10-14")...), nil, 5},
+		{"daily, missed day ends a run", ScheduleDaily, dayRange("2026-10-01", "2026-10-06", "2026-10-04"), nil, 3},
+		{"daily, off-day inside a run", ScheduleDaily, dayRange("2026-10-01", "2026-10-06", "2026-10-04"), []string{"2026-10-04"}, 5},
+		{"daily, nothing done", ScheduleDaily, nil, nil, 0},
+		{"weekdays, weekends skipped", ScheduleWeekdays, dayRange("2026-10-01", "2026-10-14", "2026-10-03", "2026-10-04", "2026-10-10", "2026-10-11"), nil, 10},
+		{"weekdays, missed Friday", ScheduleWeekdays, dayRange("2026-10-01", "2026-10-14", "2026-10-09"), nil, 6},
+		{"weekends, missed Sunday", ScheduleWeekends, []string{"2026-10-03", "2026-10-04", "2026-10-10"}, nil, 3},
+		{"weekends, weekdays do not count", ScheduleWeekends, []string{"2026-10-03", "2026-10-05", "2026-10-10"}, nil, 1},
+		{"weekly, current week still open", ScheduleWeekly, []string{"2026-10-01", "2026-10-07"}, nil, 2},
+		{"weekly, missed week", ScheduleWeekly, []string{"2026-10-01", "2026-10-13"}, nil, 1},
+		{"weekly, vacation week", ScheduleWeekly, []string{"2026-10-01", "2026-10-13"}, vacationWeek, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := longestStreak(tt.schedule, daySet(tt.done...), daySet(tt.off...), from, to)
+			if got != tt.want {
+				t.Errorf("longestStreak = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/validate"
+)
+
+const (
+	maxMetadataKeyLength   = 50
+	maxMetadataValueLength = 500
+	defaultSearchResults   = 20
+	maxSearchResults       = 100
+)
+
+// ts_headline marks matches with these private-use characters rather than
+// markup, so the note can be HTML-escaped before they become <b> tags.
+const (
+	matchStart = "\uE000"
+	matchStop  = "\uE001"
+)
+
+// UpdateRecordRequest edits a track record's journal fields after the fact.
+// An empty note, or a mood or energy of 0, clears the field; metadata, when
+// present, replaces the existing map.
+type UpdateRecordRequest struct {
+	Note     *string            `json:"note" validate:"max=2000"`
+	Mood     *int               `json:"mood" validate:"min=0,max=5"`
+	Energy   *int               `json:"energy" validate:"min=0,max=5"`
+	Metadata *map[string]string `json:"metadata" validate:"max=20"`
+}
+
+type RecordListResponse struct {
+	From    string         `json:"from"`
+	To      string         `json:"to"`
+	Records []*TrackRecord `json:"records"`
+}
+
+// SearchResult is a record matching a note search, with the matching part
+// of the note highlighted between <b> and </b>.
+type SearchResult struct {
+	*TrackRecord
+	Snippet string `json:"snippet"`
+}
+
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+}
+
+// checkMetadata bounds metadata keys and values; the number of keys is
+// limited by the validate tag.
+func checkMetadata(metadata map[string]string) error {
+	for key, value := range metadata {
+		if key == "" || len([]rune(key)) > maxMetadataKeyLength {
+			return apierror.Validation(apierror.FieldError{Field: "metadata", Code: "out_of_range", Message: fmt.Sprintf("keys must be between 1 and %d characters", maxMetadataKeyLength)})
+		}
+		if len([]rune(value)) > maxMetadataValueLength {
+			return apierror.Validation(apierror.FieldError{Field: "metadata." + key, Code: "too_long", Message: fmt.Sprintf("must be at most %d characters", maxMetadataValueLength)})
+		}
+	}
+	return nil
+}
+
+// RecordsHandler lists a habit's track records between from and to
+// (inclusive dates in the user's time zone, default the last 30 days).
+func RecordsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	c, err := authenticateCaller(r, authz.ScopeRead)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/habits/"), "/records")
+	habitID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid habit ID"))
+		return
+	}
+
+	loc := c.Location()
+	rng, details := parseSummaryRange(r, civilDay(time.Now(), loc))
+	if len(details) > 0 {
+		apierror.Write(w, r, apierror.Validation(details...))
+		return
+	}
+
+	if _, err := loadHabit(r.Context(), c.UserID, habitID); err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("Habit not found"))
+		return
+	} else if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	records, err := loadRecordsBetween(r.Context(), c.UserID, habitID, rng, loc)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	json.NewEncoder(w).Encode(RecordListResponse{From: dayKey(rng.From), To: dayKey(rng.To), Records: records})
+}
+
+// RecordHandler edits one track record with PATCH
+// /habits/{id}/records/{recordID}.
+func RecordHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeTrack)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodPatch {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	// /habits/{id}/records/{recordID}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/habits/"), "/")
+	if len(parts) != 3 {
+		apierror.Write(w, r, apierror.NotFound("Track record not found"))
+		return
+	}
+	habitID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid habit ID"))
+		return
+	}
+	recordID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid record ID"))
+		return
+	}
+
+	var req UpdateRecordRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if req.Metadata != nil {
+		if err := checkMetadata(*req.Metadata); err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+	}
+
+	record, err := updateRecord(r.Context(), userID, habitID, recordID, req)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("Track record not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(record)
+}
+
+// SearchRecordsHandler runs a full-text search over the caller's notes.
+func SearchRecordsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeRead)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	q := r.URL.Query()
+	text := strings.TrimSpace(q.Get("q"))
+	var details []apierror.FieldError
+	if text == "" || len(text) > 200 {
+		details = append(details, apierror.FieldError{Field: "q", Code: "out_of_range", Message: "q must be between 1 and 200 characters"})
+	}
+	limit := defaultSearchResults
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchResults {
+			details = append(details, apierror.FieldError{Field: "limit", Code: "out_of_range", Message: fmt.Sprintf("limit must be between 1 and %d", maxSearchResults)})
+		}
+		limit = n
+	}
+	if len(details) > 0 {
+		apierror.Write(w, r, apierror.Validation(details...))
+		return
+	}
+
+	results, err := searchRecords(r.Context(), userID, text, limit)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	json.NewEncoder(w).Encode(SearchResponse{Query: text, Results: results})
+}
+
+// recordColumns is the column list read by scanRecord.
+const recordColumns = `id, habit_id, user_id, completed, date, note, mood, energy, metadata, updated_at`
+
+func scanRecord(row rowScanner, extra ...any) (*TrackRecord, error) {
+	var record TrackRecord
+	var note sql.NullString
+	var mood, energy sql.NullInt64
+	var metadata []byte
+	var updatedAt sql.NullTime
+	dest := append([]any{&record.ID, &record.HabitID, &record.UserID, &record.Completed, &record.Date,
+		&note, &mood, &energy, &metadata, &updatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if note.Valid {
+		record.Note = &note.String
+	}
+	if mood.Valid {
+		m := int(mood.Int64)
+		record.Mood = &m
+	}
+	if energy.Valid {
+		e := int(energy.Int64)
+		record.Energy = &e
+	}
+	if updatedAt.Valid {
+		record.UpdatedAt = &updatedAt.Time
+	}
+	record.Metadata = map[string]string{}
+	if err := json.Unmarshal(metadata, &record.Metadata); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func queryRecords(ctx context.Context, query string, args ...any) ([]*TrackRecord, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*TrackRecord{}
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// loadRecordsBetween returns a habit's records on the calendar days of rng
+// in loc, oldest first.
+func loadRecordsBetween(ctx context.Context, userID, habitID int64, rng summaryRange, loc *time.Location) ([]*TrackRecord, error) {
+	query := `
+		SELECT ` + recordColumns + ` FROM track_records
+		WHERE user_id = $1 AND habit_id = $2
+			AND (date AT TIME ZONE 'UTC' AT TIME ZONE $3)::date BETWEEN $4::date AND $5::date
+		ORDER BY date, id
+	`
+	return queryRecords(ctx, query, userID, habitID, loc.String(), dayKey(rng.From), dayKey(rng.To))
+}
+
+// updateRecord applies req and returns the updated record, or sql.ErrNoRows
+// if the record is not one of userID's records for habitID.
+func updateRecord(ctx context.Context, userID, habitID, recordID int64, req UpdateRecordRequest) (*TrackRecord, error) {
+	var metadata *string
+	if req.Metadata != nil {
+		b, err := json.Marshal(*req.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		s := string(b)
+		metadata = &s
+	}
+	query := `
+		UPDATE track_records SET
+			note = CASE WHEN $4::text IS NULL THEN note ELSE NULLIF($4, '') END,
+			mood = CASE WHEN $5::smallint IS NULL THEN mood ELSE NULLIF($5, 0) END,
+			energy = CASE WHEN $6::smallint IS NULL THEN energy ELSE NULLIF($6, 0) END,
+			metadata = COALESCE($7::jsonb, metadata),
+			updated_at = $8
+		WHERE id = $1 AND user_id = $2 AND habit_id = $3
+		RETURNING ` + recordColumns
+	row := db.QueryRowContext(ctx, query, recordID, userID, habitID, req.Note, req.Mood, req.Energy, metadata, time.Now().UTC())
+	return scanRecord(row)
+}
+
+// searchRecords finds records whose note matches text, best match first.
+func searchRecords(ctx context.Context, userID int64, text string, limit int) ([]SearchResult, error) {
+	// The markers are removed from the note first so a note cannot forge
+	// its own highlighting.
+	query := `
+		SELECT ` + recordColumns + `,
+			ts_headline('simple', translate(note, $4, ''), q, $5)
+		FROM track_records, plainto_tsquery('simple', $2) q
+		WHERE user_id = $1 AND to_tsvector('simple', COALESCE(note, '')) @@ q
+		ORDER BY ts_rank(to_tsvector('simple', COALESCE(note, '')), q) DESC, date DESC
+		LIMIT $3
+	`
+	options := fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxFragments=1, MaxWords=20, MinWords=5`, matchStart, matchStop)
+	rows, err := db.QueryContext(ctx, query, userID, text, limit, matchStart+matchStop, options)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		if result.TrackRecord, err = scanRecord(rows, &result.Snippet); err != nil {
+			return nil, err
+		}
+		result.Snippet = highlight(result.Snippet)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// highlight HTML-escapes a ts_headline snippet and turns its match markers
+// into <b> tags.
+func highlight(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, matchStart, "<b>")
+	return strings.ReplaceAll(snippet, matchStop, "</b>")
+}
@@ -0,0 +1,114 @@
+package habit
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+)
+
+var errBreakSchedule = apierror.Validation(apierror.FieldError{Field: "schedule", Code: "invalid_choice", Message: "break habits must use the daily schedule"})
+
+// AbstinenceStats describes a break habit between its creation and today.
+// A clean day is one without a relapse.
+type AbstinenceStats struct {
+	Relapses         int     `json:"relapses"`
+	LastRelapse      *string `json:"last_relapse"`
+	DaysSinceRelapse int     `json:"days_since_relapse"`
+	Days             int     `json:"days"`
+	CleanDays        int     `json:"clean_days"`
+	AbstinenceRate   float64 `json:"abstinence_rate"`
+	LongestCleanRun  int     `json:"longest_clean_run"`
+}
+
+func trackMessage(habit *Habit) string {
+	if habit.Polarity == PolarityBreak {
+		return "Relapse recorded"
+	}
+	return "Habit tracked successfully"
+}
+
+// abstinence computes the stats of the days from through to, given the
+// dates with a relapse. The current run counts today, so a habit with no
+// relapse since yesterday has been clean for one day.
+func abstinence(relapses map[string]bool, from, to time.Time) AbstinenceStats {
+	var stats AbstinenceStats
+	run := 0
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		stats.Days++
+		if relapses[dayKey(d)] {
+			last := dayKey(d)
+			stats.LastRelapse = &last
+			run = 0
+			continue
+		}
+		stats.CleanDays++
+		run++
+		if run > stats.LongestCleanRun {
+			stats.LongestCleanRun = run
+		}
+	}
+	stats.DaysSinceRelapse = run
+	if stats.Days > 0 {
+		stats.AbstinenceRate = math.Round(float64(stats.CleanDays)/float64(stats.Days)*1000) / 1000
+	}
+	return stats
+}
+
+// cleanStreak counts the clean days ending today. Unlike currentStreak, a
+// relapse today ends the streak immediately.
+func cleanStreak(clean map[string]bool, today time.Time) int {
+	n := 0
+	for d := today; clean[dayKey(d)]; d = d.AddDate(0, 0, -1) {
+		n++
+	}
+	return n
+}
+
+// loadDoneDays returns, per habit, the dates from since through until that
+// count as done: completed days for build habits and clean days (active
+// days without a relapse) for break habits.
+func loadDoneDays(ctx context.Context, userID int64, list []*Habit, loc *time.Location, since, until time.Time) (map[int64]map[string]bool, error) {
+	var buildIDs, breakIDs []int64
+	for _, habit := range list {
+		if habit.Polarity == PolarityBreak {
+			breakIDs = append(breakIDs, habit.ID)
+		} else {
+			buildIDs = append(buildIDs, habit.ID)
+		}
+	}
+
+	done := make(map[int64]map[string]bool)
+	if len(buildIDs) > 0 {
+		var err error
+		if done, err = loadTrackedDays(ctx, userID, buildIDs, loc, since, true); err != nil {
+			return nil, err
+		}
+	}
+	if len(breakIDs) == 0 {
+		return done, nil
+	}
+
+	relapses, err := loadTrackedDays(ctx, userID, breakIDs, loc, since, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, habit := range list {
+		if habit.Polarity != PolarityBreak {
+			continue
+		}
+		from, to, ok := activeRange(habit, summaryRange{From: since, To: until}, loc)
+		if !ok {
+			continue
+		}
+		clean := make(map[string]bool)
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			if !relapses[habit.ID][dayKey(d)] {
+				clean[dayKey(d)] = true
+			}
+		}
+		done[habit.ID] = clean
+	}
+	return done, nil
+}
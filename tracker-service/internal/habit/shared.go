@@ -0,0 +1,558 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/validate"
+)
+
+// Shared habit membership statuses.
+const (
+	MemberInvited = "invited"
+	MemberJoined  = "joined"
+)
+
+// SharedHabitRequest creates a shared habit and invites accepted partners
+// to it. The creator joins right away; with the partners that makes at most
+// 20 members.
+type SharedHabitRequest struct {
+	Name        string  `json:"name" validate:"required,max=255"`
+	Description string  `json:"description" validate:"max=2000"`
+	Schedule    string  `json:"schedule" validate:"oneof=daily weekdays weekends weekly"`
+	PartnerIDs  []int64 `json:"partner_ids" validate:"max=19"`
+}
+
+// SharedHabit is a habit a group works on together. Every member who joins
+// gets a habit of their own, HabitID, and tracks it as usual. Status and
+// HabitID are the caller's.
+type SharedHabit struct {
+	ID          int64     `json:"id"`
+	OwnerID     int64     `json:"owner_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Schedule    string    `json:"schedule"`
+	CreatedAt   time.Time `json:"created_at"`
+	Status      string    `json:"status"`
+	HabitID     *int64    `json:"habit_id"`
+	Members     int       `json:"members"`
+}
+
+type SharedHabitListResponse struct {
+	SharedHabits []SharedHabit `json:"shared_habits"`
+}
+
+// SharedMember is one member's progress on a shared habit.
+type SharedMember struct {
+	UserID         int64         `json:"user_id"`
+	Username       string        `json:"username"`
+	DisplayName    string        `json:"display_name"`
+	Status         string        `json:"status"`
+	JoinedAt       *time.Time    `json:"joined_at"`
+	CompletedToday bool          `json:"completed_today"`
+	Streak         *StreakStatus `json:"streak,omitempty"`
+}
+
+// GroupDay is how many of the members who had joined by Date completed the
+// habit that day.
+type GroupDay struct {
+	Date      string `json:"date"`
+	Completed int    `json:"completed"`
+	Members   int    `json:"members"`
+}
+
+// SharedHabitProgress is the combined progress of a shared habit's members
+// in the caller's time zone. The group streak counts the periods in which
+// every member completed the habit.
+type SharedHabitProgress struct {
+	SharedHabit
+	Date           string         `json:"date"`
+	CompletedToday int            `json:"completed_today"`
+	GroupStreak    StreakStatus   `json:"group_streak"`
+	Week           []GroupDay     `json:"week"`
+	MemberProgress []SharedMember `json:"member_progress"`
+}
+
+// sharedMember is a row of shared_habit_members.
+type sharedMember struct {
+	UserID   int64
+	HabitID  *int64
+	JoinedAt *time.Time
+}
+
+// SharedHabitsHandler lists the shared habits the caller belongs to or is
+// invited to (GET) and creates one (POST).
+func SharedHabitsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	userID, err := authenticate(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := listSharedHabits(r.Context(), userID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		json.NewEncoder(w).Encode(SharedHabitListResponse{SharedHabits: list})
+	case http.MethodPost:
+		createSharedHabit(w, r, userID)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+func createSharedHabit(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req SharedHabitRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if req.Schedule == "" {
+		req.Schedule = ScheduleDaily
+	}
+	ids := uniqueIDs(req.PartnerIDs)
+	partners, err := acceptedPartnerIDs(r.Context(), userID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	for _, id := range ids {
+		if !partners[id] {
+			apierror.Write(w, r, apierror.Validation(apierror.FieldError{Field: "partner_ids", Code: "not_found", Message: "every ID must be an accepted partner's user ID"}))
+			return
+		}
+	}
+
+	shared := SharedHabit{
+		OwnerID:     userID,
+		Name:        req.Name,
+		Description: req.Description,
+		Schedule:    req.Schedule,
+		CreatedAt:   time.Now().UTC(),
+		Status:      MemberJoined,
+		Members:     1,
+	}
+	_, err = createLinkedHabit(r.Context(), userID, shared.Name, shared.Description, shared.Schedule, func(q querier, habitID int64) error {
+		shared.HabitID = &habitID
+		return saveSharedHabit(r.Context(), q, &shared, ids)
+	})
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(shared)
+}
+
+// SharedHabitHandler returns a shared habit with its members' combined
+// progress (GET). DELETE deletes the shared habit when the caller created
+// it and otherwise leaves it or declines the invitation. Members keep their
+// own habits and history either way.
+func SharedHabitHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	c, err := authenticateCaller(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/shared-habits/"), 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid shared habit ID"))
+		return
+	}
+
+	shared, err := loadSharedHabit(r.Context(), c.UserID, id)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("Shared habit not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		progress, err := loadSharedProgress(r.Context(), shared, c.Location(), time.Now())
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(progress)
+	case http.MethodDelete:
+		if err := leaveSharedHabit(r.Context(), c.UserID, shared); err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+// JoinSharedHabitHandler accepts an invitation with POST
+// /shared-habits/{id}/join, creating the caller's own habit for it.
+func JoinSharedHabitHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/shared-habits/"), "/join")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid shared habit ID"))
+		return
+	}
+
+	shared, err := loadSharedHabit(r.Context(), userID, id)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("Shared habit not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if shared.Status == MemberJoined {
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "You have already joined this shared habit"))
+		return
+	}
+
+	habit, err := createLinkedHabit(r.Context(), userID, shared.Name, shared.Description, shared.Schedule, func(q querier, habitID int64) error {
+		query := `
+			UPDATE shared_habit_members SET habit_id = $3, joined_at = $4
+			WHERE shared_habit_id = $1 AND user_id = $2 AND joined_at IS NULL
+		`
+		result, err := q.ExecContext(r.Context(), query, id, userID, habitID, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// A concurrent join got there first; keep only its habit.
+			return apierror.Conflict(apierror.CodeConflict, "You have already joined this shared habit")
+		}
+		return nil
+	})
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	shared.Status, shared.HabitID = MemberJoined, &habit.ID
+	shared.Members++
+	json.NewEncoder(w).Encode(shared)
+}
+
+// createLinkedHabit creates a plain habit in userID's list for a shared
+// habit or a group challenge to follow. link records the membership that
+// refers to it in the same transaction, so a failed join leaves no habit.
+func createLinkedHabit(ctx context.Context, userID int64, name, description, schedule string, link func(q querier, habitID int64) error) (*Habit, error) {
+	habit := &Habit{
+		UserID:      userID,
+		Name:        name,
+		Description: description,
+		CreatedAt:   time.Now(),
+		Schedule:    schedule,
+		Tags:        []string{},
+		Polarity:    PolarityBuild,
+	}
+	if err := saveHabitWith(ctx, habit, func(q querier) error { return link(q, habit.ID) }); err != nil {
+		return nil, err
+	}
+	habitsCreated.Inc()
+	return habit, nil
+}
+
+// loadSharedProgress loads every member's completions since the shared
+// habit was created in one query and combines them.
+func loadSharedProgress(ctx context.Context, shared SharedHabit, loc *time.Location, now time.Time) (SharedHabitProgress, error) {
+	members, err := loadSharedMembers(ctx, shared.ID)
+	if err != nil {
+		return SharedHabitProgress{}, apierror.Internal(err)
+	}
+	ids := make([]int64, len(members))
+	for i, m := range members {
+		ids[i] = m.UserID
+	}
+	users, err := lookupUsers(ctx, nil, ids)
+	if err != nil {
+		return SharedHabitProgress{}, err
+	}
+
+	query := `
+		SELECT m.user_id, (t.date AT TIME ZONE 'UTC' AT TIME ZONE $2)::date AS day
+		FROM shared_habit_members m
+		JOIN track_records t ON t.user_id = m.user_id AND t.habit_id = m.habit_id
+		WHERE m.shared_habit_id = $1 AND t.completed AND t.date >= $3
+		GROUP BY m.user_id, day
+	`
+	rows, err := db.QueryContext(ctx, query, shared.ID, loc.String(), shared.CreatedAt.AddDate(0, 0, -1))
+	if err != nil {
+		return SharedHabitProgress{}, apierror.Internal(err)
+	}
+	defer rows.Close()
+
+	done := make(map[int64]map[string]bool)
+	for rows.Next() {
+		var userID int64
+		var day time.Time
+		if err := rows.Scan(&userID, &day); err != nil {
+			return SharedHabitProgress{}, apierror.Internal(err)
+		}
+		if done[userID] == nil {
+			done[userID] = make(map[string]bool)
+		}
+		done[userID][dayKey(day)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return SharedHabitProgress{}, apierror.Internal(err)
+	}
+
+	return sharedProgress(shared, members, users, done, loc, civilDay(now, loc)), nil
+}
+
+// sharedProgress combines the members' done days. A day (for weekly habits,
+// a week) counts for the group when every member who had joined by then
+// completed it. Members whose account no longer exists are left out. It
+// does no I/O.
+func sharedProgress(shared SharedHabit, members []sharedMember, users map[int64]userInfo, done map[int64]map[string]bool, loc *time.Location, today time.Time) SharedHabitProgress {
+	unit := "days"
+	if shared.Schedule == ScheduleWeekly {
+		unit = "weeks"
+	}
+	progress := SharedHabitProgress{
+		SharedHabit:    shared,
+		Date:           dayKey(today),
+		GroupStreak:    StreakStatus{Unit: unit},
+		Week:           make([]GroupDay, 0, 7),
+		MemberProgress: []SharedMember{},
+	}
+
+	var joined []sharedMember
+	for _, m := range members {
+		info, ok := users[m.UserID]
+		if !ok {
+			continue
+		}
+		member := SharedMember{
+			UserID:      m.UserID,
+			Username:    info.Username,
+			DisplayName: info.DisplayName,
+			Status:      MemberInvited,
+			JoinedAt:    m.JoinedAt,
+		}
+		if m.JoinedAt != nil {
+			member.Status = MemberJoined
+			member.CompletedToday = done[m.UserID][dayKey(today)]
+			member.Streak = &StreakStatus{Current: currentStreak(shared.Schedule, done[m.UserID], nil, today), Unit: unit}
+			if member.CompletedToday {
+				progress.CompletedToday++
+			}
+			joined = append(joined, m)
+		}
+		progress.MemberProgress = append(progress.MemberProgress, member)
+	}
+
+	// active reports the members who had joined by day.
+	active := func(day time.Time) []sharedMember {
+		var list []sharedMember
+		for _, m := range joined {
+			if !civilDay(*m.JoinedAt, loc).After(day) {
+				list = append(list, m)
+			}
+		}
+		return list
+	}
+
+	groupDone := make(map[string]bool)
+	start := civilDay(shared.CreatedAt, loc)
+	if shared.Schedule == ScheduleWeekly {
+		for w := weekStart(start); !w.After(today); w = w.AddDate(0, 0, 7) {
+			list := active(w.AddDate(0, 0, 6))
+			all := len(list) > 0
+			for _, m := range list {
+				completed := false
+				for d := w; d.Before(w.AddDate(0, 0, 7)); d = d.AddDate(0, 0, 1) {
+					completed = completed || done[m.UserID][dayKey(d)]
+				}
+				all = all && completed
+			}
+			groupDone[dayKey(w)] = all
+		}
+	} else {
+		for d := start; !d.After(today); d = d.AddDate(0, 0, 1) {
+			list := active(d)
+			all := len(list) > 0
+			for _, m := range list {
+				all = all && done[m.UserID][dayKey(d)]
+			}
+			groupDone[dayKey(d)] = all
+		}
+	}
+	progress.GroupStreak.Current = currentStreak(shared.Schedule, groupDone, nil, today)
+
+	for d := today.AddDate(0, 0, -6); !d.After(today); d = d.AddDate(0, 0, 1) {
+		day := GroupDay{Date: dayKey(d)}
+		for _, m := range active(d) {
+			day.Members++
+			if done[m.UserID][dayKey(d)] {
+				day.Completed++
+			}
+		}
+		progress.Week = append(progress.Week, day)
+	}
+	return progress
+}
+
+const sharedHabitColumns = `s.id, s.owner_id, s.name, s.description, s.schedule, s.created_at, m.habit_id, m.joined_at,
+	(SELECT COUNT(*) FROM shared_habit_members j WHERE j.shared_habit_id = s.id AND j.joined_at IS NOT NULL)`
+
+func scanSharedHabit(row rowScanner) (SharedHabit, error) {
+	var s SharedHabit
+	var habitID sql.NullInt64
+	var joinedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.OwnerID, &s.Name, &s.Description, &s.Schedule, &s.CreatedAt, &habitID, &joinedAt, &s.Members)
+	s.Status = MemberInvited
+	if joinedAt.Valid {
+		s.Status = MemberJoined
+	}
+	if habitID.Valid {
+		s.HabitID = &habitID.Int64
+	}
+	return s, err
+}
+
+func listSharedHabits(ctx context.Context, userID int64) ([]SharedHabit, error) {
+	query := `
+		SELECT ` + sharedHabitColumns + `
+		FROM shared_habits s JOIN shared_habit_members m ON m.shared_habit_id = s.id AND m.user_id = $1
+		ORDER BY s.created_at, s.id
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []SharedHabit{}
+	for rows.Next() {
+		s, err := scanSharedHabit(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// loadSharedHabit returns shared habit id as seen by userID, or
+// sql.ErrNoRows if userID is neither a member nor invited.
+func loadSharedHabit(ctx context.Context, userID, id int64) (SharedHabit, error) {
+	query := `
+		SELECT ` + sharedHabitColumns + `
+		FROM shared_habits s JOIN shared_habit_members m ON m.shared_habit_id = s.id AND m.user_id = $1
+		WHERE s.id = $2
+	`
+	return scanSharedHabit(db.QueryRowContext(ctx, query, userID, id))
+}
+
+func loadSharedMembers(ctx context.Context, id int64) ([]sharedMember, error) {
+	query := `
+		SELECT user_id, habit_id, joined_at FROM shared_habit_members
+		WHERE shared_habit_id = $1 ORDER BY joined_at NULLS LAST, user_id
+	`
+	rows, err := db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []sharedMember
+	for rows.Next() {
+		var m sharedMember
+		var habitID sql.NullInt64
+		var joinedAt sql.NullTime
+		if err := rows.Scan(&m.UserID, &habitID, &joinedAt); err != nil {
+			return nil, err
+		}
+		if habitID.Valid {
+			m.HabitID = &habitID.Int64
+		}
+		if joinedAt.Valid {
+			m.JoinedAt = &joinedAt.Time
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// saveSharedHabit inserts shared with its creator as the first member and
+// invites partnerIDs. It runs in the transaction that creates the creator's
+// habit.
+func saveSharedHabit(ctx context.Context, q querier, shared *SharedHabit, partnerIDs []int64) error {
+	query := `
+		INSERT INTO shared_habits (owner_id, name, description, schedule, created_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id
+	`
+	err := q.QueryRowContext(ctx, query, shared.OwnerID, shared.Name, shared.Description, shared.Schedule, shared.CreatedAt).Scan(&shared.ID)
+	if err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO shared_habit_members (shared_habit_id, user_id, habit_id, invited_at, joined_at)
+		VALUES ($1, $2, $3, $4, $4)
+	`
+	if _, err := q.ExecContext(ctx, query, shared.ID, shared.OwnerID, shared.HabitID, shared.CreatedAt); err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO shared_habit_members (shared_habit_id, user_id, invited_at)
+		SELECT $1, UNNEST($2::bigint[]), $3
+	`
+	_, err = q.ExecContext(ctx, query, shared.ID, pq.Array(partnerIDs), shared.CreatedAt)
+	return err
+}
+
+// leaveSharedHabit deletes shared if userID created it and otherwise
+// removes userID from it.
+func leaveSharedHabit(ctx context.Context, userID int64, shared SharedHabit) error {
+	if shared.OwnerID == userID {
+		_, err := db.ExecContext(ctx, `DELETE FROM shared_habits WHERE id = $1 AND owner_id = $2`, shared.ID, userID)
+		return err
+	}
+	_, err := db.ExecContext(ctx, `DELETE FROM shared_habit_members WHERE shared_habit_id = $1 AND user_id = $2`, shared.ID, userID)
+	return err
+}
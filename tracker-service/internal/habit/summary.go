@@ -0,0 +1,278 @@
+package habit
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+)
+
+const (
+	defaultSummaryDays = 30
+	maxSummaryDays     = 366
+)
+
+// CompletionSummary aggregates how many scheduled periods (days, or weeks
+// for weekly habits) a set of habits had in a range and how many were
+// completed.
+type CompletionSummary struct {
+	Habits         int     `json:"habits"`
+	Scheduled      int     `json:"scheduled"`
+	Completed      int     `json:"completed"`
+	CompletionRate float64 `json:"completion_rate"`
+}
+
+func (s *CompletionSummary) add(scheduled, completed int) {
+	s.Habits++
+	s.Scheduled += scheduled
+	s.Completed += completed
+	if s.Scheduled > 0 {
+		s.CompletionRate = math.Round(float64(s.Completed)/float64(s.Scheduled)*1000) / 1000
+	}
+}
+
+type StatsSummaryResponse struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Tag        string `json:"tag,omitempty"`
+	CategoryID int64  `json:"category_id,omitempty"`
+	CompletionSummary
+}
+
+// CategorySummary is the completion summary of one category. ID is null
+// for uncategorized habits.
+type CategorySummary struct {
+	ID    *int64 `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	CompletionSummary
+}
+
+type CategorySummaryResponse struct {
+	From       string            `json:"from"`
+	To         string            `json:"to"`
+	Tag        string            `json:"tag,omitempty"`
+	Categories []CategorySummary `json:"categories"`
+}
+
+// summaryRange is a parsed from/to query, as calendar days.
+type summaryRange struct {
+	From, To time.Time
+}
+
+// parseSummaryRange reads from and to (YYYY-MM-DD, inclusive). The default
+// is the last 30 days ending today.
+func parseSummaryRange(r *http.Request, today time.Time) (summaryRange, []apierror.FieldError) {
+	q := r.URL.Query()
+	rng := summaryRange{From: today.AddDate(0, 0, -(defaultSummaryDays - 1)), To: today}
+	var details []apierror.FieldError
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &rng.From}, {"to", &rng.To}} {
+		if v := q.Get(p.name); v != "" {
+			d, err := time.Parse(dateLayout, v)
+			if err != nil {
+				details = append(details, apierror.FieldError{Field: p.name, Code: "invalid_date", Message: p.name + " must be a date in YYYY-MM-DD format"})
+				continue
+			}
+			*p.dst = d
+		}
+	}
+	if len(details) == 0 {
+		if rng.To.Before(rng.From) {
+			details = append(details, apierror.FieldError{Field: "to", Code: "out_of_range", Message: "to must not be before from"})
+		} else if rng.To.Sub(rng.From) >= maxSummaryDays*24*time.Hour {
+			details = append(details, apierror.FieldError{Field: "to", Code: "out_of_range", Message: "range must not exceed " + strconv.Itoa(maxSummaryDays) + " days"})
+		}
+	}
+	return rng, details
+}
+
+// SummaryHandler answers GET /stats with the completion summary of all of
+// the caller's habits, optionally narrowed by tag or category.
+func SummaryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	c, err := authenticateCaller(r, authz.ScopeRead)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	loc := c.Location()
+	hq, rng, ok := parseSummaryQuery(w, r, loc)
+	if !ok {
+		return
+	}
+	list, done, err := loadSummaryData(r.Context(), c.UserID, hq, rng, loc)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	response := StatsSummaryResponse{
+		From:       dayKey(rng.From),
+		To:         dayKey(rng.To),
+		Tag:        hq.Tag,
+		CategoryID: hq.Category,
+	}
+	for _, habit := range list {
+		response.add(periodProgress(habit, done[habit.ID], rng, loc))
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// CategoryStatsHandler answers GET /stats/categories with a completion
+// summary per category, including one for uncategorized habits.
+func CategoryStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	c, err := authenticateCaller(r, authz.ScopeRead)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	loc := c.Location()
+	hq, rng, ok := parseSummaryQuery(w, r, loc)
+	if !ok {
+		return
+	}
+	categories, err := listCategories(r.Context(), c.UserID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	list, done, err := loadSummaryData(r.Context(), c.UserID, hq, rng, loc)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	summaries := make([]CategorySummary, 0, len(categories)+1)
+	index := make(map[int64]int, len(categories))
+	for _, category := range categories {
+		id := category.ID
+		index[id] = len(summaries)
+		summaries = append(summaries, CategorySummary{ID: &id, Name: category.Name, Color: category.Color})
+	}
+	uncategorized := CategorySummary{Name: "Uncategorized", Color: defaultCategoryColor}
+	for _, habit := range list {
+		scheduled, completed := periodProgress(habit, done[habit.ID], rng, loc)
+		i, ok := 0, false
+		if habit.CategoryID != nil {
+			i, ok = index[*habit.CategoryID]
+		}
+		if !ok {
+			uncategorized.add(scheduled, completed)
+			continue
+		}
+		summaries[i].add(scheduled, completed)
+	}
+	if uncategorized.Habits > 0 {
+		summaries = append(summaries, uncategorized)
+	}
+
+	json.NewEncoder(w).Encode(CategorySummaryResponse{
+		From:       dayKey(rng.From),
+		To:         dayKey(rng.To),
+		Tag:        hq.Tag,
+		Categories: summaries,
+	})
+}
+
+// parseSummaryQuery reads the range and the tag/category filters shared by
+// the summary endpoints, writing a validation error if they are invalid.
+func parseSummaryQuery(w http.ResponseWriter, r *http.Request, loc *time.Location) (habitQuery, summaryRange, bool) {
+	rng, details := parseSummaryRange(r, civilDay(time.Now(), loc))
+	hq := habitQuery{Sort: "created", Archived: "all", Tag: r.URL.Query().Get("tag")}
+	if v := r.URL.Query().Get("category"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			details = append(details, apierror.FieldError{Field: "category", Code: "invalid_type", Message: "category must be a category ID"})
+		}
+		hq.Category = n
+	}
+	if len(details) > 0 {
+		apierror.Write(w, r, apierror.Validation(details...))
+		return habitQuery{}, summaryRange{}, false
+	}
+	return hq, rng, true
+}
+
+// loadSummaryData loads the habits matching hq and their completed days in
+// the range, in two queries regardless of the number of habits.
+func loadSummaryData(ctx context.Context, userID int64, hq habitQuery, rng summaryRange, loc *time.Location) ([]*Habit, map[int64]map[string]bool, error) {
+	list, _, err := queryHabits(ctx, userID, hq)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Weekly habits look back to the Monday of the first week.
+	done, err := loadDoneDays(ctx, userID, list, loc, weekStart(rng.From), rng.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	return list, done, nil
+}
+
+// periodProgress counts a habit's scheduled and completed periods within
+// rng, limited to the days between its creation and its archiving.
+func periodProgress(habit *Habit, done map[string]bool, rng summaryRange, loc *time.Location) (scheduled, completed int) {
+	from, to, ok := activeRange(habit, rng, loc)
+	if !ok {
+		return 0, 0
+	}
+
+	if habit.Schedule == ScheduleWeekly {
+		for week := weekStart(from); !week.After(to); week = week.AddDate(0, 0, 7) {
+			scheduled++
+			for d := week; d.Before(week.AddDate(0, 0, 7)); d = d.AddDate(0, 0, 1) {
+				if done[dayKey(d)] {
+					completed++
+					break
+				}
+			}
+		}
+		return scheduled, completed
+	}
+
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if !scheduledOn(habit.Schedule, d) {
+			continue
+		}
+		scheduled++
+		if done[dayKey(d)] {
+			completed++
+		}
+	}
+	return scheduled, completed
+}
+
+// activeRange narrows rng to the days between habit's creation and its
+// archiving. ok is false if the habit was not active at all in rng.
+func activeRange(habit *Habit, rng summaryRange, loc *time.Location) (from, to time.Time, ok bool) {
+	from, to = rng.From, rng.To
+	if created := civilDay(habit.CreatedAt, loc); created.After(from) {
+		from = created
+	}
+	if habit.ArchivedAt != nil {
+		if archived := civilDay(*habit.ArchivedAt, loc); archived.Before(to) {
+			to = archived
+		}
+	}
+	return from, to, !to.Before(from)
+}
@@ -0,0 +1,287 @@
+package habit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/validate"
+)
+
+const (
+	maxTagLength    = 50
+	maxTagsPerHabit = 20
+)
+
+type Tag struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	HabitCount int       `json:"habit_count"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type TagRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type TagListResponse struct {
+	Tags []Tag `json:"tags"`
+}
+
+// TagsHandler lists (GET) and creates (POST) the caller's tags.
+func TagsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := authz.ScopeFull
+	if r.Method == http.MethodGet {
+		scope = authz.ScopeRead
+	}
+	userID, err := authenticate(r, scope)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		tags, err := listTags(r.Context(), userID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		json.NewEncoder(w).Encode(TagListResponse{Tags: tags})
+	case http.MethodPost:
+		var req TagRequest
+		if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		name, err := tagName("name", req.Name)
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		tag := Tag{Name: name}
+		if err := createTag(r.Context(), userID, &tag); err != nil {
+			writeTagError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(tag)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+// TagHandler renames (PATCH) or deletes (DELETE) a tag. Deleting a tag
+// removes it from every habit.
+func TagHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := authenticate(r, authz.ScopeFull)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	tagID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/tags/"), 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid tag ID"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		var req TagRequest
+		if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		name, err := tagName("name", req.Name)
+		if err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+		tag, err := renameTag(r.Context(), userID, tagID, name)
+		if err != nil {
+			writeTagError(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(tag)
+	case http.MethodDelete:
+		deleted, err := deleteTag(r.Context(), userID, tagID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		if !deleted {
+			apierror.Write(w, r, apierror.NotFound("Tag not found"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+	}
+}
+
+func writeTagError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == sql.ErrNoRows:
+		apierror.Write(w, r, apierror.NotFound("Tag not found"))
+	case isUniqueViolation(err):
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "A tag with this name already exists"))
+	default:
+		apierror.Write(w, r, apierror.Internal(err))
+	}
+}
+
+// tagName trims a tag name, including invisible format characters such as
+// zero-width spaces, and checks its length afterwards so a blank name is
+// reported against field instead of being stored.
+func tagName(field, name string) (string, error) {
+	name = strings.TrimFunc(name, func(c rune) bool {
+		return unicode.IsSpace(c) || unicode.Is(unicode.Cf, c)
+	})
+	if name == "" || len([]rune(name)) > maxTagLength {
+		return "", apierror.Validation(apierror.FieldError{
+			Field:   field,
+			Code:    "out_of_range",
+			Message: fmt.Sprintf("must be between 1 and %d characters", maxTagLength),
+		})
+	}
+	return name, nil
+}
+
+// normalizeTags trims tag names and drops case-insensitive duplicates,
+// keeping the first spelling. It reports invalid names as field errors.
+func normalizeTags(names []string) ([]string, error) {
+	tags := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		name, err := tagName(fmt.Sprintf("tags[%d]", i), name)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		tags = append(tags, name)
+	}
+	return tags, nil
+}
+
+// setHabitTags replaces a habit's tags, creating tags the user has not used
+// before.
+func setHabitTags(ctx context.Context, q querier, userID, habitID int64, names []string) error {
+	if _, err := q.ExecContext(ctx, `DELETE FROM habit_tags WHERE user_id = $1 AND habit_id = $2`, userID, habitID); err != nil {
+		return err
+	}
+	for _, name := range names {
+		var tagID int64
+		query := `
+			INSERT INTO tags (user_id, name) VALUES ($1, $2)
+			ON CONFLICT (user_id, (LOWER(name))) DO UPDATE SET name = tags.name
+			RETURNING id
+		`
+		if err := q.QueryRowContext(ctx, query, userID, name).Scan(&tagID); err != nil {
+			return err
+		}
+		query = `INSERT INTO habit_tags (user_id, habit_id, tag_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+		if _, err := q.ExecContext(ctx, query, userID, habitID, tagID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadHabitTags returns the tag names of the given habits in one query,
+// keyed by habit ID and sorted by name.
+func loadHabitTags(ctx context.Context, userID int64, habitIDs []int64) (map[int64][]string, error) {
+	tags := make(map[int64][]string)
+	if len(habitIDs) == 0 {
+		return tags, nil
+	}
+	query := `
+		SELECT ht.habit_id, t.name
+		FROM habit_tags ht JOIN tags t ON t.id = ht.tag_id
+		WHERE ht.user_id = $1 AND ht.habit_id = ANY($2)
+		ORDER BY LOWER(t.name)
+	`
+	rows, err := db.QueryContext(ctx, query, userID, pq.Array(habitIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var habitID int64
+		var name string
+		if err := rows.Scan(&habitID, &name); err != nil {
+			return nil, err
+		}
+		tags[habitID] = append(tags[habitID], name)
+	}
+	return tags, rows.Err()
+}
+
+func listTags(ctx context.Context, userID int64) ([]Tag, error) {
+	query := `
+		SELECT t.id, t.name, t.created_at, COUNT(ht.habit_id)
+		FROM tags t LEFT JOIN habit_tags ht ON ht.tag_id = t.id
+		WHERE t.user_id = $1
+		GROUP BY t.id
+		ORDER BY LOWER(t.name)
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.CreatedAt, &tag.HabitCount); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func createTag(ctx context.Context, userID int64, tag *Tag) error {
+	query := `INSERT INTO tags (user_id, name, created_at) VALUES ($1, $2, $3) RETURNING id, created_at`
+	return db.QueryRowContext(ctx, query, userID, tag.Name, time.Now().UTC()).Scan(&tag.ID, &tag.CreatedAt)
+}
+
+// renameTag returns sql.ErrNoRows when the tag does not belong to userID.
+func renameTag(ctx context.Context, userID, tagID int64, name string) (Tag, error) {
+	tag := Tag{ID: tagID, Name: name}
+	query := `
+		UPDATE tags SET name = $3 WHERE id = $1 AND user_id = $2
+		RETURNING created_at, (SELECT COUNT(*) FROM habit_tags WHERE tag_id = $1)
+	`
+	err := db.QueryRowContext(ctx, query, tagID, userID, name).Scan(&tag.CreatedAt, &tag.HabitCount)
+	return tag, err
+}
+
+func deleteTag(ctx context.Context, userID, tagID int64) (bool, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM tags WHERE id = $1 AND user_id = $2`, tagID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,230 @@
+package habit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/env"
+	"habit-tracker/pkg/internalauth"
+	"habit-tracker/pkg/metrics"
+	"habit-tracker/pkg/middleware"
+	"habit-tracker/pkg/requestid"
+	"habit-tracker/pkg/tracing"
+)
+
+// userServiceURL is the base URL of the User Service.
+var userServiceURL = env.String("USER_SERVICE_URL", "http://localhost:8080")
+
+// userServiceClient calls the User Service, forwarding the request ID.
+var userServiceClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &requestid.Transport{
+		Base: tracing.Transport(&metrics.Transport{Upstream: "user-service"}),
+	},
+}
+
+// directoryClient calls the User Service's internal API with the shared
+// secret.
+var directoryClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &internalauth.Transport{
+		Token: env.String("INTERNAL_API_TOKEN", ""),
+		Base: &requestid.Transport{
+			Base: tracing.Transport(&metrics.Transport{Upstream: "user-service"}),
+		},
+	},
+}
+
+// caller is the authenticated user with the profile settings the tracker
+// needs to compute calendar days.
+type caller struct {
+	authz.Principal
+	TimeZone string
+}
+
+// Location returns the caller's time zone, falling back to UTC for zones
+// this host does not know.
+func (c caller) Location() *time.Location {
+	if c.TimeZone == "" || c.TimeZone == "Local" {
+		return time.UTC
+	}
+	if loc, err := time.LoadLocation(c.TimeZone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// authenticate returns the caller's user ID, rejecting API keys whose scope
+// does not cover the action (authz.ScopeRead, ScopeTrack or ScopeFull).
+func authenticate(r *http.Request, scope string) (int64, error) {
+	c, err := authenticateCaller(r, scope)
+	return c.UserID, err
+}
+
+// authenticateCaller is authenticate for handlers that also need the
+// caller's profile.
+func authenticateCaller(r *http.Request, scope string) (caller, error) {
+	c, err := lookupCaller(r)
+	if err != nil {
+		return caller{}, err
+	}
+	if !c.Allows(scope) {
+		return caller{}, apierror.Forbidden(fmt.Sprintf("API key scope %q does not allow this action", c.Scope))
+	}
+	return c, nil
+}
+
+// Principal resolves the caller by asking the User Service who owns the
+// request's credentials (a session token or API key), forwarding the
+// Authorization header verbatim. It satisfies authz.Authenticator so
+// tracker routes can use authz.RequireRole.
+func Principal(r *http.Request) (authz.Principal, error) {
+	c, err := lookupCaller(r)
+	return c.Principal, err
+}
+
+func lookupCaller(r *http.Request) (caller, error) {
+	ctx := r.Context()
+	// Without credentials there is nobody to look up; never let the user
+	// service pick a caller on the request's behalf.
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return caller{}, apierror.Unauthorized("Not signed in or session expired")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, userServiceURL+"/me", nil)
+	if err != nil {
+		return caller{}, apierror.Internal(err)
+	}
+	req.Header.Set("Authorization", auth)
+	resp, err := userServiceClient.Do(req)
+	if err != nil {
+		return caller{}, apierror.Upstream("User service is unavailable", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return caller{}, apierror.Unauthorized("Not signed in or session expired")
+	case resp.StatusCode == http.StatusForbidden:
+		return caller{}, apierror.New(http.StatusForbidden, apierror.CodeAccountDisabled, "Account is disabled")
+	case resp.StatusCode != http.StatusOK:
+		return caller{}, apierror.Upstream("User service is unavailable", fmt.Errorf("GET /me: status %d", resp.StatusCode))
+	}
+
+	var user struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+		Role     string `json:"role"`
+		Scope    string `json:"scope"`
+		APIKey   bool   `json:"api_key"`
+		TimeZone string `json:"time_zone"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return caller{}, apierror.Upstream("User service is unavailable", fmt.Errorf("decode /me response: %w", err))
+	}
+	middleware.SetUserID(ctx, user.ID)
+
+	return caller{
+		Principal: authz.Principal{UserID: user.ID, Username: user.Username, Role: user.Role, Scope: user.Scope, APIKey: user.APIKey},
+		TimeZone:  user.TimeZone,
+	}, nil
+}
+
+// userInfo is another user as the User Service's directory describes them.
+type userInfo struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	TimeZone    string `json:"time_zone"`
+}
+
+// Location is the user's time zone, as for caller.
+func (u userInfo) Location() *time.Location {
+	return caller{TimeZone: u.TimeZone}.Location()
+}
+
+// lookupUsers resolves usernames and IDs through the User Service's
+// directory. Unknown and disabled accounts are missing from the result.
+func lookupUsers(ctx context.Context, usernames []string, ids []int64) (map[int64]userInfo, error) {
+	users := make(map[int64]userInfo)
+	if len(usernames) == 0 && len(ids) == 0 {
+		return users, nil
+	}
+	q := url.Values{}
+	for _, name := range usernames {
+		q.Add("username", name)
+	}
+	for _, id := range ids {
+		q.Add("id", fmt.Sprint(id))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, userServiceURL+"/internal/users?"+q.Encode(), nil)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	resp, err := directoryClient.Do(req)
+	if err != nil {
+		return nil, apierror.Upstream("User service is unavailable", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, apierror.Upstream("User service is unavailable", fmt.Errorf("GET /internal/users: status %d", resp.StatusCode))
+	}
+
+	var body struct {
+		Users []userInfo `json:"users"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, apierror.Upstream("User service is unavailable", fmt.Errorf("decode /internal/users response: %w", err))
+	}
+	for _, u := range body.Users {
+		users[u.ID] = u
+	}
+	return users, nil
+}
+
+// fetchAccountExport returns the User Service's export of userID's account
+// as raw JSON, to embed in the account export unchanged.
+func fetchAccountExport(ctx context.Context, userID int64) (json.RawMessage, error) {
+	endpoint := fmt.Sprintf("%s/internal/users/%d/export", userServiceURL, userID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	resp, err := directoryClient.Do(req)
+	if err != nil {
+		return nil, apierror.Upstream("User service is unavailable", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, apierror.Upstream("User service is unavailable", fmt.Errorf("GET /internal/users/%d/export: status %d", userID, resp.StatusCode))
+	}
+
+	var account json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		return nil, apierror.Upstream("User service is unavailable", fmt.Errorf("decode account export: %w", err))
+	}
+	return account, nil
+}
+
+// CheckUserService reports whether the User Service answers its liveness probe.
+func CheckUserService(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, userServiceURL+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := userServiceClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("user service health: status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -1,24 +1,182 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/env"
+	"habit-tracker/pkg/internalauth"
+	"habit-tracker/pkg/logging"
+	"habit-tracker/pkg/metrics"
+	"habit-tracker/pkg/middleware"
+	"habit-tracker/pkg/ratelimit"
+	"habit-tracker/pkg/server"
+	"habit-tracker/pkg/tracing"
+	"habit-tracker/user-service/internal/mail"
+	"habit-tracker/user-service/internal/oidc"
 	"habit-tracker/user-service/internal/user"
 )
 
 func main() {
+	logger := logging.Setup("user-service")
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "user-service")
+	if err != nil {
+		logging.Fatal("Failed to initialize tracing", err)
+	}
+
 	// Initialize database
 	if err := user.InitDB(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		logging.Fatal("Failed to initialize database", err)
+	}
+	metrics.RegisterDB(user.DB(), "users")
+
+	if username := env.String("BOOTSTRAP_ADMIN", ""); username != "" {
+		if err := user.PromoteAdmin(context.Background(), username); err != nil {
+			logging.Fatal("Failed to promote bootstrap admin", err)
+		}
+	}
+
+	health := server.NewHealth()
+	health.AddCheck("database", user.DB().PingContext)
+
+	store, err := rateLimitStore()
+	if err != nil {
+		logging.Fatal("Failed to initialize rate limit store", err)
+	}
+	registerLimiter := &ratelimit.Limiter{Store: store, Limit: ratelimit.PerMinute(5), Prefix: "register:ip:"}
+	loginLimiter := &ratelimit.Limiter{Store: store, Limit: ratelimit.PerMinute(20), Prefix: "login:ip:"}
+	user.SetAccountLimiter(&ratelimit.Limiter{Store: store, Limit: ratelimit.PerMinute(10), Prefix: "login:account:"})
+	recoveryLimiter := &ratelimit.Limiter{Store: store, Limit: ratelimit.PerMinute(5), Prefix: "recovery:ip:"}
+	tokenLimiter := &ratelimit.Limiter{Store: store, Limit: ratelimit.PerMinute(10), Prefix: "token:ip:"}
+
+	user.SetMailer(mailSender())
+	if err := user.SetTOTPEncryptionKey(env.String("TOTP_ENCRYPTION_KEY", "")); err != nil {
+		logging.Fatal("Invalid TOTP_ENCRYPTION_KEY", err)
+	}
+	if client := oidcClient(); client != nil {
+		user.SetOIDCClient(client)
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/register", user.RegisterHandler)
-	mux.HandleFunc("/login", user.LoginHandler)
+	mux.Handle("/register", ratelimit.Middleware(registerLimiter, ratelimit.ByIP)(http.HandlerFunc(user.RegisterHandler)))
+	mux.Handle("/login", ratelimit.Middleware(loginLimiter, ratelimit.ByIP)(http.HandlerFunc(user.LoginHandler)))
+	mux.Handle("/login/2fa", ratelimit.Middleware(loginLimiter, ratelimit.ByIP)(http.HandlerFunc(user.LoginSecondFactorHandler)))
+	mux.Handle("/oidc/login", ratelimit.Middleware(loginLimiter, ratelimit.ByIP)(http.HandlerFunc(user.OIDCLoginHandler)))
+	mux.Handle("/oidc/callback", ratelimit.Middleware(loginLimiter, ratelimit.ByIP)(http.HandlerFunc(user.OIDCCallbackHandler)))
+	mux.HandleFunc("/logout", user.LogoutHandler)
 	mux.HandleFunc("/me", user.MeHandler)
+	mux.HandleFunc("/me/password", user.ChangePasswordHandler)
+	mux.HandleFunc("/me/email", user.ChangeEmailHandler)
+	mux.HandleFunc("/me/2fa/setup", user.TwoFactorSetupHandler)
+	mux.HandleFunc("/me/2fa/confirm", user.TwoFactorConfirmHandler)
+	mux.HandleFunc("/me/2fa/disable", user.TwoFactorDisableHandler)
+	mux.Handle("/verify-email", ratelimit.Middleware(tokenLimiter, ratelimit.ByIP)(http.HandlerFunc(user.VerifyEmailHandler)))
+	mux.Handle("/password/forgot", ratelimit.Middleware(recoveryLimiter, ratelimit.ByIP)(http.HandlerFunc(user.ForgotPasswordHandler)))
+	mux.Handle("/password/reset", ratelimit.Middleware(tokenLimiter, ratelimit.ByIP)(http.HandlerFunc(user.ResetPasswordHandler)))
+	// Personal API keys; managed with a session only.
+	session := func(h http.HandlerFunc) http.Handler {
+		return authz.Authenticate(user.Principal)(h)
+	}
+	mux.Handle("GET /me/api-keys", session(user.ListAPIKeysHandler))
+	mux.Handle("POST /me/api-keys", session(user.CreateAPIKeyHandler))
+	mux.Handle("DELETE /me/api-keys/{id}", session(user.RevokeAPIKeyHandler))
+
+	// Admin API
+	admin := func(h http.HandlerFunc) http.Handler {
+		return authz.Authenticate(user.Principal)(authz.RequireRole(authz.RoleAdmin)(h))
+	}
+	mux.Handle("GET /admin/users", admin(user.ListUsersHandler))
+	mux.Handle("GET /admin/users/{id}", admin(user.GetUserHandler))
+	mux.Handle("POST /admin/users/{id}/disable", admin(user.DisableUserHandler))
+	mux.Handle("POST /admin/users/{id}/enable", admin(user.EnableUserHandler))
+	mux.Handle("POST /admin/users/{id}/logout", admin(user.ForceLogoutHandler))
+	mux.Handle("PUT /admin/users/{id}/role", admin(user.SetRoleHandler))
+	mux.Handle("GET /admin/users/{id}/logins", admin(user.LoginHistoryHandler))
+
+	// Service-to-service routes
+	internal := internalauth.Middleware(env.String("INTERNAL_API_TOKEN", ""))
+	mux.Handle("GET /internal/users", internal(http.HandlerFunc(user.LookupUsersHandler)))
+	mux.Handle("GET /internal/users/{id}/export", internal(http.HandlerFunc(user.ExportAccountHandler)))
+
+	mux.Handle("GET /healthz", health.LivenessHandler())
+	mux.Handle("GET /readyz", health.ReadinessHandler())
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/", apierror.NotFoundHandler())
+
+	route := metrics.ServeMuxRoute(mux)
+	handler := middleware.Chain(mux, middleware.Standard(logger, metrics.Middleware(route))...)
+	handler = tracing.Middleware(route)(handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	user.StartDeletionDispatcher(ctx, env.Duration("DELETION_RETRY_INTERVAL", time.Minute))
+
+	slog.Info("Starting User Service")
+	cfg := server.ConfigFromEnv(":8080")
+	if err := server.Run(ctx, cfg, handler, health); err != nil {
+		logging.Fatal("Server failed", err)
+	}
+
+	// Emails and erasures started by the last requests still need the database.
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	if err := user.Drain(drainCtx); err != nil {
+		slog.Error("Background work did not finish", "error", err)
+	}
+	cancelDrain()
+
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	user.DB().Close()
+}
+
+// rateLimitStore selects the bucket store from RATE_LIMIT_STORE: "memory"
+// (default, single instance) or "postgres" (shared between replicas).
+func rateLimitStore() (ratelimit.Store, error) {
+	if env.String("RATE_LIMIT_STORE", "memory") == "postgres" {
+		return ratelimit.NewPostgresStore(user.DB())
+	}
+	return ratelimit.NewMemoryStore(), nil
+}
+
+// mailSender selects the email transport from MAIL_SENDER: "smtp" (SMTP_ADDR,
+// SMTP_FROM, SMTP_USERNAME, SMTP_PASSWORD, SMTP_TIMEOUT) or "file" (default),
+// which writes messages as JSON into MAIL_OUTBOX_DIR.
+func mailSender() mail.Sender {
+	if env.String("MAIL_SENDER", "file") == "smtp" {
+		return &mail.SMTPSender{
+			Addr:     env.String("SMTP_ADDR", "localhost:25"),
+			From:     env.String("SMTP_FROM", "no-reply@habit-tracker.local"),
+			Username: env.String("SMTP_USERNAME", ""),
+			Password: env.String("SMTP_PASSWORD", ""),
+			Timeout:  env.Duration("SMTP_TIMEOUT", 30*time.Second),
+		}
+	}
+	return &mail.FileSender{Dir: env.String("MAIL_OUTBOX_DIR", "outbox")}
+}
 
-	log.Println("Starting User Service on port 8080")
-	if err := http.ListenAndServe(":8080", mux); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+// oidcClient configures single sign-on from OIDC_ISSUER, OIDC_CLIENT_ID,
+// OIDC_CLIENT_SECRET, OIDC_REDIRECT_URL and OIDC_SCOPES. It returns nil,
+// leaving SSO disabled, when OIDC_ISSUER is unset.
+func oidcClient() *oidc.Client {
+	issuer := env.String("OIDC_ISSUER", "")
+	if issuer == "" {
+		return nil
 	}
-} 
\ No newline at end of file
+	return oidc.NewClient(oidc.Config{
+		Issuer:       issuer,
+		ClientID:     env.String("OIDC_CLIENT_ID", "habit-tracker"),
+		ClientSecret: env.String("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  env.String("OIDC_REDIRECT_URL", "http://localhost:8080/oidc/callback"),
+		Scopes:       strings.Fields(env.String("OIDC_SCOPES", "openid email profile")),
+	}, &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: tracing.Transport(&metrics.Transport{Upstream: "oidc-provider"}),
+	})
+}
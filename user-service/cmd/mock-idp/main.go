@@ -0,0 +1,29 @@
+// Command mock-idp runs a local OpenID Connect provider for trying the user
+// service's SSO login without a real identity provider. See package mockidp.
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"habit-tracker/pkg/env"
+	"habit-tracker/pkg/logging"
+	"habit-tracker/pkg/server"
+	"habit-tracker/user-service/internal/oidc/mockidp"
+)
+
+func main() {
+	logging.Setup("mock-idp")
+
+	cfg := server.ConfigFromEnv(":9090")
+	idp, err := mockidp.New(env.String("MOCK_IDP_ISSUER", "http://localhost:9090"))
+	if err != nil {
+		logging.Fatal("Failed to create mock identity provider", err)
+	}
+	idp.ClientID = env.String("OIDC_CLIENT_ID", "")
+
+	slog.Info("mock identity provider ready", "issuer", idp.Issuer)
+	if err := server.Run(context.Background(), cfg, idp.Handler(), server.NewHealth()); err != nil {
+		logging.Fatal("Server failed", err)
+	}
+}
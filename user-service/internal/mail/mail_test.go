@@ -0,0 +1,112 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	var outbox Outbox
+	if _, ok := outbox.Last("alice@example.com"); ok {
+		t.Fatal("Last on an empty outbox found a message")
+	}
+
+	sentAt := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	outbox.Send(ctx, Message{To: "alice@example.com", Subject: "first", SentAt: sentAt})
+	outbox.Send(ctx, Message{To: "bob@example.com", Subject: "other"})
+	outbox.Send(ctx, Message{To: "alice@example.com", Subject: "second"})
+
+	msgs := outbox.Messages()
+	if len(msgs) != 3 || !msgs[0].SentAt.Equal(sentAt) || msgs[1].SentAt.IsZero() {
+		t.Fatalf("Messages() = %+v", msgs)
+	}
+	msgs[0].Subject = "changed"
+	if outbox.Messages()[0].Subject != "first" {
+		t.Error("Messages() shares its slice with the outbox")
+	}
+
+	if msg, ok := outbox.Last("alice@example.com"); !ok || msg.Subject != "second" {
+		t.Errorf("Last(alice) = %+v, %v; want the second message", msg, ok)
+	}
+	if _, ok := outbox.Last("carol@example.com"); ok {
+		t.Error("Last found a message for an address nothing was sent to")
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	sender := &FileSender{Dir: dir}
+	want := Message{To: "alice@example.com", Subject: "Hello", Body: "Hi\n", SentAt: time.Unix(1700000000, 0).UTC()}
+	if err := sender.Send(context.Background(), want); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "1700000000000000000.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Message
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("file holds %+v, want %+v", got, want)
+	}
+}
+
+func TestSMTPSenderRejectsHeaderInjection(t *testing.T) {
+	sender := &SMTPSender{Addr: "127.0.0.1:1", From: "noreply@example.com"}
+	for _, msg := range []Message{
+		{To: "alice@example.com\r\nBcc: mallory@example.com", Subject: "Hi"},
+		{To: "alice@example.com", Subject: "Hi\nBcc: mallory@example.com"},
+	} {
+		if err := sender.Send(context.Background(), msg); err == nil || err.Error() != "smtp: header values must not contain line breaks" {
+			t.Errorf("Send(%q, %q) = %v, want the line break error", msg.To, msg.Subject, err)
+		}
+	}
+}
+
+func TestSMTPSenderBadAddr(t *testing.T) {
+	sender := &SMTPSender{Addr: "localhost", From: "noreply@example.com"}
+	if err := sender.Send(context.Background(), Message{To: "alice@example.com"}); err == nil {
+		t.Error("Send with an address without a port succeeded")
+	}
+}
+
+// TestSMTPSenderHonorsContext covers a relay that accepts connections but
+// never answers: Send must give up when ctx does.
+func TestSMTPSenderHonorsContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sender := &SMTPSender{Addr: ln.Addr().String(), From: "noreply@example.com"}
+	start := time.Now()
+	err = sender.Send(ctx, Message{To: "alice@example.com", Subject: "Hi"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Send = %v, want the context deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Send took %v after its context expired", elapsed)
+	}
+}
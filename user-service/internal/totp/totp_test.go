@@ -0,0 +1,135 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890",
+// base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the appendix B vectors. They are eight digits;
+// six-digit codes are their last six.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseSecret(t *testing.T) {
+	got, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", time.Unix(59, 0))
+	if err != nil || got != "287082" {
+		t.Errorf("Code(lowercase) = %q, %v; want 287082", got, err)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", time.Unix(59, 0)); err == nil {
+		t.Error("Code with an invalid secret succeeded")
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		wantOK bool
+	}{
+		{"current step", 0, true},
+		{"one step behind", -1, true},
+		{"one step ahead", 1, true},
+		{"two steps behind", -2, false},
+		{"two steps ahead", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := CodeAt(rfcSecret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := Validate(rfcSecret, code, now)
+			if ok != tt.wantOK {
+				t.Fatalf("Validate(code at step %+d) ok = %v, want %v", tt.offset, ok, tt.wantOK)
+			}
+			if ok && step != current+tt.offset {
+				t.Errorf("Validate returned step %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+// TestValidateClock moves the clock across a step boundary: a code stays
+// valid for the step after its own and no longer.
+func TestValidateClock(t *testing.T) {
+	issued := time.Unix(1111111080, 0) // the first second of a step
+	code, _ := Code(rfcSecret, issued)
+
+	for _, tt := range []struct {
+		after  time.Duration
+		wantOK bool
+	}{
+		{0, true},
+		{Period - time.Second, true},
+		{Period, true},
+		{2*Period - time.Second, true},
+		{2 * Period, false},
+	} {
+		if _, ok := Validate(rfcSecret, code, issued.Add(tt.after)); ok != tt.wantOK {
+			t.Errorf("Validate %v after issue ok = %v, want %v", tt.after, ok, tt.wantOK)
+		}
+	}
+}
+
+func TestValidateFormatting(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, tt := range []struct {
+		code   string
+		wantOK bool
+	}{
+		{"287082", true},
+		{" 287 082 ", true},
+		{"28708", false},
+		{"2870820", false},
+		{"", false},
+		{"287083", false},
+	} {
+		if _, ok := Validate(rfcSecret, tt.code, now); ok != tt.wantOK {
+			t.Errorf("Validate(%q) ok = %v, want %v", tt.code, ok, tt.wantOK)
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewSecret()
+	if len(a) != 32 || a == b {
+		t.Errorf("NewSecret() = %q, %q; want two distinct 32-character secrets", a, b)
+	}
+	if _, err := Code(a, time.Now()); err != nil {
+		t.Errorf("Code(NewSecret()): %v", err)
+	}
+}
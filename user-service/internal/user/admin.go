@@ -0,0 +1,317 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/ratelimit"
+	"habit-tracker/pkg/validate"
+)
+
+// Admin handlers are mounted behind authz.Authenticate(Principal) and
+// authz.RequireRole(authz.RoleAdmin), so the principal is always present.
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// userFilter narrows ListUsersHandler results.
+type userFilter struct {
+	Query    string // substring of username, email or display name
+	Role     string
+	Disabled *bool
+	Limit    int
+	Offset   int
+}
+
+func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	q := r.URL.Query()
+	filter := userFilter{
+		Query:  strings.TrimSpace(q.Get("q")),
+		Role:   q.Get("role"),
+		Limit:  defaultPageSize,
+		Offset: 0,
+	}
+
+	var details []apierror.FieldError
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			details = append(details, apierror.FieldError{Field: "limit", Code: "out_of_range", Message: fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+		}
+		filter.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			details = append(details, apierror.FieldError{Field: "offset", Code: "out_of_range", Message: "offset must be a non-negative integer"})
+		}
+		filter.Offset = n
+	}
+	if filter.Role != "" && filter.Role != authz.RoleUser && filter.Role != authz.RoleAdmin {
+		details = append(details, apierror.FieldError{Field: "role", Code: "invalid_choice", Message: "role must be one of: user, admin"})
+	}
+	switch q.Get("status") {
+	case "":
+	case "active":
+		filter.Disabled = new(bool)
+	case "disabled":
+		disabled := true
+		filter.Disabled = &disabled
+	default:
+		details = append(details, apierror.FieldError{Field: "status", Code: "invalid_choice", Message: "status must be one of: active, disabled"})
+	}
+	if len(details) > 0 {
+		apierror.Write(w, r, apierror.Validation(details...))
+		return
+	}
+
+	users, total, err := listUsers(r.Context(), filter)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	response := UserListResponse{
+		Users:  make([]AdminUserResponse, 0, len(users)),
+		Total:  total,
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+	}
+	for _, u := range users {
+		response.Users = append(response.Users, newAdminUserResponse(u))
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	target, ok := loadTarget(w, r)
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(newAdminUserResponse(target))
+}
+
+func DisableUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	admin, _ := authz.FromContext(r.Context())
+	target, ok := loadTarget(w, r)
+	if !ok {
+		return
+	}
+	if target.ID == admin.UserID {
+		apierror.Write(w, r, apierror.BadRequest("Administrators cannot disable their own account"))
+		return
+	}
+
+	if err := setDisabled(r.Context(), target.ID, true); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if err := revokeUserSessions(r.Context(), target.ID, 0); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	recordAdminEvent(r.Context(), eventAccountDisabled, target, admin.UserID, ratelimit.ClientIP(r))
+
+	writeTarget(w, r, target.ID)
+}
+
+func EnableUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	admin, _ := authz.FromContext(r.Context())
+	target, ok := loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := setDisabled(r.Context(), target.ID, false); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	recordAdminEvent(r.Context(), eventAccountEnabled, target, admin.UserID, ratelimit.ClientIP(r))
+
+	writeTarget(w, r, target.ID)
+}
+
+// ForceLogoutHandler ends every session of the target user.
+func ForceLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	admin, _ := authz.FromContext(r.Context())
+	target, ok := loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := revokeUserSessions(r.Context(), target.ID, 0); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	recordAdminEvent(r.Context(), eventForcedLogout, target, admin.UserID, ratelimit.ClientIP(r))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func SetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	admin, _ := authz.FromContext(r.Context())
+	target, ok := loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetRoleRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if target.ID == admin.UserID && req.Role != authz.RoleAdmin {
+		apierror.Write(w, r, apierror.BadRequest("Administrators cannot remove their own admin role"))
+		return
+	}
+
+	if err := setRole(r.Context(), target.ID, req.Role); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	recordAdminEvent(r.Context(), eventRoleChanged, target, admin.UserID, ratelimit.ClientIP(r))
+
+	writeTarget(w, r, target.ID)
+}
+
+// LoginHistoryHandler lists the target's authentication and admin events.
+func LoginHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	target, ok := loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			apierror.Write(w, r, apierror.Validation(apierror.FieldError{Field: "limit", Code: "out_of_range",
+				Message: fmt.Sprintf("limit must be between 1 and %d", maxPageSize)}))
+			return
+		}
+		limit = n
+	}
+
+	events, err := loadAuthEvents(r.Context(), target.ID, limit)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	json.NewEncoder(w).Encode(LoginHistoryResponse{Events: events})
+}
+
+// loadTarget reads the {id} path value and loads that user, writing the
+// error response itself when it fails.
+func loadTarget(w http.ResponseWriter, r *http.Request) (User, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid user ID"))
+		return User{}, false
+	}
+	user, err := getUserByID(r.Context(), id)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("User not found"))
+		return User{}, false
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return User{}, false
+	}
+	return user, true
+}
+
+// writeTarget reloads the user after a change and writes it.
+func writeTarget(w http.ResponseWriter, r *http.Request, id int64) {
+	user, err := getUserByID(r.Context(), id)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	json.NewEncoder(w).Encode(newAdminUserResponse(user))
+}
+
+func newAdminUserResponse(u User) AdminUserResponse {
+	return AdminUserResponse{UserResponse: newUserResponse(u), DisabledAt: u.DisabledAt, LastLogin: u.LastLogin}
+}
+
+func listUsers(ctx context.Context, f userFilter) ([]User, int, error) {
+	where := `
+		WHERE ($1 = '' OR username ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%' OR display_name ILIKE '%' || $1 || '%')
+		AND ($2 = '' OR role = $2)
+		AND ($3::BOOLEAN IS NULL OR (disabled_at IS NOT NULL) = $3)
+	`
+	search := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(f.Query)
+	disabled := sql.NullBool{}
+	if f.Disabled != nil {
+		disabled = sql.NullBool{Bool: *f.Disabled, Valid: true}
+	}
+
+	var total int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`+where, search, f.Role, disabled).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users` + where + ` ORDER BY id LIMIT $4 OFFSET $5`
+	rows, err := db.QueryContext(ctx, query, search, f.Role, disabled, f.Limit, f.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+	return users, total, rows.Err()
+}
+
+func setDisabled(ctx context.Context, userID int64, disabled bool) error {
+	query := `UPDATE users SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, $3) END WHERE id = $1`
+	_, err := db.ExecContext(ctx, query, userID, disabled, now())
+	return err
+}
+
+func setRole(ctx context.Context, userID int64, role string) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET role = $2 WHERE id = $1`, userID, role)
+	return err
+}
+
+// PromoteAdmin grants the admin role to username. It is used to bootstrap
+// the first administrator from configuration.
+func PromoteAdmin(ctx context.Context, username string) error {
+	result, err := db.ExecContext(ctx, `UPDATE users SET role = $2 WHERE username = $1`, username, authz.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %q does not exist", username)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/validate"
+)
+
+// API key handlers are mounted behind authz.Authenticate(Principal), which
+// accepts sessions only: a key can never mint or revoke keys.
+
+const (
+	// apiKeyPrefix marks API keys so they can be told apart from session
+	// tokens (and spotted by secret scanners).
+	apiKeyPrefix      = "htk_"
+	defaultAPIKeyDays = 90
+	maxAPIKeys        = 25
+)
+
+func ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	p, _ := authz.FromContext(r.Context())
+	keys, err := listAPIKeys(r.Context(), p.UserID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	json.NewEncoder(w).Encode(APIKeyListResponse{APIKeys: keys})
+}
+
+func CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req CreateAPIKeyRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultAPIKeyDays
+	}
+
+	p, _ := authz.FromContext(r.Context())
+	count, err := countAPIKeys(r.Context(), p.UserID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if count >= maxAPIKeys {
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "Too many API keys; revoke one first"))
+		return
+	}
+
+	token, _, err := newToken()
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	key := apiKeyPrefix + token
+	t := now()
+	apiKey := APIKey{
+		Name:      strings.TrimSpace(req.Name),
+		Scope:     req.Scope,
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		CreatedAt: t,
+		ExpiresAt: t.AddDate(0, 0, req.ExpiresInDays),
+	}
+	if err := saveAPIKey(r.Context(), p.UserID, &apiKey, hashToken(key)); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{Key: key, APIKey: apiKey})
+}
+
+func RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid API key ID"))
+		return
+	}
+	p, _ := authz.FromContext(r.Context())
+	revoked, err := revokeAPIKey(r.Context(), p.UserID, id)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if !revoked {
+		apierror.Write(w, r, apierror.NotFound("API key not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticateWithAPIKey is authenticate extended to API keys. It also
+// returns the credential's scope, authz.ScopeFull for sessions.
+func authenticateWithAPIKey(r *http.Request) (User, string, error) {
+	token := bearerToken(r)
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		user, _, err := authenticate(r)
+		return user, authz.ScopeFull, err
+	}
+
+	user, scope, err := apiKeyUser(r.Context(), token)
+	if err == sql.ErrNoRows {
+		return User{}, "", apierror.Unauthorized("API key is invalid, revoked or expired")
+	}
+	if err != nil {
+		return User{}, "", apierror.Internal(err)
+	}
+	if user.Disabled() {
+		return User{}, "", errAccountDisabled
+	}
+	return user, scope, nil
+}
+
+// apiKeyUser returns the owner and scope of a live key, recording its use.
+func apiKeyUser(ctx context.Context, key string) (User, string, error) {
+	var userID int64
+	var scope string
+	query := `
+		UPDATE api_keys SET last_used_at = $2
+		WHERE key_hash = $1 AND revoked_at IS NULL AND expires_at > $2
+		RETURNING user_id, scope
+	`
+	if err := db.QueryRowContext(ctx, query, hashToken(key), now()).Scan(&userID, &scope); err != nil {
+		return User{}, "", err
+	}
+	user, err := getUserByID(ctx, userID)
+	return user, scope, err
+}
+
+func saveAPIKey(ctx context.Context, userID int64, key *APIKey, hash string) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, scope, prefix, key_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
+	`
+	return db.QueryRowContext(ctx, query, userID, key.Name, key.Scope, key.Prefix, hash, key.CreatedAt, key.ExpiresAt).Scan(&key.ID)
+}
+
+// listAPIKeys returns userID's unrevoked keys, newest first. Expired keys
+// are included so users can see what needs replacing.
+func listAPIKeys(ctx context.Context, userID int64) ([]APIKey, error) {
+	query := `
+		SELECT id, name, scope, prefix, created_at, expires_at, last_used_at
+		FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC, id DESC
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &k.Scope, &k.Prefix, &k.CreatedAt, &k.ExpiresAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		if lastUsed.Valid {
+			k.LastUsedAt = &lastUsed.Time
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// countAPIKeys counts userID's live keys toward maxAPIKeys.
+func countAPIKeys(ctx context.Context, userID int64) (int, error) {
+	var n int
+	query := `SELECT COUNT(*) FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2`
+	err := db.QueryRowContext(ctx, query, userID, now()).Scan(&n)
+	return n, err
+}
+
+func revokeAPIKey(ctx context.Context, userID, id int64) (bool, error) {
+	query := `UPDATE api_keys SET revoked_at = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := db.ExecContext(ctx, query, id, userID, now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
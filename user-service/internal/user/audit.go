@@ -0,0 +1,79 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+)
+
+// Authentication events recorded in auth_events.
+const (
+	eventLoginSucceeded = "login_succeeded"
+	eventLoginFailed    = "login_failed"
+	eventAccountLocked  = "account_locked"
+	eventLoginBlocked   = "login_blocked"
+	eventMFAChallenged  = "mfa_challenged"
+	eventMFAFailed      = "mfa_failed"
+	eventSSOLinked      = "sso_linked"
+	eventSSOProvisioned = "sso_provisioned"
+
+	// Administrative actions; actor_id records the admin.
+	eventAccountDisabled = "account_disabled"
+	eventAccountEnabled  = "account_enabled"
+	eventForcedLogout    = "forced_logout"
+	eventRoleChanged     = "role_changed"
+)
+
+// recordAuthEvent appends to the audit trail. userID is 0 when the username
+// does not belong to an account. Failures are logged, never returned, so
+// auditing cannot break login.
+func recordAuthEvent(ctx context.Context, event, username string, userID int64, ip string) {
+	slog.InfoContext(ctx, "auth event", "event", event, "username", username, "user_id", userID, "ip", ip)
+
+	query := `INSERT INTO auth_events (user_id, username, event, ip) VALUES ($1, $2, $3, $4)`
+	_, err := db.ExecContext(ctx, query, sql.NullInt64{Int64: userID, Valid: userID != 0}, username, event, ip)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to record auth event", "event", event, "error", err)
+	}
+}
+
+// recordAdminEvent audits an administrator's action against target.
+func recordAdminEvent(ctx context.Context, event string, target User, actorID int64, ip string) {
+	slog.InfoContext(ctx, "admin action", "event", event, "target_user_id", target.ID, "actor_id", actorID, "ip", ip)
+
+	query := `INSERT INTO auth_events (user_id, username, event, ip, actor_id) VALUES ($1, $2, $3, $4, $5)`
+	_, err := db.ExecContext(ctx, query, target.ID, target.Username, event, ip, actorID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to record admin event", "event", event, "error", err)
+	}
+}
+
+// loadAuthEvents returns the most recent events for userID, newest first.
+func loadAuthEvents(ctx context.Context, userID int64, limit int) ([]AuthEvent, error) {
+	query := `
+		SELECT event, COALESCE(ip, ''), actor_id, created_at
+		FROM auth_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+	rows, err := db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuthEvent{}
+	for rows.Next() {
+		var e AuthEvent
+		var actorID sql.NullInt64
+		if err := rows.Scan(&e.Event, &e.IP, &actorID, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			e.ActorID = &actorID.Int64
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,36 @@
+package user
+
+import (
+	"context"
+	"sync"
+)
+
+// background tracks work started after a response has been sent, such as
+// password reset emails, so shutdown can wait for it.
+var background sync.WaitGroup
+
+// goBackground runs f in a goroutine that outlives the request but not
+// the process: Drain waits for it.
+func goBackground(ctx context.Context, f func(context.Context)) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		f(context.WithoutCancel(ctx))
+	}()
+}
+
+// Drain waits for background work to finish, giving up when ctx is done.
+// Call it after the server has stopped accepting requests.
+func Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,9 +1,14 @@
 package user
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	_ "github.com/lib/pq"
+
+	"github.com/lib/pq"
+
+	"habit-tracker/pkg/tracing"
 )
 
 const (
@@ -16,12 +21,141 @@ const (
 
 var db *sql.DB
 
+var (
+	ErrUsernameTaken = errors.New("username already taken")
+	ErrEmailTaken    = errors.New("email already registered")
+)
+
+// uniqueViolation is the Postgres SQLSTATE for unique constraint violations.
+const uniqueViolation = "23505"
+
+// schema is applied in order by InitDB; every statement must be idempotent.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id SERIAL PRIMARY KEY,
+		username VARCHAR(50) UNIQUE NOT NULL,
+		email VARCHAR(100) UNIQUE NOT NULL,
+		password VARCHAR(100) NOT NULL,
+		last_login TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+	// Failures are keyed by the submitted username, not users.id, so
+	// unknown usernames lock out exactly like real ones.
+	`CREATE TABLE IF NOT EXISTS login_failures (
+		username VARCHAR(50) PRIMARY KEY,
+		failed_count INTEGER NOT NULL,
+		last_failed_at TIMESTAMP NOT NULL,
+		locked_until TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS auth_events (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+		username VARCHAR(50) NOT NULL,
+		event VARCHAR(32) NOT NULL,
+		ip VARCHAR(45),
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS auth_events_user_id_idx ON auth_events (user_id, created_at)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP`,
+	`CREATE TABLE IF NOT EXISTS user_tokens (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		purpose VARCHAR(32) NOT NULL,
+		token_hash CHAR(64) UNIQUE NOT NULL,
+		email VARCHAR(100),
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS display_name VARCHAR(100) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+		ADD COLUMN IF NOT EXISTS locale VARCHAR(35) NOT NULL DEFAULT 'en',
+		ADD COLUMN IF NOT EXISTS week_start VARCHAR(9) NOT NULL DEFAULT 'monday'`,
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		token_hash CHAR(64) UNIQUE NOT NULL,
+		ip VARCHAR(45),
+		user_agent VARCHAR(255),
+		created_at TIMESTAMP NOT NULL,
+		last_seen_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id)`,
+	// Deliberately no foreign key: rows outlive the user they describe until
+	// the tracker has erased its copy of the user's data.
+	`CREATE TABLE IF NOT EXISTS pending_deletions (
+		user_id BIGINT PRIMARY KEY,
+		requested_at TIMESTAMP NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT,
+		completed_at TIMESTAMP
+	)`,
+	`ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user',
+		ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP`,
+	`ALTER TABLE auth_events ADD COLUMN IF NOT EXISTS actor_id BIGINT`,
+	// totp_secret is set when enrolment starts; totp_enabled_at once the
+	// first code is confirmed. totp_last_step prevents code replay.
+	`ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64),
+		ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0`,
+	// Encrypted secrets are longer than the base32 ones.
+	`ALTER TABLE users ALTER COLUMN totp_secret TYPE TEXT`,
+	`CREATE TABLE IF NOT EXISTS recovery_codes (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash CHAR(64) NOT NULL,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id)`,
+	`CREATE TABLE IF NOT EXISTS user_identities (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		issuer VARCHAR(255) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		email VARCHAR(100),
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		last_login_at TIMESTAMP,
+		UNIQUE (issuer, subject)
+	)`,
+	`CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id)`,
+	// In-flight SSO logins: the PKCE verifier and nonce for each state.
+	`CREATE TABLE IF NOT EXISTS oidc_login_states (
+		state_hash CHAR(64) PRIMARY KEY,
+		code_verifier VARCHAR(128) NOT NULL,
+		nonce VARCHAR(64) NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		scope VARCHAR(8) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash CHAR(64) UNIQUE NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id)`,
+}
+
+// DB returns the connection pool opened by InitDB.
+func DB() *sql.DB {
+	return db
+}
+
 func InitDB() error {
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
 
 	var err error
-	db, err = sql.Open("postgres", psqlInfo)
+	db, err = tracing.OpenPostgres(psqlInfo)
 	if err != nil {
 		return err
 	}
@@ -31,36 +165,141 @@ func InitDB() error {
 		return err
 	}
 
-	// Create users table if it doesn't exist
-	createTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		username VARCHAR(50) UNIQUE NOT NULL,
-		email VARCHAR(100) UNIQUE NOT NULL,
-		password VARCHAR(100) NOT NULL,
-		last_login TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	_, err = db.Exec(createTable)
-	return err
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func saveUser(user User) error {
-	query := `INSERT INTO users (username, email, password) VALUES ($1, $2, $3) RETURNING id`
-	return db.QueryRow(query, user.Username, user.Email, user.Password).Scan(&user.ID)
+// userColumns is the column list read by scanUser.
+const userColumns = `id, username, email, password, email_verified_at, display_name, time_zone, locale, week_start, role, disabled_at, last_login, totp_enabled_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func getUserByUsername(username string) (User, error) {
+func scanUser(row rowScanner) (User, error) {
 	var user User
-	query := `SELECT id, username, email, password FROM users WHERE username = $1`
-	err := db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Email, &user.Password)
+	var verifiedAt, disabledAt, lastLogin, totpEnabledAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &verifiedAt,
+		&user.DisplayName, &user.TimeZone, &user.Locale, &user.WeekStart,
+		&user.Role, &disabledAt, &lastLogin, &totpEnabledAt)
+	user.EmailVerified = verifiedAt.Valid
+	user.TwoFactorEnabled = totpEnabledAt.Valid
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+	if lastLogin.Valid {
+		user.LastLogin = &lastLogin.Time
+	}
 	return user, err
 }
 
-func updateLastLogin(username string) error {
+func saveUser(ctx context.Context, user *User) error {
+	query := `INSERT INTO users (username, email, password) VALUES ($1, $2, $3) RETURNING id`
+	err := db.QueryRowContext(ctx, query, user.Username, user.Email, user.Password).Scan(&user.ID)
+	return translateUniqueViolation(err)
+}
+
+// translateUniqueViolation maps duplicate username/email inserts to
+// ErrUsernameTaken and ErrEmailTaken so handlers can answer with 409.
+func translateUniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != uniqueViolation {
+		return err
+	}
+	switch pqErr.Constraint {
+	case "users_username_key":
+		return ErrUsernameTaken
+	case "users_email_key":
+		return ErrEmailTaken
+	}
+	return err
+}
+
+func getUserByUsername(ctx context.Context, username string) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+	return scanUser(db.QueryRowContext(ctx, query, username))
+}
+
+func getUserByID(ctx context.Context, id int64) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	return scanUser(db.QueryRowContext(ctx, query, id))
+}
+
+func getUserByEmail(ctx context.Context, email string) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE LOWER(email) = LOWER($1)`
+	return scanUser(db.QueryRowContext(ctx, query, email))
+}
+
+// setPassword stores a new password hash for userID.
+func setPassword(ctx context.Context, userID int64, hash string) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET password = $2 WHERE id = $1`, userID, hash)
+	return err
+}
+
+// updateProfile sets the fields of req that are non-nil.
+func updateProfile(ctx context.Context, userID int64, req UpdateProfileRequest) error {
+	query := `
+		UPDATE users SET
+			display_name = COALESCE($2, display_name),
+			time_zone = COALESCE($3, time_zone),
+			locale = COALESCE($4, locale),
+			week_start = COALESCE($5, week_start)
+		WHERE id = $1
+	`
+	_, err := db.ExecContext(ctx, query, userID, req.DisplayName, req.TimeZone, req.Locale, req.WeekStart)
+	return err
+}
+
+// changeEmail replaces userID's address with one that has just been
+// verified.
+func changeEmail(ctx context.Context, userID int64, email string) error {
+	query := `UPDATE users SET email = $2, email_verified_at = $3 WHERE id = $1`
+	_, err := db.ExecContext(ctx, query, userID, email, now())
+	return translateUniqueViolation(err)
+}
+
+// deleteUser removes userID and queues the erasure of its tracker data in
+// the same transaction, so a crash cannot leave orphaned habits behind.
+func deleteUser(ctx context.Context, userID int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO pending_deletions (user_id, requested_at) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, query, userID, now()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// markEmailVerified verifies userID's email, but only if it is still the
+// address the token was issued for.
+func markEmailVerified(ctx context.Context, userID int64, email string) (bool, error) {
+	query := `UPDATE users SET email_verified_at = $3 WHERE id = $1 AND email = $2`
+	result, err := db.ExecContext(ctx, query, userID, email, now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func updateLastLogin(ctx context.Context, username string) error {
 	// First check if user exists
 	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", username).Scan(&exists)
+	err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", username).Scan(&exists)
 	if err != nil {
 		return fmt.Errorf("failed to check if user exists: %v", err)
 	}
@@ -70,7 +309,7 @@ func updateLastLogin(username string) error {
 
 	// Update last login time
 	query := `UPDATE users SET last_login = CURRENT_TIMESTAMP WHERE username = $1`
-	result, err := db.Exec(query, username)
+	result, err := db.ExecContext(ctx, query, username)
 	if err != nil {
 		return fmt.Errorf("failed to update login time: %v", err)
 	}
@@ -86,9 +325,7 @@ func updateLastLogin(username string) error {
 	return nil
 }
 
-func getLastLoggedInUser() (User, error) {
-	var user User
-	query := `SELECT id, username, email, password FROM users ORDER BY last_login DESC LIMIT 1`
-	err := db.QueryRow(query).Scan(&user.ID, &user.Username, &user.Email, &user.Password)
-	return user, err
-} 
\ No newline at end of file
+func getLastLoggedInUser(ctx context.Context) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM users ORDER BY last_login DESC LIMIT 1`
+	return scanUser(db.QueryRowContext(ctx, query))
+}
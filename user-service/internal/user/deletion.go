@@ -0,0 +1,106 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"habit-tracker/pkg/env"
+	"habit-tracker/pkg/internalauth"
+	"habit-tracker/pkg/metrics"
+	"habit-tracker/pkg/requestid"
+	"habit-tracker/pkg/tracing"
+)
+
+// trackerServiceURL is the base URL of the Tracker Service.
+var trackerServiceURL = env.String("TRACKER_SERVICE_URL", "http://localhost:8081")
+
+// trackerClient calls the tracker's internal API with the shared secret.
+var trackerClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &internalauth.Transport{
+		Token: env.String("INTERNAL_API_TOKEN", ""),
+		Base: &requestid.Transport{
+			Base: tracing.Transport(&metrics.Transport{Upstream: "tracker-service"}),
+		},
+	},
+}
+
+// dispatchDeletion asks the tracker to erase userID's data and records the
+// outcome in pending_deletions.
+func dispatchDeletion(ctx context.Context, userID int64) {
+	err := eraseTrackerData(ctx, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "tracker data erasure failed; will retry", "user_id", userID, "error", err)
+		_, err = db.ExecContext(ctx, `UPDATE pending_deletions SET attempts = attempts + 1, last_error = $2 WHERE user_id = $1`, userID, err.Error())
+	} else {
+		_, err = db.ExecContext(ctx, `UPDATE pending_deletions SET attempts = attempts + 1, last_error = NULL, completed_at = $2 WHERE user_id = $1`, userID, now())
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to update pending deletion", "user_id", userID, "error", err)
+	}
+}
+
+func eraseTrackerData(ctx context.Context, userID int64) error {
+	url := fmt.Sprintf("%s/internal/users/%d", trackerServiceURL, userID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := trackerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("DELETE %s: status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// StartDeletionDispatcher retries outstanding tracker erasures every
+// interval until ctx is cancelled.
+func StartDeletionDispatcher(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				retryPendingDeletions(ctx)
+			}
+		}
+	}()
+}
+
+func retryPendingDeletions(ctx context.Context) {
+	query := `
+		SELECT user_id FROM pending_deletions
+		WHERE completed_at IS NULL
+		ORDER BY requested_at
+		LIMIT 50
+	`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load pending deletions", "error", err)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			slog.ErrorContext(ctx, "failed to scan pending deletion", "error", err)
+			break
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		dispatchDeletion(ctx, id)
+	}
+}
@@ -0,0 +1,89 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+
+	"habit-tracker/pkg/apierror"
+)
+
+// maxDirectoryLookup caps how many users one directory request resolves.
+const maxDirectoryLookup = 100
+
+// DirectoryEntry is the public part of a profile that other services may
+// show to a user's partners.
+type DirectoryEntry struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	TimeZone    string `json:"time_zone"`
+}
+
+type DirectoryResponse struct {
+	Users []DirectoryEntry `json:"users"`
+}
+
+// LookupUsersHandler resolves ?username= and ?id= (both repeatable) to
+// directory entries for the tracker. Unknown and disabled accounts are left
+// out rather than reported. It must be mounted behind internalauth.
+func LookupUsersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	q := r.URL.Query()
+	usernames := q["username"]
+	var ids []int64
+	for _, v := range q["id"] {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			apierror.Write(w, r, apierror.BadRequest("Invalid user ID"))
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(usernames)+len(ids) > maxDirectoryLookup {
+		apierror.Write(w, r, apierror.BadRequest("Too many users requested"))
+		return
+	}
+
+	users, err := lookupDirectory(r.Context(), usernames, ids)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	json.NewEncoder(w).Encode(DirectoryResponse{Users: users})
+}
+
+func lookupDirectory(ctx context.Context, usernames []string, ids []int64) ([]DirectoryEntry, error) {
+	users := []DirectoryEntry{}
+	if len(usernames) == 0 && len(ids) == 0 {
+		return users, nil
+	}
+	query := `
+		SELECT id, username, display_name, time_zone FROM users
+		WHERE (username = ANY($1) OR id = ANY($2)) AND disabled_at IS NULL
+		ORDER BY id
+	`
+	rows, err := db.QueryContext(ctx, query, pq.Array(usernames), pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u DirectoryEntry
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.TimeZone); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,141 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+)
+
+// maxExportEvents bounds the audit history included in an account export.
+const maxExportEvents = 10000
+
+// AccountExport is everything the user service holds about an account,
+// minus secrets: password, TOTP and token hashes are never included.
+type AccountExport struct {
+	Profile    User               `json:"profile"`
+	LastLogin  *time.Time         `json:"last_login"`
+	Sessions   []ExportedSession  `json:"sessions"`
+	APIKeys    []APIKey           `json:"api_keys"`
+	Identities []ExportedIdentity `json:"identities"`
+	AuthEvents []AuthEvent        `json:"auth_events"`
+}
+
+type ExportedSession struct {
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// ExportedIdentity is a linked single sign-on identity.
+type ExportedIdentity struct {
+	Issuer      string     `json:"issuer"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// ExportAccountHandler answers GET /internal/users/{id}/export for the
+// tracker's account export. It must be mounted behind internalauth.
+func ExportAccountHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Invalid user ID"))
+		return
+	}
+	export, err := exportAccount(r.Context(), id)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.NotFound("User not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	json.NewEncoder(w).Encode(export)
+}
+
+func exportAccount(ctx context.Context, userID int64) (AccountExport, error) {
+	var export AccountExport
+	user, err := getUserByID(ctx, userID)
+	if err != nil {
+		return export, err
+	}
+	export.Profile, export.LastLogin = user, user.LastLogin
+
+	if export.Sessions, err = exportSessions(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.APIKeys, err = listAPIKeys(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.Identities, err = exportIdentities(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.AuthEvents, err = loadAuthEvents(ctx, userID, maxExportEvents); err != nil {
+		return export, err
+	}
+	return export, nil
+}
+
+func exportSessions(ctx context.Context, userID int64) ([]ExportedSession, error) {
+	query := `
+		SELECT COALESCE(ip, ''), COALESCE(user_agent, ''), created_at, last_seen_at, expires_at, revoked_at
+		FROM sessions WHERE user_id = $1 ORDER BY created_at DESC, id DESC
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []ExportedSession{}
+	for rows.Next() {
+		var s ExportedSession
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			s.RevokedAt = &revokedAt.Time
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func exportIdentities(ctx context.Context, userID int64) ([]ExportedIdentity, error) {
+	query := `
+		SELECT issuer, subject, COALESCE(email, ''), created_at, last_login_at
+		FROM user_identities WHERE user_id = $1 ORDER BY created_at, id
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []ExportedIdentity{}
+	for rows.Next() {
+		var i ExportedIdentity
+		var lastLogin sql.NullTime
+		if err := rows.Scan(&i.Issuer, &i.Subject, &i.Email, &i.CreatedAt, &lastLogin); err != nil {
+			return nil, err
+		}
+		if lastLogin.Valid {
+			i.LastLoginAt = &lastLogin.Time
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
@@ -0,0 +1,121 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeResult answers one statement: rows for queries, affected for execs.
+type fakeResult struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+}
+
+// fakeHandler answers the statements a test expects. Anything else should
+// fail the test.
+type fakeHandler func(query string, args []driver.Value) (fakeResult, error)
+
+// useFakeDB points the package's db at handler for the rest of the test.
+// It stands in for Postgres where only the Go side of a statement is under
+// test; the SQL itself is not run.
+func useFakeDB(t *testing.T, handler fakeHandler) {
+	t.Helper()
+	fakeHandlers.Lock()
+	fakeHandlers.next++
+	name := fmt.Sprint(fakeHandlers.next)
+	fakeHandlers.m[name] = handler
+	fakeHandlers.Unlock()
+
+	conn, err := sql.Open("userfake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := db
+	db = conn
+	t.Cleanup(func() {
+		db = saved
+		conn.Close()
+	})
+}
+
+var fakeHandlers = struct {
+	sync.Mutex
+	next int
+	m    map[string]fakeHandler
+}{m: make(map[string]fakeHandler)}
+
+func init() {
+	sql.Register("userfake", fakeDriver{})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeHandlers.Lock()
+	defer fakeHandlers.Unlock()
+	return &fakeConn{handler: fakeHandlers.m[name]}, nil
+}
+
+type fakeConn struct {
+	handler fakeHandler
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("fake db: prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.handler(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.handler(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: res.columns, rows: res.rows}, nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	vs := make([]driver.Value, len(args))
+	for i, a := range args {
+		vs[i] = a.Value
+	}
+	return vs
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -3,34 +3,53 @@ package user
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/middleware"
+	"habit-tracker/pkg/ratelimit"
+	"habit-tracker/pkg/validate"
 )
 
+// maxBodyBytes caps register and login payloads, which are a few short strings.
+const maxBodyBytes = 4 << 10
+
+// accountLimiter throttles login attempts per username; nil disables it.
+var accountLimiter *ratelimit.Limiter
+
+// SetAccountLimiter installs the per-account login rate limiter.
+func SetAccountLimiter(l *ratelimit.Limiter) {
+	accountLimiter = l
+}
+
+// writeLocked answers a login attempt against a locked account.
+func writeLocked(w http.ResponseWriter, r *http.Request, until time.Time) {
+	ratelimit.SetRetryAfter(w, until.Sub(now()))
+	apierror.Write(w, r, apierror.New(http.StatusTooManyRequests, apierror.CodeAccountLocked,
+		"Too many failed login attempts; try again later"))
+}
+
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		apierror.Write(w, r, apierror.MethodNotAllowed())
 		return
 	}
 
 	var req RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
 		return
 	}
 
-	if req.Username == "" {
-		http.Error(w, `{"error": "Username is required"}`, http.StatusBadRequest)
-		return
-	}
-	if req.Email == "" {
-		http.Error(w, `{"error": "Email is required"}`, http.StatusBadRequest)
-		return
-	}
-	if req.Password == "" {
-		http.Error(w, `{"error": "Password is required"}`, http.StatusBadRequest)
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
 		return
 	}
 
@@ -38,15 +57,25 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	user := User{
 		Username: req.Username,
 		Email:    req.Email,
-		Password: req.Password,
+		Password: hash,
 	}
 
 	// Save to database
-	if err := saveUser(user); err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to create user: %v"}`, err), http.StatusInternalServerError)
+	if err := saveUser(r.Context(), &user); err != nil {
+		switch {
+		case errors.Is(err, ErrUsernameTaken):
+			apierror.Write(w, r, apierror.Conflict(apierror.CodeUsernameTaken, "Username is already taken"))
+		case errors.Is(err, ErrEmailTaken):
+			apierror.Write(w, r, apierror.Conflict(apierror.CodeEmailTaken, "Email is already registered"))
+		default:
+			apierror.Write(w, r, apierror.Internal(err))
+		}
 		return
 	}
 
+	registrations.Inc()
+	sendVerificationEmail(r.Context(), user)
+
 	// Return success message
 	response := RegisterResponse{
 		Message: "Registration successful",
@@ -60,44 +89,116 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		apierror.Write(w, r, apierror.MethodNotAllowed())
 		return
 	}
 
 	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
 		return
 	}
 
-	user, err := getUserByUsername(req.Username)
+	ip := ratelimit.ClientIP(r)
+
+	if accountLimiter != nil {
+		if res := accountLimiter.Allow(r.Context(), req.Username); !res.Allowed {
+			logins.WithLabelValues("rate_limited").Inc()
+			ratelimit.WriteLimited(w, r, res.RetryAfter)
+			return
+		}
+	}
+
+	until, err := lockedUntil(r.Context(), req.Username)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
-		} else {
-			http.Error(w, fmt.Sprintf(`{"error": "Failed to get user: %v"}`, err), http.StatusInternalServerError)
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if !until.IsZero() {
+		logins.WithLabelValues("locked").Inc()
+		recordAuthEvent(r.Context(), eventLoginBlocked, req.Username, 0, ip)
+		writeLocked(w, r, until)
+		return
+	}
+
+	// Unknown usernames and wrong passwords get the same response so the
+	// endpoint cannot be used to discover accounts.
+	user, err := getUserByUsername(r.Context(), req.Username)
+	if err != nil && err != sql.ErrNoRows {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	found := err == nil
+	if !passwordMatches(user.Password, req.Password) || !found {
+		logins.WithLabelValues("invalid_credentials").Inc()
+		recordAuthEvent(r.Context(), eventLoginFailed, req.Username, user.ID, ip)
+
+		until, err := recordLoginFailure(r.Context(), req.Username)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
 		}
+		if !until.IsZero() {
+			recordAuthEvent(r.Context(), eventAccountLocked, req.Username, user.ID, ip)
+		}
+
+		apierror.Write(w, r, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid username or password"))
 		return
 	}
 
-	if user.Password != req.Password {
-		http.Error(w, `{"error": "Invalid password"}`, http.StatusUnauthorized)
+	middleware.SetUserID(r.Context(), user.ID)
+
+	if user.Disabled() {
+		logins.WithLabelValues("disabled").Inc()
+		recordAuthEvent(r.Context(), eventLoginBlocked, user.Username, user.ID, ip)
+		apierror.Write(w, r, errAccountDisabled)
+		return
+	}
+
+	// Upgrade accounts still holding a plaintext password.
+	if !isBcryptHash(user.Password) {
+		if err := upgradePassword(r.Context(), user.ID, req.Password); err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+	}
+
+	if user.TwoFactorEnabled {
+		startSecondFactor(w, r, user, ip)
+		return
+	}
+	completeLogin(w, r, user, ip)
+}
+
+// completeLogin finishes a fully authenticated login: it resets the failure
+// counter, audits the login and issues a session.
+func completeLogin(w http.ResponseWriter, r *http.Request, user User, ip string) {
+	if err := clearLoginFailures(r.Context(), user.Username); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
 		return
 	}
+	recordAuthEvent(r.Context(), eventLoginSucceeded, user.Username, user.ID, ip)
 
 	// Update last login time
-	if err := updateLastLogin(req.Username); err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to update login time: %v"}`, err), http.StatusInternalServerError)
+	if err := updateLastLogin(r.Context(), user.Username); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
 		return
 	}
 
+	token, expires, err := createSession(r.Context(), user.ID, ip, r.UserAgent())
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	logins.WithLabelValues("success").Inc()
+
+	user.Password = ""
 	response := LoginResponse{
-		Message: "Login successful",
-		User: User{
-			ID:       user.ID,
-			Username: user.Username,
-			Email:    user.Email,
-		},
+		Message:   "Login successful",
+		Token:     token,
+		ExpiresAt: expires,
+		User:      user,
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -106,26 +207,43 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 func MeHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != http.MethodGet {
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
-		return
+	switch r.Method {
+	case http.MethodGet:
+		getMe(w, r)
+	case http.MethodPatch:
+		updateMe(w, r)
+	case http.MethodDelete:
+		deleteMe(w, r)
+	default:
+		apierror.Write(w, r, apierror.MethodNotAllowed())
 	}
+}
 
-	user, err := getLastLoggedInUser()
-	if err != nil {
+// getMe is how the tracker resolves callers, so unlike the other account
+// endpoints it also accepts API keys and reports their scope.
+func getMe(w http.ResponseWriter, r *http.Request) {
+	var user User
+	scope := authz.ScopeFull
+	var err error
+	if bearerToken(r) == "" && legacyMeAuth {
+		user, err = getLastLoggedInUser(r.Context())
 		if err == sql.ErrNoRows {
-			http.Error(w, `{"error": "No user is currently logged in"}`, http.StatusUnauthorized)
-		} else {
-			http.Error(w, fmt.Sprintf(`{"error": "Failed to get last logged in user: %v"}`, err), http.StatusInternalServerError)
+			err = apierror.Unauthorized("No user is currently logged in")
+		} else if err == nil && user.Disabled() {
+			err = errAccountDisabled
 		}
+	} else {
+		user, scope, err = authenticateWithAPIKey(r)
+	}
+	if err != nil {
+		apierror.Write(w, r, err)
 		return
 	}
 
-	response := UserResponse{
-		ID:       user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-	}
+	middleware.SetUserID(r.Context(), user.ID)
 
+	response := newUserResponse(user)
+	response.Scope = scope
+	response.APIKey = strings.HasPrefix(bearerToken(r), apiKeyPrefix)
 	json.NewEncoder(w).Encode(response)
-} 
\ No newline at end of file
+}
@@ -0,0 +1,89 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Progressive lockout policy: once an account accumulates maxFailedLogins
+// consecutive failures it is locked for baseLockout, doubling with every
+// further failure up to maxLockout. Failures older than failureWindow are
+// forgotten. Counters are keyed on the exact username: usernames are
+// case-sensitive, so "Alice" and "alice" are different accounts and must not
+// lock each other out.
+const (
+	maxFailedLogins = 5
+	baseLockout     = time.Minute
+	maxLockout      = time.Hour
+	failureWindow   = 24 * time.Hour
+)
+
+// now is the clock used for lockout decisions.
+var now = time.Now
+
+// lockoutDuration returns how long an account with failures consecutive
+// failed logins stays locked, or 0 if it is below the threshold.
+func lockoutDuration(failures int) time.Duration {
+	if failures < maxFailedLogins {
+		return 0
+	}
+	d := baseLockout
+	for i := maxFailedLogins; i < failures && d < maxLockout; i++ {
+		d *= 2
+	}
+	return min(d, maxLockout)
+}
+
+// lockedUntil returns the end of the current lock for username, or the zero
+// time if it is not locked.
+func lockedUntil(ctx context.Context, username string) (time.Time, error) {
+	var until sql.NullTime
+	query := `SELECT locked_until FROM login_failures WHERE username = $1`
+	err := db.QueryRowContext(ctx, query, username).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !until.Valid || !until.Time.After(now()) {
+		return time.Time{}, nil
+	}
+	return until.Time, nil
+}
+
+// recordLoginFailure counts a failed login and returns the new lock
+// expiry if this failure locked the account.
+func recordLoginFailure(ctx context.Context, username string) (time.Time, error) {
+	t := now()
+	var failures int
+	query := `
+		INSERT INTO login_failures (username, failed_count, last_failed_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (username) DO UPDATE SET
+			failed_count = CASE
+				WHEN login_failures.last_failed_at < $3 THEN 1
+				ELSE login_failures.failed_count + 1
+			END,
+			last_failed_at = $2
+		RETURNING failed_count
+	`
+	err := db.QueryRowContext(ctx, query, username, t, t.Add(-failureWindow)).Scan(&failures)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	d := lockoutDuration(failures)
+	if d == 0 {
+		return time.Time{}, nil
+	}
+	until := t.Add(d)
+	_, err = db.ExecContext(ctx, `UPDATE login_failures SET locked_until = $2 WHERE username = $1`, username, until)
+	return until, err
+}
+
+func clearLoginFailures(ctx context.Context, username string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM login_failures WHERE username = $1`, username)
+	return err
+}
@@ -1,16 +1,102 @@
 package user
 
+import "time"
+
 type User struct {
 	ID       int64  `json:"id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"-"` // Password will not be included in JSON responses
+
+	EmailVerified bool   `json:"email_verified"`
+	DisplayName   string `json:"display_name"`
+	TimeZone      string `json:"time_zone"`
+	Locale        string `json:"locale"`
+	WeekStart     string `json:"week_start"`
+	Role          string `json:"role"`
+
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+
+	DisabledAt *time.Time `json:"-"`
+	LastLogin  *time.Time `json:"-"`
 }
 
+// Disabled reports whether an administrator has disabled the account.
+func (u User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// Length limits mirror the VARCHAR sizes of the users table.
 type RegisterRequest struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required,min=3,max=50,username"`
+	Email    string `json:"email" validate:"required,max=100,email"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+// MFAChallengeResponse is returned by POST /login instead of a session when
+// the account has two-factor authentication enabled.
+type MFAChallengeResponse struct {
+	Message     string    `json:"message"`
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type LoginSecondFactorRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required,max=128"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+type TwoFactorSetupRequest struct {
+	CurrentPassword string `json:"current_password" validate:"max=72"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type TwoFactorConfirmRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type TwoFactorConfirmResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorDisableRequest struct {
+	CurrentPassword string `json:"current_password" validate:"max=72"`
+	Code            string `json:"code" validate:"required,max=32"`
+}
+
+// CreateAPIKeyRequest creates a personal API key. ExpiresInDays defaults to
+// defaultAPIKeyDays.
+type CreateAPIKeyRequest struct {
+	Name          string `json:"name" validate:"required,max=100"`
+	Scope         string `json:"scope" validate:"required,oneof=read track full"`
+	ExpiresInDays int    `json:"expires_in_days" validate:"min=1,max=365"`
+}
+
+// APIKey describes a key without its secret.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// CreateAPIKeyResponse carries the only copy of the key the server returns.
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
+
+type APIKeyListResponse struct {
+	APIKeys []APIKey `json:"api_keys"`
 }
 
 type RegisterResponse struct {
@@ -18,17 +104,116 @@ type RegisterResponse struct {
 }
 
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required,max=50"`
+	Password string `json:"password" validate:"required,max=72"`
 }
 
 type LoginResponse struct {
-	Message string `json:"message"`
-	User    User   `json:"user"`
+	Message   string    `json:"message"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      User      `json:"user"`
 }
 
 type UserResponse struct {
-	ID       int64  `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-} 
\ No newline at end of file
+	ID            int64  `json:"id"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	DisplayName   string `json:"display_name"`
+	TimeZone      string `json:"time_zone"`
+	Locale        string `json:"locale"`
+	WeekStart     string `json:"week_start"`
+	Role          string `json:"role"`
+
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+
+	// Scope and APIKey are set by GET /me: "full" for sessions, the key's
+	// scope for API keys. APIKey tells a full-scope key from a session.
+	Scope  string `json:"scope,omitempty"`
+	APIKey bool   `json:"api_key,omitempty"`
+}
+
+func newUserResponse(user User) UserResponse {
+	return UserResponse{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		DisplayName:   user.DisplayName,
+		TimeZone:      user.TimeZone,
+		Locale:        user.Locale,
+		WeekStart:     user.WeekStart,
+		Role:          user.Role,
+
+		TwoFactorEnabled: user.TwoFactorEnabled,
+	}
+}
+
+// UpdateProfileRequest is a partial update: omitted fields are left as is.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name" validate:"max=100"`
+	TimeZone    *string `json:"time_zone" validate:"max=64,timezone"`
+	Locale      *string `json:"locale" validate:"max=35,locale"`
+	WeekStart   *string `json:"week_start" validate:"oneof=monday sunday saturday"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"max=72"`
+	NewPassword     string `json:"new_password" validate:"required,password"`
+}
+
+type ChangeEmailRequest struct {
+	Email           string `json:"email" validate:"required,max=100,email"`
+	CurrentPassword string `json:"current_password" validate:"max=72"`
+}
+
+type DeleteAccountRequest struct {
+	CurrentPassword string `json:"current_password" validate:"max=72"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required,max=128"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,max=100,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required,max=128"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// AdminUserResponse is a user as seen by administrators.
+type AdminUserResponse struct {
+	UserResponse
+	DisabledAt *time.Time `json:"disabled_at"`
+	LastLogin  *time.Time `json:"last_login"`
+}
+
+type UserListResponse struct {
+	Users  []AdminUserResponse `json:"users"`
+	Total  int                 `json:"total"`
+	Limit  int                 `json:"limit"`
+	Offset int                 `json:"offset"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=user admin"`
+}
+
+type AuthEvent struct {
+	Event     string    `json:"event"`
+	IP        string    `json:"ip"`
+	ActorID   *int64    `json:"actor_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LoginHistoryResponse struct {
+	Events []AuthEvent `json:"events"`
+}
@@ -0,0 +1,37 @@
+package user
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against when the username does not exist, so that
+// unknown users cost as much as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func isBcryptHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2")
+}
+
+// passwordMatches checks given against a stored bcrypt hash. Accounts created
+// before hashing was introduced still hold plaintext, which is compared in
+// constant time; callers should rehash those on success. An empty stored
+// value (unknown user) never matches.
+func passwordMatches(stored, given string) bool {
+	switch {
+	case stored == "":
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(given))
+		return false
+	case isBcryptHash(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(given)) == nil
+	default:
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(given)) == 1
+	}
+}
@@ -0,0 +1,243 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"regexp"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/middleware"
+	"habit-tracker/pkg/validate"
+	"habit-tracker/user-service/internal/mail"
+)
+
+// localePattern accepts BCP 47 style tags such as "en", "pt-BR" or "zh-Hant-TW".
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+func init() {
+	validate.Register("timezone", func(v reflect.Value, _ string) (string, string, bool) {
+		name := v.String()
+		_, err := time.LoadLocation(name)
+		return "invalid_time_zone", "must be an IANA time zone such as Europe/Berlin", err == nil && name != "Local"
+	})
+	validate.Register("locale", func(v reflect.Value, _ string) (string, string, bool) {
+		return "invalid_locale", "must be a language tag such as en or pt-BR", localePattern.MatchString(v.String())
+	})
+}
+
+func updateMe(w http.ResponseWriter, r *http.Request) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	middleware.SetUserID(r.Context(), user.ID)
+
+	var req UpdateProfileRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	if err := updateProfile(r.Context(), user.ID, req); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	user, err = getUserByID(r.Context(), user.ID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+// deleteMe erases the account. The tracker's copy of the user's habits is
+// erased asynchronously by the deletion dispatcher.
+func deleteMe(w http.ResponseWriter, r *http.Request) {
+	user, sessionID, err := authenticate(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	middleware.SetUserID(r.Context(), user.ID)
+
+	var req DeleteAccountRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if !confirmCurrentPassword(w, r, user, sessionID, req.CurrentPassword) {
+		return
+	}
+
+	if err := deleteUser(r.Context(), user.ID); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	slog.InfoContext(r.Context(), "account deleted", "user_id", user.ID)
+
+	// Try right away; the dispatcher retries if the tracker is unavailable.
+	goBackground(r.Context(), func(ctx context.Context) { dispatchDeletion(ctx, user.ID) })
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	user, sessionID, err := authenticate(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	middleware.SetUserID(r.Context(), user.ID)
+
+	var req ChangePasswordRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if !confirmCurrentPassword(w, r, user, sessionID, req.CurrentPassword) {
+		return
+	}
+
+	if err := upgradePassword(r.Context(), user.ID, req.NewPassword); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	// Keep the caller signed in but end every other session and any
+	// outstanding reset links.
+	if err := revokeUserSessions(r.Context(), user.ID, sessionID); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if err := revokeTokens(r.Context(), user.ID, purposePasswordReset); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	notify(r.Context(), user.Email, "Your password was changed",
+		fmt.Sprintf("Hi %s,\n\nThe password for your account was just changed. If this was not you, reset your password immediately.\n", user.Username))
+
+	json.NewEncoder(w).Encode(MessageResponse{Message: "Password changed"})
+}
+
+// ChangeEmailHandler starts an email change. The new address only replaces
+// the old one once the link sent to it is confirmed via POST /verify-email.
+func ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	user, sessionID, err := authenticate(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	middleware.SetUserID(r.Context(), user.ID)
+
+	var req ChangeEmailRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if !confirmCurrentPassword(w, r, user, sessionID, req.CurrentPassword) {
+		return
+	}
+
+	if _, err := getUserByEmail(r.Context(), req.Email); err == nil {
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeEmailTaken, "Email is already registered"))
+		return
+	}
+
+	// Only the most recent change request stays valid.
+	if err := revokeTokens(r.Context(), user.ID, purposeChangeEmail); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	token, err := issueToken(r.Context(), user.ID, purposeChangeEmail, req.Email, verifyEmailTTL)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	notify(r.Context(), req.Email, "Confirm your new email address",
+		fmt.Sprintf("Hi %s,\n\nConfirm that this is your new email address by opening the link below within 24 hours:\n\n%s\n\nVerification code: %s\n",
+			user.Username, link("/verify-email", token), token))
+	notify(r.Context(), user.Email, "Email change requested",
+		fmt.Sprintf("Hi %s,\n\nSomeone asked to change the email address on your account to %s. If this was not you, change your password.\n",
+			user.Username, req.Email))
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(MessageResponse{Message: "Check the new address for a confirmation link"})
+}
+
+// confirmEmailChange applies a redeemed change_email token.
+func confirmEmailChange(w http.ResponseWriter, r *http.Request, token redeemedToken) {
+	err := changeEmail(r.Context(), token.UserID, token.Email)
+	if errors.Is(err, ErrEmailTaken) {
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeEmailTaken, "Email is already registered"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(MessageResponse{Message: "Email changed"})
+}
+
+func writeWrongPassword(w http.ResponseWriter, r *http.Request) {
+	apierror.Write(w, r, apierror.New(http.StatusForbidden, apierror.CodeInvalidCredentials, "Current password is incorrect"))
+}
+
+// reauthWindow is how recently an account without a password must have
+// signed in to make a change that otherwise asks for the current password.
+const reauthWindow = 10 * time.Minute
+
+// confirmCurrentPassword checks the current password given for a sensitive
+// change, writing 403 to w if it is wrong. Accounts provisioned through
+// single sign-on have no password: for them a session started within
+// reauthWindow stands in for it, so they confirm by signing in again.
+func confirmCurrentPassword(w http.ResponseWriter, r *http.Request, user User, sessionID int64, given string) bool {
+	if user.Password != "" {
+		if !passwordMatches(user.Password, given) {
+			writeWrongPassword(w, r)
+			return false
+		}
+		return true
+	}
+	var createdAt time.Time
+	err := db.QueryRowContext(r.Context(), `SELECT created_at FROM sessions WHERE id = $1`, sessionID).Scan(&createdAt)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return false
+	}
+	if now().Sub(createdAt) >= reauthWindow {
+		apierror.Write(w, r, apierror.New(http.StatusForbidden, apierror.CodeInvalidCredentials, "Sign in again with single sign-on to confirm this change"))
+		return false
+	}
+	return true
+}
+
+// notify sends a best-effort email; failures are only logged.
+func notify(ctx context.Context, to, subject, body string) {
+	if err := mailer.Send(ctx, mail.Message{To: to, Subject: subject, Body: body}); err != nil {
+		slog.ErrorContext(ctx, "failed to send email", "subject", subject, "error", err)
+	}
+}
@@ -0,0 +1,130 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/authz"
+	"habit-tracker/pkg/env"
+)
+
+// sessionTTL is how long a login session stays valid.
+var sessionTTL = env.Duration("SESSION_TTL", 7*24*time.Hour)
+
+// legacyMeAuth restores the original GET /me behaviour of answering with
+// the most recently logged-in user when no bearer token is sent. It lets
+// anyone act as that user, so it is off unless a client that predates
+// sessions still needs it, and will be removed.
+var legacyMeAuth = env.Bool("AUTH_LEGACY_ME", false)
+
+// createSession starts a session for userID and returns its bearer token.
+func createSession(ctx context.Context, userID int64, ip, userAgent string) (string, time.Time, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	t := now()
+	expires := t.Add(sessionTTL)
+	query := `
+		INSERT INTO sessions (user_id, token_hash, ip, user_agent, created_at, last_seen_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $5, $6)
+	`
+	_, err = db.ExecContext(ctx, query, userID, hash, ip, userAgent, t, expires)
+	return token, expires, err
+}
+
+// sessionUser returns the user owning a live session and the session ID,
+// refreshing its last-seen time.
+func sessionUser(ctx context.Context, token string) (User, int64, error) {
+	var sessionID, userID int64
+	query := `
+		UPDATE sessions SET last_seen_at = $2
+		WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > $2
+		RETURNING id, user_id
+	`
+	if err := db.QueryRowContext(ctx, query, hashToken(token), now()).Scan(&sessionID, &userID); err != nil {
+		return User{}, 0, err
+	}
+	user, err := getUserByID(ctx, userID)
+	return user, sessionID, err
+}
+
+func revokeSession(ctx context.Context, sessionID int64) error {
+	_, err := db.ExecContext(ctx, `UPDATE sessions SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`, sessionID, now())
+	return err
+}
+
+// revokeUserSessions ends every session of userID except keepID (0 keeps none).
+func revokeUserSessions(ctx context.Context, userID, keepID int64) error {
+	query := `UPDATE sessions SET revoked_at = $3 WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`
+	_, err := db.ExecContext(ctx, query, userID, keepID, now())
+	return err
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// authenticate resolves the caller's session. It returns an *apierror.Error
+// suitable for writing directly when the caller is not signed in.
+func authenticate(r *http.Request) (User, int64, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return User{}, 0, apierror.Unauthorized("Missing bearer token")
+	}
+	user, sessionID, err := sessionUser(r.Context(), token)
+	if err == sql.ErrNoRows {
+		return User{}, 0, apierror.Unauthorized("Session is invalid or has expired")
+	}
+	if err != nil {
+		return User{}, 0, apierror.Internal(err)
+	}
+	if user.Disabled() {
+		return User{}, 0, errAccountDisabled
+	}
+	return user, sessionID, nil
+}
+
+var errAccountDisabled = apierror.New(http.StatusForbidden, apierror.CodeAccountDisabled, "Account is disabled")
+
+// Principal authenticates r by session for authz.Authenticate.
+func Principal(r *http.Request) (authz.Principal, error) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		return authz.Principal{}, err
+	}
+	return authz.Principal{UserID: user.ID, Username: user.Username, Role: user.Role}, nil
+}
+
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	_, sessionID, err := authenticate(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if err := revokeSession(r.Context(), sessionID); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,342 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/middleware"
+	"habit-tracker/pkg/ratelimit"
+	"habit-tracker/user-service/internal/oidc"
+)
+
+// ssoStateTTL bounds how long a user may take at the identity provider.
+const ssoStateTTL = 10 * time.Minute
+
+// ssoStateCookie holds the login's state in the browser that started it.
+// The callback only accepts a state that matches it, so an attacker cannot
+// complete their own login in a victim's browser (login CSRF).
+const ssoStateCookie = "sso_state"
+
+// ssoClient is nil when OIDC_ISSUER is unset, which disables SSO routes.
+var ssoClient *oidc.Client
+
+// SetOIDCClient enables single sign-on through c.
+func SetOIDCClient(c *oidc.Client) {
+	ssoClient = c
+}
+
+// OIDCLoginHandler starts an authorization-code login with PKCE by
+// redirecting the browser to the identity provider. The state is also set
+// as a cookie that OIDCCallbackHandler checks.
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if ssoClient == nil {
+		apierror.Write(w, r, apierror.NotFound("Single sign-on is not configured"))
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	state, err := oidc.RandomString(32)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	nonce, err := oidc.RandomString(16)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	verifier, err := oidc.RandomString(32)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if err := saveLoginState(r.Context(), state, verifier, nonce); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	target, err := ssoClient.AuthCodeURL(r.Context(), oidc.AuthRequest{
+		State:     state,
+		Nonce:     nonce,
+		Challenge: oidc.Challenge(verifier),
+		LoginHint: r.URL.Query().Get("login_hint"),
+	})
+	if err != nil {
+		apierror.Write(w, r, apierror.Upstream("Identity provider is unavailable", err))
+		return
+	}
+	setStateCookie(w, r, state, int(ssoStateTTL.Seconds()))
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// OIDCCallbackHandler finishes an SSO login. The external identity is
+// matched to its linked account, linked to an existing account with the same
+// verified email, or provisioned as a new account; the response is the same
+// as POST /login.
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if ssoClient == nil {
+		apierror.Write(w, r, apierror.NotFound("Single sign-on is not configured"))
+		return
+	}
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	q := r.URL.Query()
+	cookie, err := r.Cookie(ssoStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(q.Get("state"))) != 1 {
+		writeTokenError(w, r, ErrInvalidToken)
+		return
+	}
+	setStateCookie(w, r, "", -1)
+	verifier, nonce, err := consumeLoginState(r.Context(), q.Get("state"))
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		apierror.Write(w, r, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "Identity provider rejected the login: "+e))
+		return
+	}
+	if q.Get("code") == "" {
+		apierror.Write(w, r, apierror.BadRequest("Missing authorization code"))
+		return
+	}
+
+	claims, err := ssoClient.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if errors.Is(err, oidc.ErrInvalidToken) {
+		apierror.Write(w, r, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "Identity token is invalid").WithCause(err))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Upstream("Identity provider is unavailable", err))
+		return
+	}
+
+	ip := ratelimit.ClientIP(r)
+	user, err := userForIdentity(r.Context(), ssoClient.Issuer(), claims, ip)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	middleware.SetUserID(r.Context(), user.ID)
+
+	if user.Disabled() {
+		logins.WithLabelValues("disabled").Inc()
+		recordAuthEvent(r.Context(), eventLoginBlocked, user.Username, user.ID, ip)
+		apierror.Write(w, r, errAccountDisabled)
+		return
+	}
+	if user.TwoFactorEnabled {
+		startSecondFactor(w, r, user, ip)
+		return
+	}
+	completeLogin(w, r, user, ip)
+}
+
+// userForIdentity resolves claims to a local account. Existing accounts are
+// only linked by email when both sides have verified the address, so an
+// identity provider cannot be used to take over an unverified signup.
+func userForIdentity(ctx context.Context, issuer string, claims oidc.Claims, ip string) (User, error) {
+	userID, err := identityUserID(ctx, issuer, claims.Subject)
+	if err == nil {
+		return getUserByID(ctx, userID)
+	}
+	if err != sql.ErrNoRows {
+		return User{}, apierror.Internal(err)
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return User{}, apierror.New(http.StatusForbidden, apierror.CodeForbidden, "Identity provider did not supply a verified email address")
+	}
+
+	user, err := getUserByEmail(ctx, claims.Email)
+	switch {
+	case err == nil && user.EmailVerified:
+		if err := linkIdentity(ctx, user.ID, issuer, claims); err != nil {
+			return User{}, apierror.Internal(err)
+		}
+		recordAuthEvent(ctx, eventSSOLinked, user.Username, user.ID, ip)
+		return user, nil
+	case err == nil:
+		return User{}, apierror.Conflict(apierror.CodeEmailTaken, "An account with this email already exists; sign in with its password and verify the address first")
+	case err != sql.ErrNoRows:
+		return User{}, apierror.Internal(err)
+	}
+
+	user, err = provisionSSOUser(ctx, issuer, claims)
+	if err != nil {
+		if errors.Is(err, ErrEmailTaken) {
+			return User{}, apierror.Conflict(apierror.CodeEmailTaken, "Email already registered")
+		}
+		return User{}, apierror.Internal(err)
+	}
+	registrations.Inc()
+	recordAuthEvent(ctx, eventSSOProvisioned, user.Username, user.ID, ip)
+	return user, nil
+}
+
+// provisionSSOUser creates an account without a password for claims. The
+// username is derived from preferred_username or the email's local part,
+// with a numeric suffix when taken.
+func provisionSSOUser(ctx context.Context, issuer string, claims oidc.Claims) (User, error) {
+	base := ssoUsername(claims)
+	for i := 0; i < 10; i++ {
+		username := base
+		if i > 0 {
+			suffix := fmt.Sprintf("%d", i+1)
+			if len(username)+len(suffix) > 50 {
+				username = username[:50-len(suffix)]
+			}
+			username += suffix
+		}
+		user := User{Username: username, Email: claims.Email}
+		err := createSSOUser(ctx, &user, issuer, claims)
+		if errors.Is(err, ErrUsernameTaken) {
+			continue
+		}
+		if err != nil {
+			return User{}, err
+		}
+		return getUserByID(ctx, user.ID)
+	}
+	return User{}, fmt.Errorf("no free username for %q", base)
+}
+
+// ssoUsername reduces the provider's preferred username to the characters
+// allowed by the username rule.
+func ssoUsername(claims oidc.Claims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	var b strings.Builder
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case (c == '.' || c == '_' || c == '-') && b.Len() > 0:
+			b.WriteRune(c)
+		}
+		if b.Len() == 50 {
+			break
+		}
+	}
+	s := b.String()
+	for len(s) < 3 {
+		s += "0"
+	}
+	return s
+}
+
+// setStateCookie sets or, with a negative maxAge, clears the state cookie.
+// Lax lets it ride along on the provider's top-level redirect back.
+func setStateCookie(w http.ResponseWriter, r *http.Request, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		Path:     "/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func saveLoginState(ctx context.Context, state, verifier, nonce string) error {
+	// Opportunistically drop abandoned logins.
+	if _, err := db.ExecContext(ctx, `DELETE FROM oidc_login_states WHERE expires_at <= $1`, now()); err != nil {
+		return err
+	}
+	query := `INSERT INTO oidc_login_states (state_hash, code_verifier, nonce, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err := db.ExecContext(ctx, query, hashToken(state), verifier, nonce, now().Add(ssoStateTTL))
+	return err
+}
+
+// consumeLoginState returns the PKCE verifier and nonce for state. Each state
+// can be used once.
+func consumeLoginState(ctx context.Context, state string) (string, string, error) {
+	if state == "" {
+		return "", "", ErrInvalidToken
+	}
+	var verifier, nonce string
+	query := `
+		DELETE FROM oidc_login_states WHERE state_hash = $1 AND expires_at > $2
+		RETURNING code_verifier, nonce
+	`
+	err := db.QueryRowContext(ctx, query, hashToken(state), now()).Scan(&verifier, &nonce)
+	if err == sql.ErrNoRows {
+		return "", "", ErrInvalidToken
+	}
+	return verifier, nonce, err
+}
+
+// identityUserID returns the account linked to an external identity and
+// records the login against the link.
+func identityUserID(ctx context.Context, issuer, subject string) (int64, error) {
+	var userID int64
+	query := `
+		UPDATE user_identities SET last_login_at = $3
+		WHERE issuer = $1 AND subject = $2
+		RETURNING user_id
+	`
+	err := db.QueryRowContext(ctx, query, issuer, subject, now()).Scan(&userID)
+	return userID, err
+}
+
+func linkIdentity(ctx context.Context, userID int64, issuer string, claims oidc.Claims) error {
+	query := `
+		INSERT INTO user_identities (user_id, issuer, subject, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+	`
+	_, err := db.ExecContext(ctx, query, userID, issuer, claims.Subject, claims.Email, now())
+	return err
+}
+
+// createSSOUser inserts a password-less, email-verified user and its
+// identity link in one transaction.
+func createSSOUser(ctx context.Context, user *User, issuer string, claims oidc.Claims) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users (username, email, password, email_verified_at, display_name)
+		VALUES ($1, $2, '', $3, $4) RETURNING id
+	`
+	err = tx.QueryRowContext(ctx, query, user.Username, user.Email, now(), truncate(claims.Name, 100)).Scan(&user.ID)
+	if err != nil {
+		return translateUniqueViolation(err)
+	}
+	query = `
+		INSERT INTO user_identities (user_id, issuer, subject, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+	`
+	if _, err := tx.ExecContext(ctx, query, user.ID, issuer, claims.Subject, claims.Email, now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
@@ -0,0 +1,314 @@
+package user
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"habit-tracker/user-service/internal/oidc"
+	"habit-tracker/user-service/internal/oidc/mockidp"
+)
+
+const ssoRedirectURL = "http://habits.test/oidc/callback"
+
+// loginState is a row of oidc_login_states.
+type loginState struct {
+	verifier, nonce string
+}
+
+// ssoStore is the login state, identity links and users the SSO statements
+// read and write.
+type ssoStore struct {
+	t          *testing.T
+	states     map[string]loginState // state hash -> row
+	identities map[string]int64      // subject -> user ID
+	users      map[int64]User
+	sessions   int
+	sessionAge time.Duration
+}
+
+func newSSOStore(t *testing.T) *ssoStore {
+	s := &ssoStore{
+		t:          t,
+		states:     make(map[string]loginState),
+		identities: make(map[string]int64),
+		users:      make(map[int64]User),
+	}
+	useFakeDB(t, s.handle)
+	return s
+}
+
+func (s *ssoStore) handle(query string, args []driver.Value) (fakeResult, error) {
+	query = strings.Join(strings.Fields(query), " ")
+	switch {
+	case strings.HasPrefix(query, "DELETE FROM oidc_login_states WHERE expires_at"):
+		return fakeResult{}, nil
+	case strings.HasPrefix(query, "INSERT INTO oidc_login_states"):
+		s.states[args[0].(string)] = loginState{verifier: args[1].(string), nonce: args[2].(string)}
+		return fakeResult{affected: 1}, nil
+	case strings.HasPrefix(query, "DELETE FROM oidc_login_states WHERE state_hash"):
+		hash := args[0].(string)
+		row, ok := s.states[hash]
+		if !ok {
+			return fakeResult{columns: []string{"code_verifier", "nonce"}}, nil
+		}
+		delete(s.states, hash)
+		return fakeResult{
+			columns: []string{"code_verifier", "nonce"},
+			rows:    [][]driver.Value{{row.verifier, row.nonce}},
+		}, nil
+	case strings.HasPrefix(query, "UPDATE user_identities SET last_login_at"):
+		result := fakeResult{columns: []string{"user_id"}}
+		if id, ok := s.identities[args[1].(string)]; ok {
+			result.rows = [][]driver.Value{{id}}
+		}
+		return result, nil
+	case strings.HasPrefix(query, "SELECT "+userColumns+" FROM users WHERE id"):
+		result := fakeResult{columns: strings.Split(userColumns, ", ")}
+		if u, ok := s.users[args[0].(int64)]; ok {
+			result.rows = [][]driver.Value{{u.ID, u.Username, u.Email, u.Password, time.Now(),
+				u.DisplayName, "UTC", "en", "monday", "user", nil, nil, nil}}
+		}
+		return result, nil
+	case strings.HasPrefix(query, "SELECT created_at FROM sessions"):
+		return fakeResult{
+			columns: []string{"created_at"},
+			rows:    [][]driver.Value{{now().Add(-s.sessionAge)}},
+		}, nil
+	case strings.HasPrefix(query, "SELECT EXISTS(SELECT 1 FROM users WHERE username"):
+		return fakeResult{columns: []string{"exists"}, rows: [][]driver.Value{{true}}}, nil
+	case strings.HasPrefix(query, "INSERT INTO sessions"):
+		s.sessions++
+		return fakeResult{affected: 1}, nil
+	case strings.HasPrefix(query, "INSERT INTO auth_events"),
+		strings.HasPrefix(query, "DELETE FROM login_failures"),
+		strings.HasPrefix(query, "UPDATE users SET last_login"):
+		return fakeResult{affected: 1}, nil
+	}
+	s.t.Fatalf("unexpected statement: %s", query)
+	return fakeResult{}, nil
+}
+
+// linkUser adds a password-less account linked to the mock provider's
+// identity for hint.
+func (s *ssoStore) linkUser(id int64, hint string) {
+	s.users[id] = User{ID: id, Username: hint, Email: hint + "@example.com", DisplayName: hint}
+	s.identities[hint] = id
+}
+
+// ssoBrowser drives the login flow against a mock identity provider the way
+// a browser would, one redirect at a time.
+type ssoBrowser struct {
+	t      *testing.T
+	idp    *http.Client
+	cookie *http.Cookie
+}
+
+func newSSOBrowser(t *testing.T) *ssoBrowser {
+	idp, err := mockidp.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.ClientID = "habits"
+	srv := httptest.NewServer(idp.Handler())
+	t.Cleanup(srv.Close)
+	idp.Issuer = srv.URL
+
+	saved := ssoClient
+	t.Cleanup(func() { ssoClient = saved })
+	SetOIDCClient(oidc.NewClient(oidc.Config{
+		Issuer:      srv.URL,
+		ClientID:    "habits",
+		RedirectURL: ssoRedirectURL,
+	}, srv.Client()))
+
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &ssoBrowser{t: t, idp: client}
+}
+
+// login starts a login for hint and follows the provider's redirect,
+// returning the callback query it would send the browser to.
+func (b *ssoBrowser) login(hint string) url.Values {
+	b.t.Helper()
+	rec := httptest.NewRecorder()
+	OIDCLoginHandler(rec, httptest.NewRequest(http.MethodGet, "/oidc/login?login_hint="+hint, nil))
+	if rec.Code != http.StatusFound {
+		b.t.Fatalf("login: status %d: %s", rec.Code, rec.Body)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == ssoStateCookie {
+			b.cookie = c
+		}
+	}
+	if b.cookie == nil || !b.cookie.HttpOnly || b.cookie.SameSite != http.SameSiteLaxMode {
+		b.t.Fatalf("login set state cookie %+v, want an HttpOnly, SameSite=Lax cookie", b.cookie)
+	}
+
+	resp, err := b.idp.Get(rec.Header().Get("Location"))
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(back.String(), ssoRedirectURL) {
+		b.t.Fatalf("provider redirected to %q, want the callback", resp.Header.Get("Location"))
+	}
+	if back.Query().Get("state") != b.cookie.Value {
+		b.t.Fatalf("provider returned state %q, want %q", back.Query().Get("state"), b.cookie.Value)
+	}
+	return back.Query()
+}
+
+// callback delivers query to the callback handler, with the state cookie
+// when cookie is non-nil.
+func (b *ssoBrowser) callback(query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/oidc/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	OIDCCallbackHandler(rec, req)
+	return rec
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return body.Error.Code
+}
+
+func TestSSOCodeFlow(t *testing.T) {
+	store := newSSOStore(t)
+	store.linkUser(7, "alice")
+	browser := newSSOBrowser(t)
+
+	query := browser.login("alice")
+	rec := browser.callback(query, browser.cookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d: %s", rec.Code, rec.Body)
+	}
+	var resp LoginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" || resp.User.ID != 7 || store.sessions != 1 {
+		t.Fatalf("callback = %+v with %d sessions, want a session for user 7", resp, store.sessions)
+	}
+	cleared := false
+	for _, c := range rec.Result().Cookies() {
+		cleared = cleared || (c.Name == ssoStateCookie && c.MaxAge < 0)
+	}
+	if !cleared {
+		t.Error("callback did not clear the state cookie")
+	}
+
+	// The state is spent; replaying the callback fails.
+	rec = browser.callback(query, browser.cookie)
+	if rec.Code != http.StatusBadRequest || errorCode(t, rec) != "invalid_token" {
+		t.Errorf("replayed callback: status %d: %s", rec.Code, rec.Body)
+	}
+	if store.sessions != 1 {
+		t.Errorf("replayed callback created a session")
+	}
+}
+
+// TestSSOCallbackRequiresStateCookie covers login CSRF: a callback for a
+// login started in another browser must not sign this one in.
+func TestSSOCallbackRequiresStateCookie(t *testing.T) {
+	store := newSSOStore(t)
+	store.linkUser(7, "mallory")
+	browser := newSSOBrowser(t)
+
+	attacker := browser.login("mallory")
+	victim := browser.login("mallory")
+	for name, cookie := range map[string]*http.Cookie{
+		"no cookie":          nil,
+		"another login's":    browser.cookie,
+		"empty state cookie": {Name: ssoStateCookie, Value: ""},
+	} {
+		rec := browser.callback(attacker, cookie)
+		if rec.Code != http.StatusBadRequest || errorCode(t, rec) != "invalid_token" {
+			t.Errorf("%s: status %d: %s", name, rec.Code, rec.Body)
+		}
+	}
+	if store.sessions != 0 {
+		t.Fatalf("%d sessions created without a matching state cookie", store.sessions)
+	}
+
+	// A rejected callback leaves the login it names usable by its owner.
+	if rec := browser.callback(victim, browser.cookie); rec.Code != http.StatusOK {
+		t.Errorf("the victim's own callback: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSSOCallbackChecksPKCEAndNonce(t *testing.T) {
+	store := newSSOStore(t)
+	store.linkUser(7, "alice")
+	browser := newSSOBrowser(t)
+
+	tamper := func(change func(*loginState)) {
+		for hash, row := range store.states {
+			change(&row)
+			store.states[hash] = row
+		}
+	}
+
+	// A verifier that does not match the challenge is refused by the
+	// provider, so an intercepted code cannot be redeemed.
+	query := browser.login("alice")
+	tamper(func(row *loginState) { row.verifier += "x" })
+	if rec := browser.callback(query, browser.cookie); rec.Code != http.StatusBadGateway {
+		t.Errorf("wrong verifier: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// An ID token minted for another login's nonce is rejected.
+	query = browser.login("alice")
+	tamper(func(row *loginState) { row.nonce = "other" })
+	rec := browser.callback(query, browser.cookie)
+	if rec.Code != http.StatusUnauthorized || errorCode(t, rec) != "invalid_token" {
+		t.Errorf("wrong nonce: status %d: %s", rec.Code, rec.Body)
+	}
+
+	if store.sessions != 0 {
+		t.Errorf("%d sessions created", store.sessions)
+	}
+}
+
+// TestConfirmCurrentPasswordWithoutPassword covers accounts provisioned by
+// SSO: a recent sign-in stands in for the password they do not have.
+func TestConfirmCurrentPasswordWithoutPassword(t *testing.T) {
+	store := newSSOStore(t)
+	setClock(t, time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	user := User{ID: 7, Username: "alice"}
+
+	for _, tt := range []struct {
+		age  time.Duration
+		want bool
+	}{
+		{time.Minute, true},
+		{reauthWindow - time.Second, true},
+		{reauthWindow, false},
+		{time.Hour, false},
+	} {
+		store.sessionAge = tt.age
+		rec := httptest.NewRecorder()
+		got := confirmCurrentPassword(rec, httptest.NewRequest(http.MethodPost, "/me/password", nil), user, 1, "")
+		if got != tt.want {
+			t.Errorf("session %v old: confirmed = %v, want %v", tt.age, got, tt.want)
+		}
+		if !got && rec.Code != http.StatusForbidden {
+			t.Errorf("session %v old: status %d, want 403", tt.age, rec.Code)
+		}
+	}
+}
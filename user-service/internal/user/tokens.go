@@ -0,0 +1,111 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Token purposes stored in user_tokens.
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposePasswordReset = "password_reset"
+	purposeChangeEmail   = "change_email"
+)
+
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	passwordResetTTL = time.Hour
+)
+
+// ErrInvalidToken is returned for unknown, expired or already used tokens.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// newToken returns a random URL-safe token and the hash that is stored.
+// Only the hash is persisted, so a database leak does not expose live tokens.
+func newToken() (token, hash string, err error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b[:])
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueToken creates a single-use token for userID. email records the
+// address a verification token was sent to and is empty for other purposes.
+func issueToken(ctx context.Context, userID int64, purpose, email string, ttl time.Duration) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	query := `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, email, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err = db.ExecContext(ctx, query, userID, purpose, hash, sql.NullString{String: email, Valid: email != ""}, now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// redeemedToken is what a consumed token was issued for.
+type redeemedToken struct {
+	UserID  int64
+	Email   string
+	Purpose string
+}
+
+// consumeToken marks a live token with one of purposes as used. The single
+// UPDATE makes concurrent redemptions race-free.
+func consumeToken(ctx context.Context, token string, purposes ...string) (redeemedToken, error) {
+	var t redeemedToken
+	var email sql.NullString
+	query := `
+		UPDATE user_tokens SET used_at = $3
+		WHERE token_hash = $1 AND purpose = ANY($2) AND used_at IS NULL AND expires_at > $3
+		RETURNING user_id, email, purpose
+	`
+	err := db.QueryRowContext(ctx, query, hashToken(token), pq.Array(purposes), now()).Scan(&t.UserID, &email, &t.Purpose)
+	if err == sql.ErrNoRows {
+		return redeemedToken{}, ErrInvalidToken
+	}
+	t.Email = email.String
+	return t, err
+}
+
+// lookupToken returns a live token without consuming it.
+func lookupToken(ctx context.Context, token, purpose string) (redeemedToken, error) {
+	t := redeemedToken{Purpose: purpose}
+	var email sql.NullString
+	query := `
+		SELECT user_id, email FROM user_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+	`
+	err := db.QueryRowContext(ctx, query, hashToken(token), purpose, now()).Scan(&t.UserID, &email)
+	if err == sql.ErrNoRows {
+		return redeemedToken{}, ErrInvalidToken
+	}
+	t.Email = email.String
+	return t, err
+}
+
+// revokeTokens invalidates every outstanding token of purpose for userID.
+func revokeTokens(ctx context.Context, userID int64, purpose string) error {
+	query := `UPDATE user_tokens SET used_at = $3 WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	_, err := db.ExecContext(ctx, query, userID, purpose, now())
+	return err
+}
@@ -0,0 +1,477 @@
+package user
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/env"
+	"habit-tracker/pkg/middleware"
+	"habit-tracker/pkg/ratelimit"
+	"habit-tracker/pkg/validate"
+	"habit-tracker/user-service/internal/totp"
+)
+
+const (
+	purposeMFAChallenge = "mfa_challenge"
+	mfaChallengeTTL     = 5 * time.Minute
+	recoveryCodeCount   = 10
+)
+
+// totpIssuer is the account issuer shown in authenticator apps.
+var totpIssuer = env.String("TOTP_ISSUER", "Habit Tracker")
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpAEAD encrypts TOTP secrets at rest. Unlike passwords and recovery
+// codes they cannot be hashed: the server needs them to compute codes.
+var totpAEAD cipher.AEAD
+
+// sealedPrefix marks an encrypted secret. Plain base32 secrets, stored
+// before a key was configured, never contain a colon.
+const sealedPrefix = "v1:"
+
+// SetTOTPEncryptionKey sets the base64-encoded 256-bit key TOTP secrets are
+// encrypted with (AES-GCM). Without one, secrets are stored in plaintext.
+func SetTOTPEncryptionKey(key string) error {
+	if key == "" {
+		slog.Warn("TOTP_ENCRYPTION_KEY is not set; two-factor secrets are stored unencrypted")
+		totpAEAD = nil
+		return nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("decode key: %w", err)
+	}
+	if len(raw) != 32 {
+		return fmt.Errorf("key must be 32 bytes, got %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return err
+	}
+	totpAEAD, err = cipher.NewGCM(block)
+	return err
+}
+
+// sealTOTPSecret encrypts secret for storage, bound to userID so it cannot
+// be copied to another account.
+func sealTOTPSecret(userID int64, secret string) (string, error) {
+	if totpAEAD == nil {
+		return secret, nil
+	}
+	nonce := make([]byte, totpAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := totpAEAD.Seal(nonce, nonce, []byte(secret), []byte(fmt.Sprint(userID)))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+var errTOTPKey = errors.New("two-factor secret is encrypted but TOTP_ENCRYPTION_KEY is not set")
+
+// openTOTPSecret reverses sealTOTPSecret. Plaintext secrets pass through.
+func openTOTPSecret(userID int64, stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		return stored, nil
+	}
+	if totpAEAD == nil {
+		return "", errTOTPKey
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil || len(sealed) < totpAEAD.NonceSize() {
+		return "", fmt.Errorf("decode two-factor secret: %w", err)
+	}
+	n := totpAEAD.NonceSize()
+	secret, err := totpAEAD.Open(nil, sealed[:n], sealed[n:], []byte(fmt.Sprint(userID)))
+	if err != nil {
+		return "", fmt.Errorf("decrypt two-factor secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+// newRecoveryCode returns an 80-bit code formatted as four groups of four
+// characters, e.g. "k3m9-q2xa-7fpd-h4we".
+func newRecoveryCode() (string, error) {
+	var b [10]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(recoveryEncoding.EncodeToString(b[:]))
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// normalizeRecoveryCode lets users type codes with or without dashes,
+// spaces or capitals.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 16 {
+		return code
+	}
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+}
+
+func TwoFactorSetupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	user, sessionID, err := authenticate(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	middleware.SetUserID(r.Context(), user.ID)
+
+	var req TwoFactorSetupRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if !confirmCurrentPassword(w, r, user, sessionID, req.CurrentPassword) {
+		return
+	}
+	if user.TwoFactorEnabled {
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "Two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if err := setPendingTOTP(r.Context(), user.ID, secret); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(TwoFactorSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(totpIssuer, user.Username, secret),
+	})
+}
+
+// TwoFactorConfirmHandler enables two-factor authentication once the user
+// proves their authenticator produces valid codes, and returns the recovery
+// codes. They are shown only this once.
+func TwoFactorConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	user, _, err := authenticate(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	middleware.SetUserID(r.Context(), user.ID)
+
+	var req TwoFactorConfirmRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if user.TwoFactorEnabled {
+		apierror.Write(w, r, apierror.Conflict(apierror.CodeConflict, "Two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, _, err := totpState(r.Context(), user.ID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if secret == "" {
+		apierror.Write(w, r, apierror.BadRequest("Start two-factor setup first"))
+		return
+	}
+	step, ok := totp.Validate(secret, req.Code, now())
+	if !ok {
+		writeInvalidCode(w, r)
+		return
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+	}
+	if err := enableTOTP(r.Context(), user.ID, step, codes); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	notify(r.Context(), user.Email, "Two-factor authentication enabled",
+		fmt.Sprintf("Hi %s,\n\nTwo-factor authentication is now enabled on your account. Keep your recovery codes somewhere safe.\n", user.Username))
+
+	json.NewEncoder(w).Encode(TwoFactorConfirmResponse{
+		Message:       "Two-factor authentication enabled",
+		RecoveryCodes: codes,
+	})
+}
+
+func TwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	user, sessionID, err := authenticate(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	middleware.SetUserID(r.Context(), user.ID)
+
+	var req TwoFactorDisableRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if !confirmCurrentPassword(w, r, user, sessionID, req.CurrentPassword) {
+		return
+	}
+	if !user.TwoFactorEnabled {
+		apierror.Write(w, r, apierror.BadRequest("Two-factor authentication is not enabled"))
+		return
+	}
+	ok, err := verifySecondFactor(r.Context(), user.ID, req.Code)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if !ok {
+		writeInvalidCode(w, r)
+		return
+	}
+
+	if err := disableTOTP(r.Context(), user.ID); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	notify(r.Context(), user.Email, "Two-factor authentication disabled",
+		fmt.Sprintf("Hi %s,\n\nTwo-factor authentication was turned off for your account. If this was not you, change your password.\n", user.Username))
+
+	json.NewEncoder(w).Encode(MessageResponse{Message: "Two-factor authentication disabled"})
+}
+
+// startSecondFactor answers a correct password on a 2FA account with a
+// short-lived challenge token to present to POST /login/2fa.
+func startSecondFactor(w http.ResponseWriter, r *http.Request, user User, ip string) {
+	token, err := issueToken(r.Context(), user.ID, purposeMFAChallenge, "", mfaChallengeTTL)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	recordAuthEvent(r.Context(), eventMFAChallenged, user.Username, user.ID, ip)
+	logins.WithLabelValues("mfa_required").Inc()
+
+	json.NewEncoder(w).Encode(MFAChallengeResponse{
+		Message:     "Two-factor authentication required",
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresAt:   now().Add(mfaChallengeTTL),
+	})
+}
+
+// LoginSecondFactorHandler completes a login with a TOTP or recovery code.
+// Wrong codes count towards the same lockout as wrong passwords.
+func LoginSecondFactorHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	var req LoginSecondFactorRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	ip := ratelimit.ClientIP(r)
+
+	challenge, err := lookupToken(r.Context(), req.MFAToken, purposeMFAChallenge)
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+	user, err := getUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	middleware.SetUserID(r.Context(), user.ID)
+
+	until, err := lockedUntil(r.Context(), user.Username)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if !until.IsZero() {
+		recordAuthEvent(r.Context(), eventLoginBlocked, user.Username, user.ID, ip)
+		writeLocked(w, r, until)
+		return
+	}
+	if user.Disabled() {
+		apierror.Write(w, r, errAccountDisabled)
+		return
+	}
+
+	ok, err := verifySecondFactor(r.Context(), user.ID, req.Code)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if !ok {
+		logins.WithLabelValues("invalid_second_factor").Inc()
+		recordAuthEvent(r.Context(), eventMFAFailed, user.Username, user.ID, ip)
+		until, err := recordLoginFailure(r.Context(), user.Username)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal(err))
+			return
+		}
+		if !until.IsZero() {
+			recordAuthEvent(r.Context(), eventAccountLocked, user.Username, user.ID, ip)
+		}
+		writeInvalidCode(w, r)
+		return
+	}
+
+	// Single use: a concurrent request with the same challenge loses here.
+	if _, err := consumeToken(r.Context(), req.MFAToken, purposeMFAChallenge); err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+
+	completeLogin(w, r, user, ip)
+}
+
+func writeInvalidCode(w http.ResponseWriter, r *http.Request) {
+	apierror.Write(w, r, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid authentication code"))
+}
+
+// verifySecondFactor accepts a current TOTP code that has not been used
+// before, or an unused recovery code, which is then spent.
+func verifySecondFactor(ctx context.Context, userID int64, code string) (bool, error) {
+	secret, lastStep, err := totpState(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if step, ok := totp.Validate(secret, code, now()); ok && secret != "" {
+		if step <= lastStep {
+			return false, nil
+		}
+		return acceptTOTPStep(ctx, userID, step)
+	}
+	return useRecoveryCode(ctx, userID, normalizeRecoveryCode(code))
+}
+
+func setPendingTOTP(ctx context.Context, userID int64, secret string) error {
+	sealed, err := sealTOTPSecret(userID, secret)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE users SET totp_secret = $2 WHERE id = $1 AND totp_enabled_at IS NULL`
+	_, err = db.ExecContext(ctx, query, userID, sealed)
+	return err
+}
+
+// totpState returns the (possibly pending) secret and the last accepted step.
+func totpState(ctx context.Context, userID int64) (string, int64, error) {
+	var stored sql.NullString
+	var lastStep int64
+	query := `SELECT totp_secret, totp_last_step FROM users WHERE id = $1`
+	if err := db.QueryRowContext(ctx, query, userID).Scan(&stored, &lastStep); err != nil {
+		return "", 0, err
+	}
+	secret, err := openTOTPSecret(userID, stored.String)
+	return secret, lastStep, err
+}
+
+// acceptTOTPStep records step as used, failing if an equal or later step was
+// already accepted by a concurrent request.
+func acceptTOTPStep(ctx context.Context, userID, step int64) (bool, error) {
+	query := `UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2`
+	result, err := db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func useRecoveryCode(ctx context.Context, userID int64, code string) (bool, error) {
+	query := `UPDATE recovery_codes SET used_at = $3 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	result, err := db.ExecContext(ctx, query, userID, hashToken(code), now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// enableTOTP turns on 2FA and replaces the recovery codes in one transaction.
+func enableTOTP(ctx context.Context, userID, step int64, codes []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET totp_enabled_at = $2, totp_last_step = $3 WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, userID, now(), step); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		query := `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(ctx, query, userID, hashToken(code)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func disableTOTP(ctx context.Context, userID int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,219 @@
+package user
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"habit-tracker/user-service/internal/totp"
+)
+
+const testSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// twoFactorStore is the users and recovery_codes state the two-factor
+// statements read and write.
+type twoFactorStore struct {
+	t        *testing.T
+	secret   string
+	lastStep int64
+	codes    map[string]bool // code hash -> used
+}
+
+func newTwoFactorStore(t *testing.T, recoveryCodes ...string) *twoFactorStore {
+	s := &twoFactorStore{t: t, secret: testSecret, codes: make(map[string]bool)}
+	for _, code := range recoveryCodes {
+		s.codes[hashToken(code)] = false
+	}
+	useFakeDB(t, s.handle)
+	return s
+}
+
+func (s *twoFactorStore) handle(query string, args []driver.Value) (fakeResult, error) {
+	query = strings.Join(strings.Fields(query), " ")
+	switch {
+	case strings.HasPrefix(query, "SELECT totp_secret, totp_last_step FROM users"):
+		return fakeResult{
+			columns: []string{"totp_secret", "totp_last_step"},
+			rows:    [][]driver.Value{{s.secret, s.lastStep}},
+		}, nil
+	case strings.HasPrefix(query, "UPDATE users SET totp_secret = $2"):
+		s.secret = args[1].(string)
+		return fakeResult{affected: 1}, nil
+	case strings.HasPrefix(query, "UPDATE users SET totp_last_step = $2"):
+		// WHERE totp_last_step < $2
+		if step := args[1].(int64); s.lastStep < step {
+			s.lastStep = step
+			return fakeResult{affected: 1}, nil
+		}
+		return fakeResult{}, nil
+	case strings.HasPrefix(query, "UPDATE recovery_codes SET used_at"):
+		// WHERE code_hash = $2 AND used_at IS NULL
+		hash := args[1].(string)
+		if used, ok := s.codes[hash]; ok && !used {
+			s.codes[hash] = true
+			return fakeResult{affected: 1}, nil
+		}
+		return fakeResult{}, nil
+	}
+	s.t.Fatalf("unexpected statement: %s", query)
+	return fakeResult{}, nil
+}
+
+// setClock fixes now for the rest of the test.
+func setClock(t *testing.T, at time.Time) {
+	saved := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = saved })
+}
+
+func TestVerifySecondFactorRejectsReplay(t *testing.T) {
+	ctx := context.Background()
+	store := newTwoFactorStore(t)
+	clock := time.Unix(1111111111, 0)
+	setClock(t, clock)
+
+	code, _ := totp.Code(testSecret, clock)
+	if ok, err := verifySecondFactor(ctx, 1, code); err != nil || !ok {
+		t.Fatalf("first use = %v, %v; want accepted", ok, err)
+	}
+	if store.lastStep != totp.Step(clock) {
+		t.Fatalf("last step = %d, want %d", store.lastStep, totp.Step(clock))
+	}
+	if ok, _ := verifySecondFactor(ctx, 1, code); ok {
+		t.Error("the same code was accepted twice")
+	}
+
+	// The previous step's code is still within the skew, but older than
+	// the one accepted.
+	previous, _ := totp.CodeAt(testSecret, totp.Step(clock)-1)
+	if ok, _ := verifySecondFactor(ctx, 1, previous); ok {
+		t.Error("a code older than the last accepted one was accepted")
+	}
+
+	setClock(t, clock.Add(totp.Period))
+	next, _ := totp.Code(testSecret, clock.Add(totp.Period))
+	if ok, err := verifySecondFactor(ctx, 1, next); err != nil || !ok {
+		t.Errorf("next step's code = %v, %v; want accepted", ok, err)
+	}
+}
+
+func TestAcceptTOTPStep(t *testing.T) {
+	ctx := context.Background()
+	store := newTwoFactorStore(t)
+	store.lastStep = 100
+
+	for _, tt := range []struct {
+		step int64
+		want bool
+	}{
+		{99, false},
+		{100, false},
+		{101, true},
+		// A concurrent request that validated the same code loses.
+		{101, false},
+		{103, true},
+	} {
+		ok, err := acceptTOTPStep(ctx, 1, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.want {
+			t.Errorf("acceptTOTPStep(%d) = %v, want %v", tt.step, ok, tt.want)
+		}
+	}
+	if store.lastStep != 103 {
+		t.Errorf("last step = %d, want 103", store.lastStep)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	ctx := context.Background()
+	newTwoFactorStore(t, "k3m9-q2xa-7fpd-h4we", "aaaa-bbbb-cccc-dddd")
+	setClock(t, time.Unix(1111111111, 0))
+
+	if ok, err := verifySecondFactor(ctx, 1, "K3M9 Q2XA 7FPD H4WE"); err != nil || !ok {
+		t.Fatalf("first use = %v, %v; want accepted", ok, err)
+	}
+	if ok, _ := verifySecondFactor(ctx, 1, "k3m9-q2xa-7fpd-h4we"); ok {
+		t.Error("a spent recovery code was accepted again")
+	}
+	if ok, _ := verifySecondFactor(ctx, 1, "zzzz-zzzz-zzzz-zzzz"); ok {
+		t.Error("an unknown recovery code was accepted")
+	}
+	if ok, _ := verifySecondFactor(ctx, 1, "aaaabbbbccccdddd"); !ok {
+		t.Error("the other recovery code was not accepted")
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for in, want := range map[string]string{
+		"k3m9-q2xa-7fpd-h4we": "k3m9-q2xa-7fpd-h4we",
+		"K3M9Q2XA7FPDH4WE":    "k3m9-q2xa-7fpd-h4we",
+		"k3m9 q2xa 7fpd h4we": "k3m9-q2xa-7fpd-h4we",
+		"k3m9-q2xa":           "k3m9q2xa",
+	} {
+		if got := normalizeRecoveryCode(in); got != want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func useTOTPKey(t *testing.T, key string) {
+	t.Helper()
+	saved := totpAEAD
+	t.Cleanup(func() { totpAEAD = saved })
+	if err := SetTOTPEncryptionKey(key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var testTOTPKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+func TestTOTPSecretIsEncryptedAtRest(t *testing.T) {
+	ctx := context.Background()
+	useTOTPKey(t, testTOTPKey)
+	store := newTwoFactorStore(t)
+
+	if err := setPendingTOTP(ctx, 7, testSecret); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(store.secret, sealedPrefix) || strings.Contains(store.secret, testSecret) {
+		t.Fatalf("stored secret %q is not encrypted", store.secret)
+	}
+	secret, _, err := totpState(ctx, 7)
+	if err != nil || secret != testSecret {
+		t.Fatalf("totpState() = %q, %v; want the original secret", secret, err)
+	}
+
+	// The ciphertext is bound to the account it was stored for.
+	if _, err := openTOTPSecret(8, store.secret); err == nil {
+		t.Error("a secret sealed for one user opened for another")
+	}
+
+	// Secrets stored before a key was configured still work.
+	if got, err := openTOTPSecret(7, testSecret); err != nil || got != testSecret {
+		t.Errorf("openTOTPSecret(plaintext) = %q, %v", got, err)
+	}
+
+	sealed := store.secret
+	useTOTPKey(t, "")
+	if _, err := openTOTPSecret(7, sealed); err != errTOTPKey {
+		t.Errorf("openTOTPSecret without a key: err = %v, want errTOTPKey", err)
+	}
+}
+
+func TestSetTOTPEncryptionKeyRejectsBadKeys(t *testing.T) {
+	saved := totpAEAD
+	t.Cleanup(func() { totpAEAD = saved })
+	for _, key := range []string{
+		"not base64!",
+		base64.StdEncoding.EncodeToString([]byte("too short")),
+	} {
+		if err := SetTOTPEncryptionKey(key); err == nil {
+			t.Errorf("SetTOTPEncryptionKey(%q) succeeded", key)
+		}
+	}
+}
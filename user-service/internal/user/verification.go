@@ -0,0 +1,210 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"habit-tracker/pkg/apierror"
+	"habit-tracker/pkg/env"
+	"habit-tracker/pkg/validate"
+	"habit-tracker/user-service/internal/mail"
+)
+
+// appBaseURL is the front-end origin used to build links in emails.
+var appBaseURL = env.String("APP_BASE_URL", "http://localhost:3000")
+
+// mailer delivers verification and reset emails.
+var mailer mail.Sender = &mail.FileSender{Dir: "outbox"}
+
+// SetMailer replaces the email sender.
+func SetMailer(s mail.Sender) {
+	mailer = s
+}
+
+func link(path, token string) string {
+	return appBaseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendVerificationEmail issues a token for user's current address and mails
+// it. Failures are logged; the user can still sign in unverified.
+func sendVerificationEmail(ctx context.Context, user User) {
+	token, err := issueToken(ctx, user.ID, purposeVerifyEmail, user.Email, verifyEmailTTL)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to issue verification token", "user_id", user.ID, "error", err)
+		return
+	}
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening the link below within 24 hours:\n\n%s\n\nVerification code: %s\n",
+			user.Username, link("/verify-email", token), token),
+	}
+	if err := mailer.Send(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "failed to send verification email", "user_id", user.ID, "error", err)
+	}
+}
+
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	var req VerifyEmailRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	token, err := consumeToken(r.Context(), req.Token, purposeVerifyEmail, purposeChangeEmail)
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+
+	if token.Purpose == purposeChangeEmail {
+		confirmEmailChange(w, r, token)
+		return
+	}
+
+	// The token only verifies the address it was sent to.
+	ok, err := markEmailVerified(r.Context(), token.UserID, token.Email)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if !ok {
+		writeTokenError(w, r, ErrInvalidToken)
+		return
+	}
+
+	json.NewEncoder(w).Encode(MessageResponse{Message: "Email verified"})
+}
+
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	var req ForgotPasswordRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	// Always answer the same way, and before looking the address up, so
+	// neither the response nor its timing reveals which addresses are
+	// registered.
+	goBackground(r.Context(), func(ctx context.Context) { requestPasswordReset(ctx, req.Email) })
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(MessageResponse{Message: "If the address is registered, a reset link has been sent"})
+}
+
+// requestPasswordReset mails a reset link if email belongs to an account.
+// It runs after the response has been sent, so failures are only logged.
+func requestPasswordReset(ctx context.Context, email string) {
+	user, err := getUserByEmail(ctx, email)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to look up password reset address", "error", err)
+		return
+	}
+	sendPasswordResetEmail(ctx, user)
+}
+
+func sendPasswordResetEmail(ctx context.Context, user User) {
+	token, err := issueToken(ctx, user.ID, purposePasswordReset, "", passwordResetTTL)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to issue password reset token", "user_id", user.ID, "error", err)
+		return
+	}
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset your password. If it was you, open the link below within one hour:\n\n%s\n\nReset code: %s\n\nIf it was not you, you can ignore this email.\n",
+			user.Username, link("/reset-password", token), token),
+	}
+	if err := mailer.Send(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "failed to send password reset email", "user_id", user.ID, "error", err)
+	}
+}
+
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed())
+		return
+	}
+
+	var req ResetPasswordRequest
+	if err := validate.DecodeJSON(w, r, &req, maxBodyBytes); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	token, err := consumeToken(r.Context(), req.Token, purposePasswordReset)
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+	userID := token.UserID
+
+	user, err := getUserByID(r.Context(), userID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if err := upgradePassword(r.Context(), userID, req.Password); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	// Any other outstanding reset links die with this one, and a lockout
+	// caused by the forgotten password no longer applies.
+	if err := revokeTokens(r.Context(), userID, purposePasswordReset); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	if err := clearLoginFailures(r.Context(), user.Username); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+	// Whoever knew the old password is signed out everywhere.
+	if err := revokeUserSessions(r.Context(), userID, 0); err != nil {
+		apierror.Write(w, r, apierror.Internal(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(MessageResponse{Message: "Password has been reset"})
+}
+
+// upgradePassword hashes password and stores it for userID.
+func upgradePassword(ctx context.Context, userID int64, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return setPassword(ctx, userID, hash)
+}
+
+func writeTokenError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrInvalidToken) {
+		apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.CodeInvalidToken, "Token is invalid or has expired"))
+		return
+	}
+	apierror.Write(w, r, apierror.Internal(err))
+}
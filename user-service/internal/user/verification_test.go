@@ -0,0 +1,188 @@
+package user
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"habit-tracker/user-service/internal/mail"
+)
+
+// resetToken is a row of user_tokens.
+type resetToken struct {
+	userID  int64
+	purpose string
+	used    bool
+}
+
+// resetStore is the users and user_tokens state the password reset
+// statements read and write. Lookups by email wait for lookups to be
+// closed, so a test can tell what happens before the database answers, and
+// report the address on answered once they have.
+type resetStore struct {
+	t         *testing.T
+	users     map[string]User // email -> user
+	tokens    map[string]*resetToken
+	passwords map[int64]string
+	lookups   chan struct{}
+	answered  chan string
+}
+
+func newResetStore(t *testing.T, users ...User) *resetStore {
+	s := &resetStore{
+		t:         t,
+		users:     make(map[string]User),
+		tokens:    make(map[string]*resetToken),
+		passwords: make(map[int64]string),
+		lookups:   make(chan struct{}),
+		answered:  make(chan string, 10),
+	}
+	for _, u := range users {
+		s.users[u.Email] = u
+	}
+	useFakeDB(t, s.handle)
+	return s
+}
+
+func (s *resetStore) user(u User) [][]driver.Value {
+	return [][]driver.Value{{u.ID, u.Username, u.Email, u.Password, nil,
+		"", "UTC", "en", "monday", "user", nil, nil, nil}}
+}
+
+func (s *resetStore) handle(query string, args []driver.Value) (fakeResult, error) {
+	query = strings.Join(strings.Fields(query), " ")
+	columns := strings.Split(userColumns, ", ")
+	switch {
+	case strings.HasPrefix(query, "SELECT "+userColumns+" FROM users WHERE LOWER(email)"):
+		<-s.lookups
+		defer func() { s.answered <- args[0].(string) }()
+		if u, ok := s.users[args[0].(string)]; ok {
+			return fakeResult{columns: columns, rows: s.user(u)}, nil
+		}
+		return fakeResult{columns: columns}, nil
+	case strings.HasPrefix(query, "SELECT "+userColumns+" FROM users WHERE id"):
+		for _, u := range s.users {
+			if u.ID == args[0].(int64) {
+				return fakeResult{columns: columns, rows: s.user(u)}, nil
+			}
+		}
+		return fakeResult{columns: columns}, nil
+	case strings.HasPrefix(query, "INSERT INTO user_tokens"):
+		s.tokens[args[2].(string)] = &resetToken{userID: args[0].(int64), purpose: args[1].(string)}
+		return fakeResult{affected: 1}, nil
+	case strings.HasPrefix(query, "UPDATE user_tokens SET used_at = $3 WHERE token_hash"):
+		result := fakeResult{columns: []string{"user_id", "email", "purpose"}}
+		if tok, ok := s.tokens[args[0].(string)]; ok && !tok.used {
+			tok.used = true
+			result.rows = [][]driver.Value{{tok.userID, nil, tok.purpose}}
+		}
+		return result, nil
+	case strings.HasPrefix(query, "UPDATE user_tokens SET used_at = $3 WHERE user_id"):
+		for _, tok := range s.tokens {
+			if tok.userID == args[0].(int64) && tok.purpose == args[1].(string) {
+				tok.used = true
+			}
+		}
+		return fakeResult{}, nil
+	case strings.HasPrefix(query, "UPDATE users SET password"):
+		s.passwords[args[0].(int64)] = args[1].(string)
+		return fakeResult{affected: 1}, nil
+	case strings.HasPrefix(query, "DELETE FROM login_failures"),
+		strings.HasPrefix(query, "UPDATE sessions SET revoked_at"):
+		return fakeResult{}, nil
+	}
+	s.t.Fatalf("unexpected statement: %s", query)
+	return fakeResult{}, nil
+}
+
+func useOutbox(t *testing.T) *mail.Outbox {
+	saved := mailer
+	t.Cleanup(func() { mailer = saved })
+	outbox := &mail.Outbox{}
+	SetMailer(outbox)
+	return outbox
+}
+
+// waitForMail polls outbox until addr has been sent a message.
+func waitForMail(t *testing.T, outbox *mail.Outbox, addr string) mail.Message {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if msg, ok := outbox.Last(addr); ok {
+			return msg
+		}
+	}
+	t.Fatalf("no email sent to %s", addr)
+	return mail.Message{}
+}
+
+func forgotPassword(email string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/password/forgot", strings.NewReader(`{"email": "`+email+`"}`))
+	ForgotPasswordHandler(rec, req)
+	return rec
+}
+
+// TestForgotPasswordDoesNotRevealAccounts checks that known and unknown
+// addresses get the same answer, and get it before the address is looked
+// up, so response times do not depend on whether an account exists.
+func TestForgotPasswordDoesNotRevealAccounts(t *testing.T) {
+	outbox := useOutbox(t)
+	store := newResetStore(t, User{ID: 7, Username: "alice", Email: "alice@example.com"})
+
+	known := forgotPassword("alice@example.com")
+	unknown := forgotPassword("nobody@example.com")
+	for _, rec := range []*httptest.ResponseRecorder{known, unknown} {
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	if known.Body.String() != unknown.Body.String() {
+		t.Errorf("responses differ: %s vs %s", known.Body, unknown.Body)
+	}
+
+	// Both lookups are still waiting on the database.
+	close(store.lookups)
+	<-store.answered
+	<-store.answered
+	waitForMail(t, outbox, "alice@example.com")
+	if n := len(outbox.Messages()); n != 1 {
+		t.Errorf("%d emails sent, want only the one to the registered address", n)
+	}
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	outbox := useOutbox(t)
+	store := newResetStore(t, User{ID: 7, Username: "alice", Email: "alice@example.com"})
+	close(store.lookups)
+
+	forgotPassword("alice@example.com")
+	<-store.answered
+	msg := waitForMail(t, outbox, "alice@example.com")
+	if msg.Subject != "Reset your password" || !strings.Contains(msg.Body, "Hi alice,") {
+		t.Errorf("email = %+v", msg)
+	}
+	code := regexp.MustCompile(`Reset code: (\S+)`).FindStringSubmatch(msg.Body)
+	if code == nil || !strings.Contains(msg.Body, link("/reset-password", code[1])) {
+		t.Fatalf("email has no reset code and link: %s", msg.Body)
+	}
+
+	reset := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := `{"token": "` + code[1] + `", "password": "n3w-Passw0rd!"}`
+		ResetPasswordHandler(rec, httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader(body)))
+		return rec
+	}
+	if rec := reset(); rec.Code != http.StatusOK {
+		t.Fatalf("reset: status %d: %s", rec.Code, rec.Body)
+	}
+	if !passwordMatches(store.passwords[7], "n3w-Passw0rd!") {
+		t.Error("reset did not store the new password")
+	}
+	if rec := reset(); rec.Code != http.StatusBadRequest {
+		t.Errorf("second reset with the same token: status %d, want 400", rec.Code)
+	}
+}